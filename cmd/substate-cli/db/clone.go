@@ -3,7 +3,9 @@ package db
 import (
 	"fmt"
 	"strconv"
+	"sync/atomic"
 
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
@@ -16,6 +18,13 @@ var CloneCommand = cli.Command{
 	ArgsUsage: "<srcPath> <dstPath> <blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&TouchFlag,
+		&StatusFlag,
+		&TxTypeFlag,
+		&OpCodeFlag,
 	},
 	Description: `
 The substate-cli db clone command requires four arguments:
@@ -23,7 +32,14 @@ The substate-cli db clone command requires four arguments:
 <srcPath> is the original substate database to read the information.
 <dstPath> is the target substate database to write the information
 <blockNumFirst> and <blockNumLast> are the first and
-last block of the inclusive range of blocks to clone.`,
+last block of the inclusive range of blocks to clone.
+
+Filter flags restrict the clone to the transactions matching all of them:
+    --touch <addr,...>    sends from, sends to or accesses one of the addresses
+    --status <status>     ends with status successful or failed
+    --tx-type <type,...>  is a call, create or transfer
+    --opcode <op,...>     loads code containing one of the instructions,
+                          which may or may not be executed`,
 }
 
 func clone(ctx *cli.Context) error {
@@ -47,6 +63,11 @@ func clone(ctx *cli.Context) error {
 		return fmt.Errorf("substate-cli db clone: error: first block has larger number than last block")
 	}
 
+	filter, err := NewSubstateFilter(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli db clone: %v", err)
+	}
+
 	srcBackend, err := rawdb.NewLevelDBDatabase(srcPath, 1024, 100, "srcDB", true)
 	if err != nil {
 		return fmt.Errorf("substate-cli db clone: error opening %s: %v", srcPath, err)
@@ -62,13 +83,21 @@ func clone(ctx *cli.Context) error {
 	dstDB := substate.NewSubstateDB(dstBackend)
 	defer dstDB.Close()
 
+	var cloned uint64
 	cloneTask := func(block uint64, tx int, substate *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		if filter != nil && !filter(substate) {
+			return nil
+		}
 		dstDB.PutSubstate(block, tx, substate)
+		atomic.AddUint64(&cloned, 1)
 		return nil
 	}
 
 	taskPool := substate.NewSubstateTaskPool("substate-cli db clone", cloneTask, uint64(first), uint64(last), ctx)
 	taskPool.DB = srcDB
-	err = taskPool.Execute()
+	err = progress.ExecuteTaskPool(taskPool)
+	if filter != nil {
+		fmt.Printf("substate-cli db clone: cloned %v transactions\n", cloned)
+	}
 	return err
 }
@@ -2,9 +2,10 @@ package db
 
 import (
 	"fmt"
-	"sync"
 	"time"
 
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/ethereum/go-ethereum/substate"
 	"github.com/syndtr/goleveldb/leveldb"
 	leveldb_opt "github.com/syndtr/goleveldb/leveldb/opt"
 	leveldb_util "github.com/syndtr/goleveldb/leveldb/util"
@@ -16,11 +17,77 @@ var CompactCommand = cli.Command{
 	Name:      "compact",
 	Usage:     "Compat LevelDB - discarding deleted and overwritten versions",
 	ArgsUsage: "<dbPath>",
-	Flags:     []cli.Flag{},
+	Flags: []cli.Flag{
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+	},
 	Description: `
 The substate-cli db compact command requires one argument:
 	<dbPath>
-<dbPath> is the target LevelDB instance to compact.`,
+<dbPath> is the target LevelDB instance to compact.
+
+The substates are compacted in key ranges of consecutive blocks, so the
+progress is reported by the last block of the compacted range.`,
+}
+
+// number of key ranges the substates are compacted in
+const compactRanges = 100
+
+// compactRange is a key range compacted at once. Substates of blocks up to
+// the last block are compacted with the range.
+type compactRange struct {
+	keys   leveldb_util.Range
+	last   uint64
+	blocks uint64
+}
+
+// substateBlockRange returns the first and last block holding a substate.
+func substateBlockRange(db *leveldb.DB) (first uint64, last uint64, found bool, err error) {
+	iter := db.NewIterator(leveldb_util.BytesPrefix(substate.Stage1SubstateBlockPrefix(0)[:2]), nil)
+	defer iter.Release()
+	if !iter.First() {
+		return 0, 0, false, iter.Error()
+	}
+	if first, _, err = substate.DecodeStage1SubstateKey(iter.Key()); err != nil {
+		return 0, 0, false, err
+	}
+	if !iter.Last() {
+		return 0, 0, false, iter.Error()
+	}
+	if last, _, err = substate.DecodeStage1SubstateKey(iter.Key()); err != nil {
+		return 0, 0, false, err
+	}
+	return first, last, true, nil
+}
+
+// splitCompaction splits the key space into ranges: the keys before the
+// substates, the substates in ranges of consecutive blocks, and the keys
+// after the substates.
+func splitCompaction(first uint64, last uint64) []compactRange {
+	size := (last-first)/compactRanges + 1
+	ranges := []compactRange{{keys: leveldb_util.Range{Limit: substate.Stage1SubstateBlockPrefix(first)}}}
+	for start := first; ; start += size {
+		end := last
+		if last-start >= size {
+			end = start + size - 1
+		}
+		ranges = append(ranges, compactRange{
+			keys: leveldb_util.Range{
+				Start: substate.Stage1SubstateBlockPrefix(start),
+				Limit: substate.Stage1SubstateBlockPrefix(end + 1),
+			},
+			last:   end,
+			blocks: end - start + 1,
+		})
+		if end == last {
+			break
+		}
+	}
+	// the last substate range extends to the end of the substate keys
+	end := leveldb_util.BytesPrefix(substate.Stage1SubstateBlockPrefix(0)[:2]).Limit
+	ranges[len(ranges)-1].keys.Limit = end
+	return append(ranges, compactRange{keys: leveldb_util.Range{Start: end}})
 }
 
 func compact(ctx *cli.Context) error {
@@ -41,19 +108,37 @@ func compact(ctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("substate-cli db compact: error opening dbPath %s: %v", dbPath, err)
 	}
+	defer db.Close()
+
+	first, last, found, err := substateBlockRange(db)
+	if err != nil {
+		return fmt.Errorf("substate-cli db compact: error reading dbPath %s: %v", dbPath, err)
+	}
+	ranges := []compactRange{{keys: leveldb_util.Range{}}}
+	if found {
+		ranges = splitCompaction(first, last)
+		fmt.Printf("substate-cli db compact: substates of blocks %v - %v\n", first, last)
+	}
+
+	reporter, err := progress.NewReporter(ctx, "substate-cli db compact", first, last)
+	if err != nil {
+		return fmt.Errorf("substate-cli db compact: %v", err)
+	}
 
 	start := time.Now()
 	fmt.Printf("substate-cli db compact: compaction begin\n")
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		err = db.CompactRange(leveldb_util.Range{})
-		if err != nil {
-			panic(fmt.Errorf("substate-cli db compact: error compacting dbPath %s: %v", dbPath, err))
+	for _, r := range ranges {
+		if err = db.CompactRange(r.keys); err != nil {
+			reporter.Close()
+			return fmt.Errorf("substate-cli db compact: error compacting dbPath %s: %v", dbPath, err)
 		}
-		wg.Done()
-	}()
-	wg.Wait()
+		if r.blocks > 0 {
+			reporter.Add(r.last, r.blocks, 0, 0)
+		}
+	}
+	if err = reporter.Close(); err != nil {
+		return fmt.Errorf("substate-cli db compact: %v", err)
+	}
 	duration := time.Since(start)
 	fmt.Printf("substate-cli db compact: compaction completed\n")
 	fmt.Printf("substate-cli db compact: elapsed time: %v\n", duration.Round(1*time.Millisecond))
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+// command line options
+var (
+	FormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "output format: text or json",
+		Value: "text",
+	}
+	TopFlag = cli.IntFlag{
+		Name:  "top",
+		Usage: "number of largest substates listed",
+		Value: 10,
+	}
+	BucketSizeFlag = cli.Uint64Flag{
+		Name:  "bucket-size",
+		Usage: "number of blocks per histogram bucket",
+		Value: 1000000,
+	}
+	TouchFlag = cli.StringFlag{
+		Name:  "touch",
+		Usage: "only transactions touching one of the given comma-separated addresses",
+	}
+	StatusFlag = cli.StringFlag{
+		Name:  "status",
+		Usage: "only transactions with the given status: successful or failed",
+	}
+	TxTypeFlag = cli.StringFlag{
+		Name:  "tx-type",
+		Usage: "only transactions of the given comma-separated types: call, create or transfer",
+	}
+	OpCodeFlag = cli.StringFlag{
+		Name:  "opcode",
+		Usage: "only transactions whose code contains one of the given comma-separated instructions, e.g. CREATE2",
+	}
+	OnConflictFlag = cli.StringFlag{
+		Name:  "on-conflict",
+		Usage: "policy for substates stored in several DBs: error, keep-first or keep-identical",
+		Value: "error",
+	}
+	DryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "report overlapping substates without writing",
+	}
+)
+
+// parseBlockRange parses the first and last block of an inclusive block range.
+func parseBlockRange(command string, firstArg string, lastArg string) (uint64, uint64, error) {
+	first, ferr := strconv.ParseUint(firstArg, 10, 64)
+	last, lerr := strconv.ParseUint(lastArg, 10, 64)
+	if ferr != nil || lerr != nil {
+		return first, last, fmt.Errorf("%v: error in parsing parameters: block number not an integer", command)
+	}
+	if first > last {
+		return first, last, fmt.Errorf("%v: error: first block has larger number than last block", command)
+	}
+	return first, last, nil
+}
+
+// parseOptionalBlockRange parses the block range given by args[from:]. If
+// no block range is given, the range covers all blocks.
+func parseOptionalBlockRange(command string, args cli.Args, from int) (uint64, uint64, error) {
+	switch args.Len() - from {
+	case 0:
+		return 0, math.MaxUint64, nil
+	case 2:
+		return parseBlockRange(command, args.Get(from), args.Get(from+1))
+	}
+	return 0, 0, fmt.Errorf("%v: error: a block range requires both <blockNumFirst> and <blockNumLast>", command)
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// SubstateFilter selects substates.
+type SubstateFilter func(st *substate.Substate) bool
+
+// splitList splits a comma-separated list and drops empty elements.
+func splitList(list string) []string {
+	res := []string{}
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			res = append(res, element)
+		}
+	}
+	return res
+}
+
+// touchFilter selects transactions sending from, sending to or accessing one
+// of the given addresses.
+func touchFilter(list string) (SubstateFilter, error) {
+	addresses := map[common.Address]bool{}
+	for _, element := range splitList(list) {
+		if !common.IsHexAddress(element) {
+			return nil, fmt.Errorf("invalid address %q", element)
+		}
+		addresses[common.HexToAddress(element)] = true
+	}
+	return func(st *substate.Substate) bool {
+		if addresses[st.Message.From] || (st.Message.To != nil && addresses[*st.Message.To]) {
+			return true
+		}
+		for address := range st.InputAlloc {
+			if addresses[address] {
+				return true
+			}
+		}
+		for address := range st.OutputAlloc {
+			if addresses[address] {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func statusFilter(status string) (SubstateFilter, error) {
+	var want uint64
+	switch status {
+	case "successful":
+		want = types.ReceiptStatusSuccessful
+	case "failed":
+		want = types.ReceiptStatusFailed
+	default:
+		return nil, fmt.Errorf("unknown status %q, supported statuses are successful and failed", status)
+	}
+	return func(st *substate.Substate) bool {
+		return st.Result.Status == want
+	}, nil
+}
+
+func txTypeFilter(list string) (SubstateFilter, error) {
+	txTypes := map[string]bool{}
+	for _, txType := range splitList(list) {
+		if txType != "call" && txType != "create" && txType != "transfer" {
+			return nil, fmt.Errorf("unknown transaction type %q, supported types are call, create and transfer", txType)
+		}
+		txTypes[txType] = true
+	}
+	return func(st *substate.Substate) bool {
+		return txTypes[utils.GetTxType(st.Message.To, st.InputAlloc)]
+	}, nil
+}
+
+// opCodeFilter selects transactions whose init code or code of an accessed
+// account contains one of the given instructions. The instructions are not
+// necessarily executed by the transaction.
+func opCodeFilter(list string) (SubstateFilter, error) {
+	ops := map[vm.OpCode]bool{}
+	for _, name := range splitList(list) {
+		name = strings.ToUpper(name)
+		op := vm.StringToOp(name)
+		if op == vm.STOP && name != "STOP" {
+			return nil, fmt.Errorf("unknown instruction %q", name)
+		}
+		ops[op] = true
+	}
+	return func(st *substate.Substate) bool {
+		return utils.MayExecuteOpCode(st, ops)
+	}, nil
+}
+
+// NewSubstateFilter returns a filter selecting the substates matching all
+// filter flags set in ctx, or nil if no filter flag is set.
+func NewSubstateFilter(ctx *cli.Context) (SubstateFilter, error) {
+	var filters []SubstateFilter
+	for _, option := range []struct {
+		flag      *cli.StringFlag
+		newFilter func(string) (SubstateFilter, error)
+	}{
+		{&TouchFlag, touchFilter},
+		{&StatusFlag, statusFilter},
+		{&TxTypeFlag, txTypeFilter},
+		{&OpCodeFlag, opCodeFilter},
+	} {
+		if !ctx.IsSet(option.flag.Name) {
+			continue
+		}
+		filter, err := option.newFilter(ctx.String(option.flag.Name))
+		if err != nil {
+			return nil, fmt.Errorf("--%v: %v", option.flag.Name, err)
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return func(st *substate.Substate) bool {
+		for _, filter := range filters {
+			if !filter(st) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,313 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+var MergeCommand = cli.Command{
+	Action:    merge,
+	Name:      "merge",
+	Usage:     "Merge several substate DBs into one",
+	ArgsUsage: "<dstPath> <srcPath>...",
+	Flags: []cli.Flag{
+		&OnConflictFlag,
+		&DryRunFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+	},
+	Description: `
+The substate-cli db merge command requires at least two arguments:
+    <dstPath> <srcPath>...
+<dstPath> is the target substate database, which is created if missing.
+<srcPath>... are the substate databases merged into the target in the
+given order.
+
+A substate stored in more than one DB, including the target, is an overlap.
+The --on-conflict policy decides how overlaps are resolved:
+    error           every overlap is an error (default)
+    keep-first      the substate merged first is kept, differing overlaps
+                    are reported
+    keep-identical  overlaps must be byte-identical, differing overlaps are
+                    an error
+Codes are shared by substates, so only a code stored with different
+bytes under the same hash is a conflict. Without --dry-run the merge stops at the first error. With --dry-run all
+overlaps are reported and nothing is written.`,
+}
+
+const (
+	conflictError         = "error"
+	conflictKeepFirst     = "keep-first"
+	conflictKeepIdentical = "keep-identical"
+)
+
+// mergeStats counts the substates of a merge.
+type mergeStats struct {
+	merged    uint64
+	codes     uint64
+	identical uint64
+	differing uint64
+}
+
+// substateMerger copies the substates of source DBs into a target DB.
+type substateMerger struct {
+	dst    ethdb.KeyValueStore
+	batch  ethdb.Batch
+	policy string
+	dryRun bool
+
+	// DBs that may already hold a substate of a source: the target and,
+	// in a dry run, the previous sources
+	merged []ethdb.KeyValueReader
+	names  []string
+
+	progress *progress.Reporter
+
+	stats mergeStats
+}
+
+// find returns the name of the first merged DB holding the key and its value.
+func (m *substateMerger) find(key []byte) (string, []byte, error) {
+	for i, db := range m.merged {
+		has, err := db.Has(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if has {
+			value, err := db.Get(key)
+			return m.names[i], value, err
+		}
+	}
+	return "", nil, nil
+}
+
+func (m *substateMerger) put(key []byte, value []byte) error {
+	if m.dryRun {
+		return nil
+	}
+	if err := m.batch.Put(key, value); err != nil {
+		return err
+	}
+	if m.batch.ValueSize() >= ethdb.IdealBatchSize {
+		if err := m.batch.Write(); err != nil {
+			return err
+		}
+		m.batch.Reset()
+	}
+	return nil
+}
+
+// overlap resolves a substate stored in src and in a merged DB.
+func (m *substateMerger) overlap(src string, other string, block uint64, tx int, identical bool) error {
+	if identical {
+		m.stats.identical++
+		if m.policy == conflictError {
+			return fmt.Errorf("block %v tx %v: stored in %v and %v", block, tx, other, src)
+		}
+		return nil
+	}
+	m.stats.differing++
+	if m.policy == conflictKeepFirst {
+		fmt.Printf("substate-cli db merge: block %v tx %v: %v differs from %v, keeping %v\n", block, tx, src, other, other)
+		return nil
+	}
+	return fmt.Errorf("block %v tx %v: %v differs from %v", block, tx, src, other)
+}
+
+// codeOverlap resolves a code stored with different values in src and in a
+// merged DB.
+func (m *substateMerger) codeOverlap(src string, other string, key []byte) error {
+	m.stats.differing++
+	codeHash, err := substate.DecodeStage1CodeKey(key)
+	if err != nil {
+		return err
+	}
+	if m.policy == conflictKeepFirst {
+		fmt.Printf("substate-cli db merge: code %v: %v differs from %v, keeping %v\n", codeHash.Hex(), src, other, other)
+		return nil
+	}
+	return fmt.Errorf("code %v: %v differs from %v", codeHash.Hex(), src, other)
+}
+
+// mergeSource copies codes and substates of a source DB. In a dry run,
+// errors of the conflict policy are reported and counted in errs.
+func (m *substateMerger) mergeSource(name string, src ethdb.KeyValueStore) (errs int, err error) {
+	codes := src.NewIterator(substate.Stage1CodeKey(common.Hash{})[:2], nil)
+	defer codes.Release()
+	for codes.Next() {
+		other, value, err := m.find(codes.Key())
+		if err != nil {
+			return errs, err
+		}
+		if other != "" {
+			// codes are shared by substates, only differing codes conflict
+			if !bytes.Equal(value, codes.Value()) {
+				if err := m.codeOverlap(name, other, codes.Key()); err != nil {
+					if !m.dryRun {
+						return errs, err
+					}
+					fmt.Printf("substate-cli db merge: %v\n", err)
+					errs++
+				}
+			}
+			continue
+		}
+		if err := m.put(codes.Key(), codes.Value()); err != nil {
+			return errs, err
+		}
+		m.stats.codes++
+	}
+	if err := codes.Error(); err != nil {
+		return errs, err
+	}
+
+	iter := newSubstateIterator(src, 0)
+	defer iter.Release()
+	lastBlock, hasBlock := uint64(0), false
+	for iter.Next() {
+		block, tx, err := substate.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			return errs, err
+		}
+		if !hasBlock || block != lastBlock {
+			m.progress.Add(block, 1, 0, 0)
+			lastBlock, hasBlock = block, true
+		}
+		m.progress.Add(block, 0, 1, 0)
+		other, value, err := m.find(iter.Key())
+		if err != nil {
+			return errs, err
+		}
+		if other != "" {
+			if err := m.overlap(name, other, block, tx, bytes.Equal(value, iter.Value())); err != nil {
+				if !m.dryRun {
+					return errs, err
+				}
+				fmt.Printf("substate-cli db merge: %v\n", err)
+				errs++
+			}
+			continue
+		}
+		if err := m.put(iter.Key(), iter.Value()); err != nil {
+			return errs, err
+		}
+		m.stats.merged++
+	}
+	if err := iter.Error(); err != nil {
+		return errs, err
+	}
+
+	if !m.dryRun {
+		if err := m.batch.Write(); err != nil {
+			return errs, err
+		}
+		m.batch.Reset()
+	}
+	return errs, nil
+}
+
+func merge(ctx *cli.Context) error {
+	if ctx.Args().Len() < 2 {
+		return fmt.Errorf("substate-cli db merge command requires at least 2 arguments")
+	}
+	policy := ctx.String(OnConflictFlag.Name)
+	if policy != conflictError && policy != conflictKeepFirst && policy != conflictKeepIdentical {
+		return fmt.Errorf("substate-cli db merge: unknown policy %q, supported policies are %v, %v and %v", policy, conflictError, conflictKeepFirst, conflictKeepIdentical)
+	}
+	dryRun := ctx.Bool(DryRunFlag.Name)
+
+	dstPath := ctx.Args().Get(0)
+	srcPaths := ctx.Args().Slice()[1:]
+	for _, srcPath := range srcPaths {
+		if filepath.Clean(srcPath) == filepath.Clean(dstPath) {
+			return fmt.Errorf("substate-cli db merge: error: %v is both target and source", dstPath)
+		}
+	}
+
+	var dst ethdb.KeyValueStore
+	if _, err := os.Stat(dstPath); dryRun && os.IsNotExist(err) {
+		// a dry run does not create the target
+		dst = memorydb.New()
+	} else {
+		dst, err = rawdb.NewLevelDBDatabase(dstPath, 1024, 100, "dstDB", dryRun)
+		if err != nil {
+			return fmt.Errorf("substate-cli db merge: error opening %s: %v", dstPath, err)
+		}
+	}
+	defer dst.Close()
+
+	merger := &substateMerger{
+		dst:    dst,
+		batch:  dst.NewBatch(),
+		policy: policy,
+		dryRun: dryRun,
+		merged: []ethdb.KeyValueReader{dst},
+		names:  []string{dstPath},
+	}
+
+	// the metrics server keeps running across the sources
+	metrics, err := progress.StartMetricsServer(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli db merge: %v", err)
+	}
+	defer metrics.Close()
+
+	start := time.Now()
+	errs := 0
+	for _, srcPath := range srcPaths {
+		src, err := rawdb.NewLevelDBDatabase(srcPath, 1024, 100, "srcDB", true)
+		if err != nil {
+			return fmt.Errorf("substate-cli db merge: error opening %s: %v", srcPath, err)
+		}
+		merger.progress, err = progress.NewReporter(ctx, fmt.Sprintf("substate-cli db merge %v", srcPath), 0, progress.Unbounded)
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("substate-cli db merge: %v", err)
+		}
+		before := merger.stats
+		n, err := merger.mergeSource(srcPath, src)
+		errs += n
+		if closeErr := merger.progress.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("substate-cli db merge: %v", err)
+		}
+		fmt.Printf("substate-cli db merge: %v: merged %v substates and %v codes\n", srcPath, merger.stats.merged-before.merged, merger.stats.codes-before.codes)
+		if dryRun {
+			// nothing is written to the target, so later sources are compared
+			// with this source directly
+			merger.merged = append(merger.merged, src)
+			merger.names = append(merger.names, srcPath)
+			defer src.Close()
+		} else {
+			src.Close()
+		}
+	}
+
+	fmt.Printf("substate-cli db merge: merged substates   = %v\n", merger.stats.merged)
+	fmt.Printf("substate-cli db merge: merged codes       = %v\n", merger.stats.codes)
+	fmt.Printf("substate-cli db merge: identical overlaps = %v\n", merger.stats.identical)
+	fmt.Printf("substate-cli db merge: differing overlaps = %v\n", merger.stats.differing)
+	fmt.Printf("substate-cli db merge: done in %v\n", time.Since(start).Round(1*time.Millisecond))
+	if dryRun {
+		fmt.Printf("substate-cli db merge: dry run, nothing written to %v\n", dstPath)
+	}
+	if errs > 0 {
+		return fmt.Errorf("substate-cli db merge: %v overlaps violate policy %v", errs, policy)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// testEntry is a substate, or a code if tx is negative, stored in a test DB.
+type testEntry struct {
+	block uint64
+	tx    int
+	value string
+}
+
+func (e testEntry) key() []byte {
+	if e.tx < 0 {
+		return substate.Stage1CodeKey(common.Hash{byte(e.block)})
+	}
+	return substate.Stage1SubstateKey(e.block, e.tx)
+}
+
+func newTestDB(t *testing.T, entries ...testEntry) ethdb.KeyValueStore {
+	t.Helper()
+	db := memorydb.New()
+	for _, entry := range entries {
+		if err := db.Put(entry.key(), []byte(entry.value)); err != nil {
+			t.Fatalf("cannot store entry: %v", err)
+		}
+	}
+	return db
+}
+
+func code(id uint64, value string) testEntry {
+	return testEntry{block: id, tx: -1, value: value}
+}
+
+// runTestMerge merges the sources into dst the way the merge command does and
+// returns the number of overlaps violating the policy in a dry run.
+func runTestMerge(t *testing.T, dst ethdb.KeyValueStore, policy string, dryRun bool, sources ...ethdb.KeyValueStore) (mergeStats, int, error) {
+	t.Helper()
+	m := &substateMerger{
+		dst:    dst,
+		batch:  dst.NewBatch(),
+		policy: policy,
+		dryRun: dryRun,
+		merged: []ethdb.KeyValueReader{dst},
+		names:  []string{"dst"},
+	}
+	errs := 0
+	for i, src := range sources {
+		name := string(rune('a' + i))
+		n, err := m.mergeSource(name, src)
+		errs += n
+		if err != nil {
+			return m.stats, errs, err
+		}
+		if dryRun {
+			m.merged = append(m.merged, src)
+			m.names = append(m.names, name)
+		}
+	}
+	return m.stats, errs, nil
+}
+
+func checkEntries(t *testing.T, db ethdb.KeyValueReader, entries ...testEntry) {
+	t.Helper()
+	for _, entry := range entries {
+		value, err := db.Get(entry.key())
+		if err != nil {
+			t.Errorf("entry %v is missing: %v", entry, err)
+			continue
+		}
+		if !bytes.Equal(value, []byte(entry.value)) {
+			t.Errorf("unexpected value of entry %v, got %q", entry, value)
+		}
+	}
+}
+
+func TestMergeWithoutOverlaps(t *testing.T) {
+	for _, policy := range []string{conflictError, conflictKeepFirst, conflictKeepIdentical} {
+		dst := newTestDB(t, testEntry{1, 0, "dst"})
+		a := newTestDB(t, testEntry{2, 0, "a"}, code(1, "code"))
+		b := newTestDB(t, testEntry{2, 1, "b"}, testEntry{3, 0, "b"}, code(1, "code"))
+		stats, _, err := runTestMerge(t, dst, policy, false, a, b)
+		if err != nil {
+			t.Fatalf("policy %v: unexpected error: %v", policy, err)
+		}
+		// identical codes are shared and are no overlap
+		if want := (mergeStats{merged: 3, codes: 1}); stats != want {
+			t.Errorf("policy %v: unexpected statistics, wanted %+v, got %+v", policy, want, stats)
+		}
+		checkEntries(t, dst, testEntry{1, 0, "dst"}, testEntry{2, 0, "a"}, testEntry{2, 1, "b"}, testEntry{3, 0, "b"}, code(1, "code"))
+	}
+}
+
+func TestMergeConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		entries []testEntry // entries of source b, overlapping source a
+		fails   bool
+		stats   mergeStats
+		want    []testEntry
+	}{
+		{
+			policy:  conflictError,
+			entries: []testEntry{{1, 0, "a"}},
+			fails:   true,
+			stats:   mergeStats{merged: 1, codes: 1, identical: 1},
+		},
+		{
+			policy:  conflictKeepIdentical,
+			entries: []testEntry{{1, 0, "a"}, {2, 0, "b"}},
+			stats:   mergeStats{merged: 2, codes: 1, identical: 1},
+			want:    []testEntry{{1, 0, "a"}, {2, 0, "b"}},
+		},
+		{
+			policy:  conflictKeepIdentical,
+			entries: []testEntry{{1, 0, "b"}},
+			fails:   true,
+			stats:   mergeStats{merged: 1, codes: 1, differing: 1},
+		},
+		{
+			policy:  conflictKeepFirst,
+			entries: []testEntry{{1, 0, "b"}, {2, 0, "b"}},
+			stats:   mergeStats{merged: 2, codes: 1, differing: 1},
+			want:    []testEntry{{1, 0, "a"}, {2, 0, "b"}},
+		},
+		{
+			policy:  conflictError,
+			entries: []testEntry{code(1, "other")},
+			fails:   true,
+			stats:   mergeStats{merged: 1, codes: 1, differing: 1},
+		},
+		{
+			policy:  conflictKeepIdentical,
+			entries: []testEntry{code(1, "other")},
+			fails:   true,
+			stats:   mergeStats{merged: 1, codes: 1, differing: 1},
+		},
+		{
+			policy:  conflictKeepFirst,
+			entries: []testEntry{code(1, "other")},
+			stats:   mergeStats{merged: 1, codes: 1, differing: 1},
+			want:    []testEntry{code(1, "code")},
+		},
+	}
+	for _, test := range tests {
+		dst := memorydb.New()
+		a := newTestDB(t, testEntry{1, 0, "a"}, code(1, "code"))
+		b := newTestDB(t, test.entries...)
+		stats, _, err := runTestMerge(t, dst, test.policy, false, a, b)
+		if test.fails != (err != nil) {
+			t.Errorf("policy %v with %v: unexpected error: %v", test.policy, test.entries, err)
+		}
+		if stats != test.stats {
+			t.Errorf("policy %v with %v: unexpected statistics, wanted %+v, got %+v", test.policy, test.entries, test.stats, stats)
+		}
+		checkEntries(t, dst, test.want...)
+	}
+}
+
+func TestMergeDryRunReportsAllOverlaps(t *testing.T) {
+	dst := newTestDB(t, testEntry{1, 0, "dst"})
+	a := newTestDB(t, testEntry{1, 0, "a"}, testEntry{2, 0, "a"}, code(1, "code"))
+	// overlaps with a, which is not written to the target
+	b := newTestDB(t, testEntry{2, 0, "b"}, testEntry{3, 0, "b"}, code(1, "other"))
+	stats, errs, err := runTestMerge(t, dst, conflictKeepIdentical, true, a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if errs != 3 {
+		t.Errorf("unexpected number of violations, wanted 3, got %d", errs)
+	}
+	if want := (mergeStats{merged: 2, codes: 1, differing: 3}); stats != want {
+		t.Errorf("unexpected statistics, wanted %+v, got %+v", want, stats)
+	}
+	for _, entry := range []testEntry{{2, 0, ""}, {3, 0, ""}, code(1, "")} {
+		if has, _ := dst.Has(entry.key()); has {
+			t.Errorf("dry run wrote entry %v", entry)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+var StatsCommand = cli.Command{
+	Action:    stats,
+	Name:      "stats",
+	Usage:     "Print an inventory of the substates in a DB",
+	ArgsUsage: "<dbPath> [<blockNumFirst> <blockNumLast>]",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&FormatFlag,
+		&TopFlag,
+		&BucketSizeFlag,
+	},
+	Description: `
+The substate-cli db stats command requires one argument:
+    <dbPath> [<blockNumFirst> <blockNumLast>]
+<dbPath> is the substate database to inspect.
+<blockNumFirst> and <blockNumLast> optionally restrict the inventory
+to an inclusive range of blocks.
+
+The inventory lists the first and last block, the number of blocks and
+transactions, transactions per type, the size of substates, distinct codes
+and input storage, the largest substates and a histogram of these numbers
+per bucket of --bucket-size blocks. It is printed as text or json.`,
+}
+
+// DBStats is the inventory of a substate DB.
+type DBStats struct {
+	First         uint64            `json:"first"`
+	Last          uint64            `json:"last"`
+	Blocks        uint64            `json:"blocks"`
+	Transactions  uint64            `json:"transactions"`
+	TxTypes       map[string]uint64 `json:"txTypes"`
+	SubstateBytes uint64            `json:"substateBytes"`
+	Codes         uint64            `json:"codes"`
+	CodeBytes     uint64            `json:"codeBytes"`
+	StorageSlots  uint64            `json:"storageSlots"`
+	StorageBytes  uint64            `json:"storageBytes"`
+	Largest       []SubstateSize    `json:"largest"`
+	BucketSize    uint64            `json:"bucketSize"`
+	Buckets       []*StatsBucket    `json:"buckets"`
+}
+
+// SubstateSize is the size of an encoded substate.
+type SubstateSize struct {
+	Block uint64 `json:"block"`
+	Tx    int    `json:"tx"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// StatsBucket holds the inventory of a bucket of blocks.
+type StatsBucket struct {
+	First         uint64            `json:"first"`
+	Last          uint64            `json:"last"`
+	Blocks        uint64            `json:"blocks"`
+	Transactions  uint64            `json:"transactions"`
+	TxTypes       map[string]uint64 `json:"txTypes"`
+	SubstateBytes uint64            `json:"substateBytes"`
+	StorageSlots  uint64            `json:"storageSlots"`
+}
+
+// statsCollector accumulates the inventory. Block numbers and sizes are
+// collected while iterating the keys in order, the content of substates is
+// collected by the workers decoding them.
+type statsCollector struct {
+	mu      sync.Mutex
+	stats   DBStats
+	top     int
+	buckets map[uint64]*StatsBucket
+	codes   map[common.Hash]struct{}
+}
+
+func newStatsCollector(top int, bucketSize uint64) *statsCollector {
+	return &statsCollector{
+		stats: DBStats{
+			TxTypes:    map[string]uint64{},
+			Largest:    []SubstateSize{},
+			BucketSize: bucketSize,
+			Buckets:    []*StatsBucket{},
+		},
+		top:     top,
+		buckets: map[uint64]*StatsBucket{},
+		codes:   map[common.Hash]struct{}{},
+	}
+}
+
+func (c *statsCollector) bucket(block uint64) *StatsBucket {
+	index := block / c.stats.BucketSize
+	b, exist := c.buckets[index]
+	if !exist {
+		b = &StatsBucket{
+			First:   index * c.stats.BucketSize,
+			Last:    index*c.stats.BucketSize + c.stats.BucketSize - 1,
+			TxTypes: map[string]uint64{},
+		}
+		c.buckets[index] = b
+	}
+	return b
+}
+
+// addKey records a substate key and the size of its encoded value.
+func (c *statsCollector) addKey(block uint64, tx int, size uint64, newBlock bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.bucket(block)
+	if newBlock {
+		if c.stats.Blocks == 0 {
+			c.stats.First = block
+		}
+		c.stats.Last = block
+		c.stats.Blocks++
+		b.Blocks++
+	}
+	c.stats.SubstateBytes += size
+	b.SubstateBytes += size
+
+	if c.top == 0 {
+		return
+	}
+	largest := c.stats.Largest
+	if len(largest) < c.top || size > largest[len(largest)-1].Bytes {
+		if len(largest) == c.top {
+			largest = largest[:len(largest)-1]
+		}
+		i := sort.Search(len(largest), func(i int) bool { return largest[i].Bytes < size })
+		largest = append(largest, SubstateSize{})
+		copy(largest[i+1:], largest[i:])
+		largest[i] = SubstateSize{Block: block, Tx: tx, Bytes: size}
+		c.stats.Largest = largest
+	}
+}
+
+// addSubstate records the content of a decoded substate.
+func (c *statsCollector) addSubstate(block uint64, st *substate.Substate) {
+	txType := utils.GetTxType(st.Message.To, st.InputAlloc)
+	var slots uint64
+	codes := map[common.Hash]int{}
+	for _, account := range st.InputAlloc {
+		slots += uint64(len(account.Storage))
+		if len(account.Code) > 0 {
+			codes[account.CodeHash()] = len(account.Code)
+		}
+	}
+	for _, account := range st.OutputAlloc {
+		if len(account.Code) > 0 {
+			codes[account.CodeHash()] = len(account.Code)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.bucket(block)
+	c.stats.Transactions++
+	b.Transactions++
+	c.stats.TxTypes[txType]++
+	b.TxTypes[txType]++
+	c.stats.StorageSlots += slots
+	b.StorageSlots += slots
+	for codeHash, size := range codes {
+		if _, exist := c.codes[codeHash]; !exist {
+			c.codes[codeHash] = struct{}{}
+			c.stats.Codes++
+			c.stats.CodeBytes += uint64(size)
+		}
+	}
+}
+
+// result completes the inventory once all substates are collected.
+func (c *statsCollector) result() *DBStats {
+	s := &c.stats
+	s.StorageBytes = s.StorageSlots * 2 * common.HashLength
+	indices := make([]uint64, 0, len(c.buckets))
+	for index := range c.buckets {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	s.Buckets = s.Buckets[:0]
+	for _, index := range indices {
+		s.Buckets = append(s.Buckets, c.buckets[index])
+	}
+	return s
+}
+
+// WriteText writes the inventory as aligned plain text.
+func (s *DBStats) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if s.Blocks == 0 {
+		fmt.Fprintf(tw, "Blocks:\t0\n")
+		return tw.Flush()
+	}
+	fmt.Fprintf(tw, "Block range:\t%v - %v\n", s.First, s.Last)
+	fmt.Fprintf(tw, "Blocks:\t%v\n", s.Blocks)
+	fmt.Fprintf(tw, "Transactions:\t%v\n", s.Transactions)
+	for _, txType := range sortedTxTypes(s.TxTypes) {
+		fmt.Fprintf(tw, "  %v:\t%v\n", txType, s.TxTypes[txType])
+	}
+	fmt.Fprintf(tw, "Substate bytes:\t%v\n", s.SubstateBytes)
+	fmt.Fprintf(tw, "Distinct codes:\t%v\n", s.Codes)
+	fmt.Fprintf(tw, "Code bytes:\t%v\n", s.CodeBytes)
+	fmt.Fprintf(tw, "Storage slots:\t%v\n", s.StorageSlots)
+	fmt.Fprintf(tw, "Storage bytes:\t%v\n", s.StorageBytes)
+
+	fmt.Fprintf(tw, "\nLargest substates\n")
+	fmt.Fprintf(tw, "block\ttx\tbytes\n")
+	for _, size := range s.Largest {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", size.Block, size.Tx, size.Bytes)
+	}
+
+	txTypes := sortedTxTypes(s.TxTypes)
+	fmt.Fprintf(tw, "\nBuckets of %v blocks\n", s.BucketSize)
+	fmt.Fprintf(tw, "first\tlast\tblocks\ttransactions\t%v\tsubstate bytes\tstorage slots\n", strings.Join(txTypes, "\t"))
+	for _, b := range s.Buckets {
+		counts := make([]string, len(txTypes))
+		for i, txType := range txTypes {
+			counts[i] = fmt.Sprint(b.TxTypes[txType])
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", b.First, b.Last, b.Blocks, b.Transactions, strings.Join(counts, "\t"), b.SubstateBytes, b.StorageSlots)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the inventory as an indented JSON document.
+func (s *DBStats) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func sortedTxTypes(txTypes map[string]uint64) []string {
+	list := make([]string, 0, len(txTypes))
+	for txType := range txTypes {
+		list = append(list, txType)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// newSubstateIterator iterates over all substate keys in order, starting
+// with the first substate of the given block.
+func newSubstateIterator(backend ethdb.Iteratee, first uint64) ethdb.Iterator {
+	start := substate.Stage1SubstateBlockPrefix(first)
+	prefix := substate.Stage1SubstateBlockPrefix(0)[:len(start)-8]
+	return backend.NewIterator(prefix, start[len(prefix):])
+}
+
+// substateKey identifies a substate scheduled for decoding.
+type substateKey struct {
+	block uint64
+	tx    int
+}
+
+func stats(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 && ctx.Args().Len() != 3 {
+		return fmt.Errorf("substate-cli db stats command requires 1 or 3 arguments")
+	}
+	dbPath := ctx.Args().Get(0)
+	first, last, err := parseOptionalBlockRange("substate-cli db stats", ctx.Args(), 1)
+	if err != nil {
+		return err
+	}
+	format := ctx.String(FormatFlag.Name)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("substate-cli db stats: unknown format %q, supported formats are text and json", format)
+	}
+	top := ctx.Int(TopFlag.Name)
+	bucketSize := ctx.Uint64(BucketSizeFlag.Name)
+	workers := ctx.Int(substate.WorkersFlag.Name)
+	if top < 0 || bucketSize == 0 || workers < 1 {
+		return fmt.Errorf("substate-cli db stats: error: --%v must not be negative, --%v and --%v must be positive", TopFlag.Name, BucketSizeFlag.Name, substate.WorkersFlag.Name)
+	}
+
+	backend, err := rawdb.NewLevelDBDatabase(dbPath, 1024, 100, "srcDB", true)
+	if err != nil {
+		return fmt.Errorf("substate-cli db stats: error opening %s: %v", dbPath, err)
+	}
+	db := substate.NewSubstateDB(backend)
+	defer db.Close()
+
+	if numProcs := workers + 2; runtime.GOMAXPROCS(0) < numProcs {
+		runtime.GOMAXPROCS(numProcs)
+	}
+
+	collector := newStatsCollector(top, bucketSize)
+
+	reporter, err := progress.NewReporter(ctx, "substate-cli db stats", first, last)
+	if err != nil {
+		return fmt.Errorf("substate-cli db stats: %v", err)
+	}
+
+	keys := make(chan substateKey, workers*10)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				st, err := getSubstate(db, key.block, key.tx)
+				if err != nil {
+					errs <- fmt.Errorf("substate-cli db stats: block %v tx %v: %v", key.block, key.tx, err)
+					for range keys {
+					}
+					return
+				}
+				collector.addSubstate(key.block, st)
+				reporter.Add(key.block, 0, 1, st.Result.GasUsed)
+			}
+		}()
+	}
+
+	iter := newSubstateIterator(backend, first)
+	lastBlock, hasBlock := uint64(0), false
+	for iter.Next() {
+		block, tx, err := substate.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "substate-cli db stats: skipped key %x: %v\n", iter.Key(), err)
+			continue
+		}
+		if block > last {
+			break
+		}
+		newBlock := !hasBlock || block != lastBlock
+		if newBlock {
+			// blocks without substates count as progress as well
+			if hasBlock {
+				reporter.Add(block, block-lastBlock, 0, 0)
+			} else {
+				reporter.Add(block, block-first+1, 0, 0)
+			}
+		}
+		lastBlock, hasBlock = block, true
+		collector.addKey(block, tx, uint64(len(iter.Value())), newBlock)
+		keys <- substateKey{block, tx}
+	}
+	iter.Release()
+	if last != progress.Unbounded {
+		if hasBlock {
+			reporter.Add(last, last-lastBlock, 0, 0)
+		} else {
+			reporter.Add(last, last-first+1, 0, 0)
+		}
+	}
+	close(keys)
+	wg.Wait()
+	close(errs)
+	if err := reporter.Close(); err != nil {
+		return fmt.Errorf("substate-cli db stats: %v", err)
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("substate-cli db stats: error iterating %s: %v", dbPath, err)
+	}
+
+	s := collector.result()
+	if format == "json" {
+		err = s.WriteJSON(os.Stdout)
+	} else {
+		err = s.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return fmt.Errorf("substate-cli db stats: %v", err)
+	}
+	return nil
+}
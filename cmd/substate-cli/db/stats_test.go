@@ -0,0 +1,95 @@
+package db
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+func TestStatsCollectorListsLargestSubstates(t *testing.T) {
+	keys := []struct {
+		block uint64
+		tx    int
+		size  uint64
+	}{
+		{1, 0, 10}, {1, 1, 30}, {2, 0, 20}, {3, 0, 5}, {3, 1, 40}, {4, 0, 30},
+	}
+	tests := []struct {
+		top  int
+		want []SubstateSize
+	}{
+		{top: 0, want: []SubstateSize{}},
+		{top: 1, want: []SubstateSize{{3, 1, 40}}},
+		// ties keep the substate collected first
+		{top: 3, want: []SubstateSize{{3, 1, 40}, {1, 1, 30}, {4, 0, 30}}},
+		{top: 10, want: []SubstateSize{{3, 1, 40}, {1, 1, 30}, {4, 0, 30}, {2, 0, 20}, {1, 0, 10}, {3, 0, 5}}},
+	}
+	for _, test := range tests {
+		c := newStatsCollector(test.top, 10)
+		lastBlock := uint64(0)
+		for _, key := range keys {
+			c.addKey(key.block, key.tx, key.size, key.block != lastBlock)
+			lastBlock = key.block
+		}
+		s := c.result()
+		if !reflect.DeepEqual(s.Largest, test.want) {
+			t.Errorf("unexpected largest substates for top %d, wanted %v, got %v", test.top, test.want, s.Largest)
+		}
+		if s.First != 1 || s.Last != 4 || s.Blocks != 4 || s.SubstateBytes != 135 {
+			t.Errorf("unexpected totals for top %d: first %d, last %d, blocks %d, bytes %d", test.top, s.First, s.Last, s.Blocks, s.SubstateBytes)
+		}
+	}
+}
+
+func TestStatsCollectorFillsBuckets(t *testing.T) {
+	to := common.Address{0xc}
+	code := []byte{0x60, 0x00}
+	call := &substate.Substate{
+		InputAlloc: substate.SubstateAlloc{
+			to: substate.NewSubstateAccount(1, big.NewInt(0), code),
+		},
+		OutputAlloc: substate.SubstateAlloc{},
+		Message:     &substate.SubstateMessage{To: &to},
+	}
+	call.InputAlloc[to].Storage[common.Hash{1}] = common.Hash{1}
+	create := &substate.Substate{
+		InputAlloc: substate.SubstateAlloc{},
+		OutputAlloc: substate.SubstateAlloc{
+			{0xd}: substate.NewSubstateAccount(1, big.NewInt(0), []byte{0x00}),
+		},
+		Message: &substate.SubstateMessage{},
+	}
+
+	c := newStatsCollector(1, 10)
+	// blocks 5 and 9 fall into bucket 0-9, block 25 into bucket 20-29
+	c.addKey(5, 0, 100, true)
+	c.addSubstate(5, call)
+	c.addKey(9, 0, 50, true)
+	c.addSubstate(9, create)
+	c.addKey(9, 1, 70, false)
+	c.addSubstate(9, call)
+	c.addKey(25, 0, 10, true)
+	c.addSubstate(25, create)
+	s := c.result()
+
+	want := []*StatsBucket{
+		{First: 0, Last: 9, Blocks: 2, Transactions: 3, TxTypes: map[string]uint64{"call": 2, "create": 1}, SubstateBytes: 220, StorageSlots: 2},
+		{First: 20, Last: 29, Blocks: 1, Transactions: 1, TxTypes: map[string]uint64{"create": 1}, SubstateBytes: 10},
+	}
+	if !reflect.DeepEqual(s.Buckets, want) {
+		t.Errorf("unexpected buckets, wanted %v, got %v", want, s.Buckets)
+	}
+	if s.Transactions != 4 || s.StorageSlots != 2 || s.StorageBytes != 128 {
+		t.Errorf("unexpected totals: transactions %d, storage slots %d, storage bytes %d", s.Transactions, s.StorageSlots, s.StorageBytes)
+	}
+	// the code of the called contract and the created contract are distinct
+	if s.Codes != 2 || s.CodeBytes != 3 {
+		t.Errorf("unexpected codes, wanted 2 codes of 3 bytes, got %d codes of %d bytes", s.Codes, s.CodeBytes)
+	}
+	if want := map[string]uint64{"call": 2, "create": 2}; !reflect.DeepEqual(s.TxTypes, want) {
+		t.Errorf("unexpected transaction types, wanted %v, got %v", want, s.TxTypes)
+	}
+}
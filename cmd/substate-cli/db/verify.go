@@ -0,0 +1,266 @@
+package db
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+var VerifyCommand = cli.Command{
+	Action:    verify,
+	Name:      "verify",
+	Usage:     "Check the integrity of substates in a given range of blocks",
+	ArgsUsage: "<dbPath> <blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+	},
+	Description: `
+The substate-cli db verify command requires three arguments:
+    <dbPath> <blockNumFirst> <blockNumLast>
+<dbPath> is the substate database to verify.
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to verify.
+
+Every stored substate is checked for the following problems:
+    key          the key of the substate cannot be decoded
+    decode       the substate or one of its codes cannot be decoded
+    tx-gap       transaction indices of a block do not start at 0 or are
+                 not consecutive
+    env-number   the block number in the environment differs from the key
+    code-hash    a code does not match the hash it is stored under
+    sender       the sender of the message is missing in the input alloc
+    blockhashes  the code may execute BLOCKHASH but no block hashes are
+                 recorded, or a recorded block hash is out of range
+The command fails if any problem is found.`,
+}
+
+// verifyIssue is a problem found in a substate.
+type verifyIssue struct {
+	block uint64
+	tx    int
+	check string
+	msg   string
+}
+
+func (i verifyIssue) String() string {
+	if i.tx < 0 {
+		return fmt.Sprintf("block %v: %v: %v", i.block, i.check, i.msg)
+	}
+	return fmt.Sprintf("block %v tx %v: %v: %v", i.block, i.tx, i.check, i.msg)
+}
+
+// getSubstate decodes a substate and turns the panics of the substate DB
+// into an error.
+func getSubstate(db *substate.SubstateDB, block uint64, tx int) (st *substate.Substate, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return db.GetSubstate(block, tx), nil
+}
+
+// verifySubstate checks the consistency of a decoded substate.
+func verifySubstate(db *substate.SubstateDB, block uint64, tx int, st *substate.Substate) []verifyIssue {
+	var issues []verifyIssue
+	report := func(check string, format string, args ...interface{}) {
+		issues = append(issues, verifyIssue{block: block, tx: tx, check: check, msg: fmt.Sprintf(format, args...)})
+	}
+
+	if st.Env == nil || st.Message == nil || st.Result == nil {
+		report("decode", "missing environment, message or result")
+		return issues
+	}
+
+	if st.Env.Number != block {
+		report("env-number", "environment has block number %v", st.Env.Number)
+	}
+
+	for name, alloc := range map[string]substate.SubstateAlloc{"input": st.InputAlloc, "output": st.OutputAlloc} {
+		for address, account := range alloc {
+			if len(account.Code) == 0 {
+				continue
+			}
+			if codeHash := substate.CodeHash(account.Code); !db.HasCode(codeHash) {
+				report("code-hash", "code of %v in %v alloc is not stored under its hash %v", address.Hex(), name, codeHash.Hex())
+			}
+		}
+	}
+	if msg := st.Message; msg.To == nil && len(msg.Data) > 0 {
+		if codeHash := substate.CodeHash(msg.Data); !db.HasCode(codeHash) {
+			report("code-hash", "init code is not stored under its hash %v", codeHash.Hex())
+		}
+	}
+
+	if _, exist := st.InputAlloc[st.Message.From]; !exist {
+		report("sender", "sender %v is not in input alloc", st.Message.From.Hex())
+	}
+
+	if len(st.Env.BlockHashes) == 0 && utils.MayExecuteBlockHash(st) {
+		report("blockhashes", "code may execute BLOCKHASH but no block hashes are recorded")
+	}
+	for number := range st.Env.BlockHashes {
+		if number >= st.Env.Number || number+256 < st.Env.Number {
+			report("blockhashes", "block hash of block %v is out of range", number)
+		}
+	}
+	return issues
+}
+
+// verifyBlock checks all substates of a block.
+func verifyBlock(backend substate.BackendDatabase, db *substate.SubstateDB, block uint64) (int, uint64, []verifyIssue) {
+	var gas uint64
+	var issues []verifyIssue
+	var txs []int
+
+	iter := backend.NewIterator(substate.Stage1SubstateBlockPrefix(block), nil)
+	for iter.Next() {
+		b, tx, err := substate.DecodeStage1SubstateKey(iter.Key())
+		if err != nil {
+			issues = append(issues, verifyIssue{block: block, tx: -1, check: "key", msg: err.Error()})
+			continue
+		}
+		if b != block {
+			issues = append(issues, verifyIssue{block: block, tx: tx, check: "key", msg: fmt.Sprintf("key of block %v", b)})
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		issues = append(issues, verifyIssue{block: block, tx: -1, check: "decode", msg: err.Error()})
+	}
+
+	sort.Ints(txs)
+	for i := 0; i < len(txs); i++ {
+		// transaction indices start at 0
+		from := 0
+		if i > 0 {
+			from = txs[i-1] + 1
+		}
+		switch to := txs[i] - 1; {
+		case from == to:
+			issues = append(issues, verifyIssue{block: block, tx: -1, check: "tx-gap", msg: fmt.Sprintf("transaction %v is missing", from)})
+		case from < to:
+			issues = append(issues, verifyIssue{block: block, tx: -1, check: "tx-gap", msg: fmt.Sprintf("transactions %v to %v are missing", from, to)})
+		}
+	}
+
+	for _, tx := range txs {
+		st, err := getSubstate(db, block, tx)
+		if err != nil {
+			issues = append(issues, verifyIssue{block: block, tx: tx, check: "decode", msg: err.Error()})
+			continue
+		}
+		if st.Result != nil {
+			gas += st.Result.GasUsed
+		}
+		issues = append(issues, verifySubstate(db, block, tx, st)...)
+	}
+	return len(txs), gas, issues
+}
+
+func verify(ctx *cli.Context) error {
+	if ctx.Args().Len() != 3 {
+		return fmt.Errorf("substate-cli db verify command requires exactly 3 arguments")
+	}
+
+	dbPath := ctx.Args().Get(0)
+	first, last, err := parseBlockRange("substate-cli db verify", ctx.Args().Get(1), ctx.Args().Get(2))
+	if err != nil {
+		return err
+	}
+	workers := ctx.Int(substate.WorkersFlag.Name)
+	if workers < 1 {
+		return fmt.Errorf("substate-cli db verify: error: number of workers must be positive")
+	}
+
+	backend, err := rawdb.NewLevelDBDatabase(dbPath, 1024, 100, "srcDB", true)
+	if err != nil {
+		return fmt.Errorf("substate-cli db verify: error opening %s: %v", dbPath, err)
+	}
+	db := substate.NewSubstateDB(backend)
+	defer db.Close()
+
+	if numProcs := workers + 2; runtime.GOMAXPROCS(0) < numProcs {
+		runtime.GOMAXPROCS(numProcs)
+	}
+
+	reporter, err := progress.NewReporter(ctx, "substate-cli db verify", first, last)
+	if err != nil {
+		return fmt.Errorf("substate-cli db verify: %v", err)
+	}
+
+	start := time.Now()
+	var (
+		mu         sync.Mutex
+		numTx      int
+		numBlock   int
+		checkCount = map[string]int{}
+	)
+	blocks := make(chan uint64, workers*10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range blocks {
+				n, gas, issues := verifyBlock(backend, db, block)
+				reporter.Add(block, 1, uint64(n), gas)
+				mu.Lock()
+				numTx += n
+				if n > 0 {
+					numBlock++
+				}
+				for _, issue := range issues {
+					fmt.Printf("substate-cli db verify: %v\n", issue)
+					checkCount[issue.check]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for block := first; block <= last; block++ {
+		blocks <- block
+		if block == last {
+			break
+		}
+	}
+	close(blocks)
+	wg.Wait()
+	if err := reporter.Close(); err != nil {
+		return fmt.Errorf("substate-cli db verify: %v", err)
+	}
+
+	fmt.Printf("substate-cli db verify: block range = %v %v\n", first, last)
+	fmt.Printf("substate-cli db verify: total #block = %v\n", numBlock)
+	fmt.Printf("substate-cli db verify: total #tx    = %v\n", numTx)
+	fmt.Printf("substate-cli db verify: done in %v\n", time.Since(start).Round(1*time.Millisecond))
+
+	if len(checkCount) == 0 {
+		fmt.Printf("substate-cli db verify: no problems found\n")
+		return nil
+	}
+	checks := make([]string, 0, len(checkCount))
+	total := 0
+	for check, count := range checkCount {
+		checks = append(checks, check)
+		total += count
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		fmt.Printf("substate-cli db verify: %v: %v problems\n", check, checkCount[check])
+	}
+	return fmt.Errorf("substate-cli db verify: found %v problems in %v", total, dbPath)
+}
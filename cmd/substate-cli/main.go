@@ -19,6 +19,9 @@ var (
 		Subcommands: []*cli.Command{
 			&db.CloneCommand,
 			&db.CompactCommand,
+			&db.VerifyCommand,
+			&db.StatsCommand,
+			&db.MergeCommand,
 		},
 	}
 )
@@ -37,13 +40,23 @@ func main() {
 		Flags:		[]cli.Flag{},
 		Commands:	[]*cli.Command{
 			&replay.ReplayCommand,
+			&replay.ReplayForkCommand,
+			&replay.TxCommand,
 			&replay.GetStorageUpdateSizeCommand,
 			&replay.GetCodeCommand,
 			&replay.GetCodeSizeCommand,
 			&replay.SubstateDumpCommand,
+			&replay.ExportStateTestCommand,
+			&replay.ImportCommand,
 			&replay.GetAddressStatsCommand,
 			&replay.GetKeyStatsCommand,
 			&replay.GetLocationStatsCommand,
+			&replay.GetAddressLocalityCommand,
+			&replay.GetKeyLocalityCommand,
+			&replay.GetLocationLocalityCommand,
+			&replay.ReportCommand,
+			&replay.GetGasStatsCommand,
+			&replay.GetStorageAccessCommand,
 			&dbCommand,
 		},
 	}
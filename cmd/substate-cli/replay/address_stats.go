@@ -1,6 +1,10 @@
 package replay
 
 import (
+	"bytes"
+	"sort"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
@@ -14,8 +18,12 @@ var GetAddressStatsCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&OutputFlag,
 	},
 	Description: `
 The substate-cli address-stats command requires two arguments:
@@ -25,20 +33,29 @@ The substate-cli address-stats command requires two arguments:
 last block of the inclusive range of blocks to be analysed.
 
 Statistics on the usage of addresses are printed to the console.
+With --output, the reference frequency distribution is also written to a
+CSV, JSON-lines or SQLite file.
 `,
 }
 
 // getAddressStatsAction collects statistical information on the usage
 // of addresses in transactions.
 func getAddressStatsAction(ctx *cli.Context) error {
-	return getReferenceStatsAction(ctx, "address-stats", func(info *TransactionInfo) []common.Address {
-		addresses := []common.Address{}
-		for address := range info.st.InputAlloc {
-			addresses = append(addresses, address)
-		}
-		for address := range info.st.OutputAlloc {
-			addresses = append(addresses, address)
-		}
-		return addresses
+	return getReferenceStatsAction(ctx, "address-stats", extractAddresses)
+}
+
+// extractAddresses returns the addresses accessed by a transaction.
+func extractAddresses(info *TransactionInfo) []common.Address {
+	addresses := []common.Address{}
+	for address := range info.st.InputAlloc {
+		addresses = append(addresses, address)
+	}
+	for address := range info.st.OutputAlloc {
+		addresses = append(addresses, address)
+	}
+	// sorted, since the order of map iteration is random
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
 	})
+	return addresses
 }
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ChainProfile describes the rules of a chain used for replaying its
+// transactions. Profiles are loaded from JSON files of the form
+//
+//	{
+//	  "profiles": [{
+//	    "name": "devnet",
+//	    "chainId": 4003,
+//	    "forks": {"berlin": 0, "london": 100},
+//	    "noBaseFee": true,
+//	    "vm": {"interpreter": "lfvm", "extraEips": [3855]}
+//	  }]
+//	}
+//
+// Forks missing in a profile are active from the genesis block.
+type ChainProfile struct {
+	Name      string            `json:"name"`
+	ChainID   uint64            `json:"chainId"`
+	Forks     map[string]uint64 `json:"forks,omitempty"`
+	NoBaseFee *bool             `json:"noBaseFee,omitempty"`
+	VM        VMConfigOverrides `json:"vm"`
+}
+
+// VMConfigOverrides lists the parts of the VM configuration a chain profile
+// may change.
+type VMConfigOverrides struct {
+	Interpreter string `json:"interpreter,omitempty"`
+	ExtraEips   []int  `json:"extraEips,omitempty"`
+}
+
+// ChainProfileFile is the content of a --chain-config file.
+type ChainProfileFile struct {
+	Profiles []*ChainProfile `json:"profiles"`
+}
+
+// BuiltinChainProfiles are the profiles of the public Opera networks.
+var BuiltinChainProfiles = []*ChainProfile{
+	{
+		Name:    "mainnet",
+		ChainID: 250,
+		Forks:   map[string]uint64{"berlin": 37455223, "london": 37534833},
+	},
+	{
+		Name:    "testnet",
+		ChainID: 4002,
+		Forks:   map[string]uint64{"berlin": 1559470, "london": 7513335},
+	},
+}
+
+// chainForks maps fork names used in chain profiles to the corresponding
+// block fields of a chain configuration.
+var chainForks = map[string]func(*params.ChainConfig) **big.Int{
+	"homestead":      func(c *params.ChainConfig) **big.Int { return &c.HomesteadBlock },
+	"eip150":         func(c *params.ChainConfig) **big.Int { return &c.EIP150Block },
+	"eip155":         func(c *params.ChainConfig) **big.Int { return &c.EIP155Block },
+	"eip158":         func(c *params.ChainConfig) **big.Int { return &c.EIP158Block },
+	"byzantium":      func(c *params.ChainConfig) **big.Int { return &c.ByzantiumBlock },
+	"constantinople": func(c *params.ChainConfig) **big.Int { return &c.ConstantinopleBlock },
+	"petersburg":     func(c *params.ChainConfig) **big.Int { return &c.PetersburgBlock },
+	"istanbul":       func(c *params.ChainConfig) **big.Int { return &c.IstanbulBlock },
+	"muirglacier":    func(c *params.ChainConfig) **big.Int { return &c.MuirGlacierBlock },
+	"berlin":         func(c *params.ChainConfig) **big.Int { return &c.BerlinBlock },
+	"london":         func(c *params.ChainConfig) **big.Int { return &c.LondonBlock },
+}
+
+// validate checks the profile for unknown fork names.
+func (p *ChainProfile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("chain profile without name")
+	}
+	for fork := range p.Forks {
+		if _, exists := chainForks[fork]; !exists {
+			return fmt.Errorf("chain profile %v: unknown fork %q", p.Name, fork)
+		}
+	}
+	return nil
+}
+
+// ChainConfig creates a new chain configuration for the profile. Each call
+// returns an independent copy which may be modified by the caller.
+func (p *ChainProfile) ChainConfig() *params.ChainConfig {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = new(big.Int).SetUint64(p.ChainID)
+	for fork, block := range p.Forks {
+		*chainForks[fork](&config) = new(big.Int).SetUint64(block)
+	}
+	return &config
+}
+
+// ApplyVMConfig applies the base-fee behaviour and VM overrides of the
+// profile. Replays ignore base fees unless the profile says otherwise.
+func (p *ChainProfile) ApplyVMConfig(config *vm.Config) {
+	config.NoBaseFee = true
+	if p.NoBaseFee != nil {
+		config.NoBaseFee = *p.NoBaseFee
+	}
+	if p.VM.Interpreter != "" {
+		config.InterpreterImpl = p.VM.Interpreter
+	}
+	if len(p.VM.ExtraEips) > 0 {
+		config.ExtraEips = append([]int{}, p.VM.ExtraEips...)
+	}
+}
+
+// LoadChainProfiles reads the chain profiles of a --chain-config file.
+func LoadChainProfiles(filename string) ([]*ChainProfile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read chain config %v: %v", filename, err)
+	}
+	var file ChainProfileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse chain config %v: %v", filename, err)
+	}
+	for _, profile := range file.Profiles {
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("invalid chain config %v: %v", filename, err)
+		}
+	}
+	return file.Profiles, nil
+}
+
+// getChainProfile selects a chain profile by name or, if no name is given, by
+// chain ID. Profiles of the chain config file take precedence over built-in
+// profiles. Unknown chain IDs fall back to a profile with all forks active.
+func getChainProfile(filename string, name string, chainID uint64) (*ChainProfile, error) {
+	profiles := []*ChainProfile{}
+	if filename != "" {
+		loaded, err := LoadChainProfiles(filename)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, loaded...)
+	}
+	profiles = append(profiles, BuiltinChainProfiles...)
+
+	if name != "" {
+		for _, profile := range profiles {
+			if profile.Name == name {
+				return profile, nil
+			}
+		}
+		names := make([]string, 0, len(profiles))
+		for _, profile := range profiles {
+			names = append(names, profile.Name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown chain profile %q, available profiles: %v", name, strings.Join(names, ", "))
+	}
+
+	for _, profile := range profiles {
+		if profile.ChainID == chainID {
+			return profile, nil
+		}
+	}
+	return &ChainProfile{Name: fmt.Sprintf("chain-%d", chainID), ChainID: chainID}, nil
+}
@@ -0,0 +1,165 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// checkpointInterval is the minimum time between two checkpoint file updates.
+var checkpointInterval = 30 * time.Second
+
+// Checkpoint is the persisted progress of a command processing a block range.
+// All blocks from First up to and including Block are completed and their
+// statistics are accumulated in Stats.
+type Checkpoint[S any] struct {
+	Command string `json:"command"`
+	First   uint64 `json:"first"`
+	Last    uint64 `json:"last"`
+	Block   uint64 `json:"block"`
+	Started bool   `json:"started"` // false if no block is completed yet
+	Stats   S      `json:"stats"`
+}
+
+// Next returns the first block which is not completed yet.
+func (c *Checkpoint[S]) Next() uint64 {
+	if !c.Started {
+		return c.First
+	}
+	return c.Block + 1
+}
+
+// LoadCheckpoint reads a checkpoint file and checks that it was written by the
+// given command for the given block range.
+func LoadCheckpoint[S any](filename, command string, first, last uint64) (*Checkpoint[S], error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint %v: %v", filename, err)
+	}
+	checkpoint := &Checkpoint[S]{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("cannot parse checkpoint %v: %v", filename, err)
+	}
+	if checkpoint.Command != command {
+		return nil, fmt.Errorf("checkpoint %v was written by %v, not by %v", filename, checkpoint.Command, command)
+	}
+	if checkpoint.First != first || checkpoint.Last != last {
+		return nil, fmt.Errorf("checkpoint %v covers block range %v %v, not %v %v", filename, checkpoint.First, checkpoint.Last, first, last)
+	}
+	return checkpoint, nil
+}
+
+// BlockCheckpointer collects the statistics of completed blocks and merges
+// them in block order into a checkpoint, which is periodically written to a
+// file. Blocks completed out of order are kept back until all preceding blocks
+// are completed, such that a resumed run accumulates exactly the same
+// statistics as an uninterrupted one. It is safe to be used by multiple
+// workers concurrently.
+type BlockCheckpointer[S any] struct {
+	filename   string
+	merge      func(total *S, block *S)
+	mu         sync.Mutex
+	checkpoint *Checkpoint[S]
+	pending    map[uint64]*S
+	lastSave   time.Time
+}
+
+// NewBlockCheckpointer creates a checkpointer continuing the given checkpoint.
+func NewBlockCheckpointer[S any](filename string, checkpoint *Checkpoint[S], merge func(total *S, block *S)) *BlockCheckpointer[S] {
+	return &BlockCheckpointer[S]{
+		filename:   filename,
+		merge:      merge,
+		checkpoint: checkpoint,
+		pending:    map[uint64]*S{},
+		lastSave:   time.Now(),
+	}
+}
+
+// Complete registers the statistics of a completed block.
+func (c *BlockCheckpointer[S]) Complete(block uint64, stats *S) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[block] = stats
+	for {
+		next := c.checkpoint.Next()
+		stats, exists := c.pending[next]
+		if !exists {
+			break
+		}
+		delete(c.pending, next)
+		c.merge(&c.checkpoint.Stats, stats)
+		c.checkpoint.Block = next
+		c.checkpoint.Started = true
+	}
+	if time.Since(c.lastSave) < checkpointInterval {
+		return nil
+	}
+	return c.save()
+}
+
+// Save writes the current checkpoint to the checkpoint file.
+func (c *BlockCheckpointer[S]) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+func (c *BlockCheckpointer[S]) save() error {
+	data, err := json.MarshalIndent(c.checkpoint, "", " ")
+	if err != nil {
+		return fmt.Errorf("cannot encode checkpoint: %v", err)
+	}
+	// write to a temporary file first to never leave a partial checkpoint
+	tmp := c.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("cannot write checkpoint %v: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, c.filename); err != nil {
+		return fmt.Errorf("cannot write checkpoint %v: %v", c.filename, err)
+	}
+	c.lastSave = time.Now()
+	return nil
+}
+
+// BlockTaskFunc processes a transaction and records its statistics in the
+// statistics of its block.
+type BlockTaskFunc[S any] func(block uint64, tx int, recording *substate.Substate, stats *S) error
+
+// executeWithCheckpoints runs the task on all transactions of the pool's block
+// range and registers each completed block with the checkpointer. The final
+// checkpoint is written even if the execution fails.
+func executeWithCheckpoints[S any](pool *substate.SubstateTaskPool, checkpointer *BlockCheckpointer[S], task BlockTaskFunc[S]) error {
+	// All transactions of a block are processed within the block function,
+	// which is the only place where the completion of a block is known.
+	pool.TaskFunc = nil
+	pool.BlockFunc = func(block uint64, transactions map[int]*substate.Substate, pool *substate.SubstateTaskPool) error {
+		txs := make([]int, 0, len(transactions))
+		for tx := range transactions {
+			txs = append(txs, tx)
+		}
+		sort.Ints(txs)
+
+		stats := new(S)
+		for _, tx := range txs {
+			if progress.IsSkipped(pool, transactions[tx]) {
+				continue
+			}
+			if err := task(block, tx, transactions[tx], stats); err != nil {
+				return fmt.Errorf("%v_%v: %v", block, tx, err)
+			}
+		}
+		return checkpointer.Complete(block, stats)
+	}
+
+	err := progress.ExecuteTaskPool(pool)
+	if saveErr := checkpointer.Save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
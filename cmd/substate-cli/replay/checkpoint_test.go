@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testStats struct {
+	Blocks []uint64 `json:"blocks"`
+}
+
+func mergeTestStats(total *testStats, block *testStats) {
+	total.Blocks = append(total.Blocks, block.Blocks...)
+}
+
+func TestBlockCheckpointerMergesBlocksInOrder(t *testing.T) {
+	checkpoint := &Checkpoint[testStats]{Command: "test", First: 10, Last: 20}
+	checkpointer := NewBlockCheckpointer(filepath.Join(t.TempDir(), "checkpoint"), checkpoint, mergeTestStats)
+
+	tests := []struct {
+		complete uint64
+		next     uint64 // first block not completed afterwards
+		merged   []uint64
+	}{
+		{complete: 12, next: 10, merged: nil},
+		{complete: 11, next: 10, merged: nil},
+		{complete: 10, next: 13, merged: []uint64{10, 11, 12}},
+		{complete: 14, next: 13, merged: []uint64{10, 11, 12}},
+		{complete: 13, next: 15, merged: []uint64{10, 11, 12, 13, 14}},
+	}
+	for _, test := range tests {
+		if err := checkpointer.Complete(test.complete, &testStats{Blocks: []uint64{test.complete}}); err != nil {
+			t.Fatalf("cannot complete block %d: %v", test.complete, err)
+		}
+		if got := checkpoint.Next(); got != test.next {
+			t.Errorf("unexpected next block after completing block %d, wanted %d, got %d", test.complete, test.next, got)
+		}
+		if !reflect.DeepEqual(checkpoint.Stats.Blocks, test.merged) {
+			t.Errorf("unexpected statistics after completing block %d, wanted %v, got %v", test.complete, test.merged, checkpoint.Stats.Blocks)
+		}
+	}
+}
+
+func TestBlockCheckpointerSaveAndResume(t *testing.T) {
+	defer func(interval time.Duration) { checkpointInterval = interval }(checkpointInterval)
+	checkpointInterval = time.Hour
+
+	filename := filepath.Join(t.TempDir(), "checkpoint")
+	checkpoint := &Checkpoint[testStats]{Command: "test", First: 10, Last: 20}
+	checkpointer := NewBlockCheckpointer(filename, checkpoint, mergeTestStats)
+	for _, block := range []uint64{10, 11, 13} {
+		if err := checkpointer.Complete(block, &testStats{Blocks: []uint64{block}}); err != nil {
+			t.Fatalf("cannot complete block %d: %v", block, err)
+		}
+	}
+	if _, err := LoadCheckpoint[testStats](filename, "test", 10, 20); err == nil {
+		t.Fatalf("checkpoint was saved before the checkpoint interval elapsed")
+	}
+	if err := checkpointer.Save(); err != nil {
+		t.Fatalf("cannot save checkpoint: %v", err)
+	}
+
+	resumed, err := LoadCheckpoint[testStats](filename, "test", 10, 20)
+	if err != nil {
+		t.Fatalf("cannot load checkpoint: %v", err)
+	}
+	// block 13 is pending on block 12 and replayed by the resumed run
+	if resumed.Next() != 12 {
+		t.Errorf("unexpected next block of resumed run, wanted 12, got %d", resumed.Next())
+	}
+	if want := []uint64{10, 11}; !reflect.DeepEqual(resumed.Stats.Blocks, want) {
+		t.Errorf("unexpected statistics of resumed run, wanted %v, got %v", want, resumed.Stats.Blocks)
+	}
+
+	if _, err := LoadCheckpoint[testStats](filename, "other", 10, 20); err == nil {
+		t.Errorf("checkpoint of another command was accepted")
+	}
+	if _, err := LoadCheckpoint[testStats](filename, "test", 10, 21); err == nil {
+		t.Errorf("checkpoint of another block range was accepted")
+	}
+}
+
+func TestCheckpointNext(t *testing.T) {
+	checkpoint := &Checkpoint[testStats]{First: 5, Last: 9}
+	if checkpoint.Next() != 5 {
+		t.Errorf("unexpected next block of new checkpoint, wanted 5, got %d", checkpoint.Next())
+	}
+	checkpoint.Started, checkpoint.Block = true, 5
+	if checkpoint.Next() != 6 {
+		t.Errorf("unexpected next block, wanted 6, got %d", checkpoint.Next())
+	}
+}
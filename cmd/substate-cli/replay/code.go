@@ -5,6 +5,7 @@ import (
 	"log"
 	"sync"
 
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -19,6 +20,9 @@ var GetCodeCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ContractDBFlag,
 		&ChainIDFlag,
@@ -106,7 +110,7 @@ func getCodeAction(ctx *cli.Context) error {
 	CodeRegistry = make(map[common.Address][]byte)
 
 	taskPool := substate.NewSubstateTaskPool("substate-cli code", getCodeTask, first, last, ctx)
-	err = taskPool.Execute()
+	err = progress.ExecuteTaskPool(taskPool)
 
 	writeContracts()
 	return err
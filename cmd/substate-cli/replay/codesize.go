@@ -3,7 +3,9 @@ package replay
 import (
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
 )
@@ -16,8 +18,12 @@ var GetCodeSizeCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&OutputFlag,
 	},
 	Description: `
 The substate-cli code-size command requires two arguments:
@@ -26,30 +32,42 @@ The substate-cli code-size command requires two arguments:
 <blockNumFirst> and <blockNumLast> are the first and
 last block of the inclusive range of blocks to replay transactions.
 
-Output log format: (block, timestamp, transaction, account, code size, nonce, transaction type)`,
+Output log format: (block, timestamp, transaction, account, code size, nonce, transaction type)
+
+With --output, the rows are written to the substate_code_size table of a CSV,
+JSON-lines or SQLite file instead.`,
 }
 
-func GetTxType(to *common.Address, alloc substate.SubstateAlloc) string {
-	if to == nil {
-		return "create"
-	}
-	account, hasReceiver := alloc[*to]
-	if to != nil && (!hasReceiver || len(account.Code) == 0) {
-		return "transfer"
-	}
-	if to != nil && (hasReceiver && len(account.Code) > 0) {
-		return "call"
+// codeSizeSchema declares the rows of the code-size command.
+var codeSizeSchema = &metrics.Schema{
+	Table: "substate_code_size",
+	Columns: []metrics.Column{
+		{Name: "block_number", Type: metrics.Integer},
+		{Name: "block_timestamp", Type: metrics.Integer},
+		{Name: "tx_number", Type: metrics.Integer},
+		{Name: "contract", Type: metrics.Text},
+		{Name: "code_size_bytes", Type: metrics.Integer},
+		{Name: "nonce", Type: metrics.Integer},
+		{Name: "tx_type", Type: metrics.Text},
+	},
+	PrimaryKey: []string{"block_number", "tx_number", "contract"},
+}
+
+// newCodeSizeTask creates a task writing code sizes and nonces to the given
+// sink.
+func newCodeSizeTask(sink metrics.Sink) substate.SubstateTaskFunc {
+	return func(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		return getCodeSizeTask(sink, block, tx, st)
 	}
-	return "unknown"
 }
 
 // getCodeSizeTask returns codesize and nonce of accounts in a substate
-func getCodeSizeTask(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+func getCodeSizeTask(sink metrics.Sink, block uint64, tx int, st *substate.Substate) error {
 	to := st.Message.To
 	timestamp := st.Env.Timestamp
-	txType := GetTxType(to, st.InputAlloc)
+	txType := utils.GetTxType(to, st.InputAlloc)
 	for account, accountInfo := range st.OutputAlloc {
-		fmt.Printf("metric: %v,%v,%v,%v,%v,%v,%v\n",
+		err := sink.Write(codeSizeSchema.Table,
 			block,
 			timestamp,
 			tx,
@@ -57,10 +75,13 @@ func getCodeSizeTask(block uint64, tx int, st *substate.Substate, taskPool *subs
 			len(accountInfo.Code),
 			accountInfo.Nonce,
 			txType)
+		if err != nil {
+			return err
+		}
 	}
 	for account, accountInfo := range st.InputAlloc {
 		if _, found := st.OutputAlloc[account]; !found {
-			fmt.Printf("metric: %v,%v,%v,%v,%v,%v,%v\n",
+			err := sink.Write(codeSizeSchema.Table,
 				block,
 				timestamp,
 				tx,
@@ -68,6 +89,9 @@ func getCodeSizeTask(block uint64, tx int, st *substate.Substate, taskPool *subs
 				len(accountInfo.Code),
 				accountInfo.Nonce,
 				txType)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -95,7 +119,15 @@ func getCodeSizeAction(ctx *cli.Context) error {
 	substate.OpenSubstateDBReadOnly()
 	defer substate.CloseSubstateDB()
 
-	taskPool := substate.NewSubstateTaskPool("substate-cli storage", getCodeSizeTask, first, last, ctx)
-	err = taskPool.Execute()
+	sink, err := openMetricsSink(ctx, "code-size", codeSizeSchema)
+	if err != nil {
+		return err
+	}
+
+	taskPool := substate.NewSubstateTaskPool("substate-cli storage", newCodeSizeTask(sink), first, last, ctx)
+	err = progress.ExecuteTaskPool(taskPool)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
 	return err
 }
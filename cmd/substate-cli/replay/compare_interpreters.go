@@ -0,0 +1,172 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// InterpreterDivergenceError is returned if two interpreters compared by
+// replay --compare-interpreter do not agree on the outcome of a transaction.
+type InterpreterDivergenceError struct {
+	Verdict string
+	Diffs   []*TransactionDiff
+	Errs    []error // errors of interpreters failing to run the transaction
+}
+
+func (e *InterpreterDivergenceError) Error() string {
+	msg := e.Category()
+	for _, err := range e.Errs {
+		msg += fmt.Sprintf("; %v", err)
+	}
+	return msg
+}
+
+// Category returns the error without the errors of the interpreters.
+func (e *InterpreterDivergenceError) Category() string {
+	return fmt.Sprintf("interpreter divergence: %v", e.Verdict)
+}
+
+// parseInterpreterPair parses a comma separated pair of interpreter names.
+func parseInterpreterPair(impls string) ([]string, error) {
+	pair := strings.Split(impls, ",")
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("substate-cli replay: --%v requires exactly two interpreters, got %q", CompareInterpreterFlag.Name, impls)
+	}
+	for i := range pair {
+		pair[i] = strings.TrimSpace(pair[i])
+	}
+	if pair[0] == pair[1] {
+		return nil, fmt.Errorf("substate-cli replay: --%v requires two different interpreters, got %q", CompareInterpreterFlag.Name, impls)
+	}
+	return pair, nil
+}
+
+// copyAlloc creates a deep copy of a substate allocation such that every
+// interpreter starts from an untouched input state.
+func copyAlloc(alloc substate.SubstateAlloc) substate.SubstateAlloc {
+	res := make(substate.SubstateAlloc, len(alloc))
+	for addr, account := range alloc {
+		res[addr] = account.Copy()
+	}
+	return res
+}
+
+// compareInterpretersTask replays a transaction on both configured
+// interpreters, compares each outcome with the recording and the outcomes with
+// each other.
+func compareInterpretersTask(config ReplayConfig, block uint64, tx int, recording *substate.Substate) error {
+	implA, implB := config.compare_impls[0], config.compare_impls[1]
+	contract := recording.Message.To
+
+	// both interpreters are run even if one fails, a failure of a single
+	// interpreter is a divergence as well
+	runA := runInterpreter(config, implA, block, tx, recording)
+	runB := runInterpreter(config, implB, block, tx, recording)
+	if runA.err != nil || runB.err != nil {
+		return reportInterpreterFailure(config, block, tx, recording, runA, runB)
+	}
+	tracerA, resultA, allocA := runA.tracer, runA.result, runA.alloc
+	tracerB, resultB, allocB := runB.tracer, runB.result, runB.alloc
+
+	diffA := NewTransactionDiff(block, tx, contract, recording.Result, recording.OutputAlloc, resultA, allocA)
+	diffB := NewTransactionDiff(block, tx, contract, recording.Result, recording.OutputAlloc, resultB, allocB)
+	if err := config.trace.Finish(tracerA, block, tx, implA, diffA != nil || diffB != nil); err != nil {
+		return err
+	}
+	if err := config.trace.Finish(tracerB, block, tx, implB, diffA != nil || diffB != nil); err != nil {
+		return err
+	}
+	if diffA == nil && diffB == nil {
+		return nil
+	}
+
+	// the recorded outcome serves as reference; the cross diff tells whether
+	// both interpreters failed in the same way
+	var verdict string
+	var diffs []*TransactionDiff
+	switch {
+	case diffB == nil:
+		verdict = fmt.Sprintf("%v diverged", implA)
+		diffA.Interpreter = implA
+		diffs = append(diffs, diffA)
+	case diffA == nil:
+		verdict = fmt.Sprintf("%v diverged", implB)
+		diffB.Interpreter = implB
+		diffs = append(diffs, diffB)
+	default:
+		diffA.Interpreter = implA
+		diffB.Interpreter = implB
+		diffs = append(diffs, diffA, diffB)
+		cross := NewTransactionDiff(block, tx, contract, resultA, allocA, resultB, allocB)
+		if cross == nil {
+			verdict = "both diverged identically"
+		} else {
+			verdict = "both diverged differently"
+			cross.Interpreter = fmt.Sprintf("%v vs %v", implA, implB)
+			diffs = append(diffs, cross)
+		}
+	}
+
+	return reportDivergence(config, block, tx, &InterpreterDivergenceError{Verdict: verdict, Diffs: diffs})
+}
+
+// interpreterRun is the outcome of a transaction run by one interpreter.
+type interpreterRun struct {
+	impl   string
+	tracer TransactionTracer
+	result *substate.SubstateResult
+	alloc  substate.SubstateAlloc
+	err    error
+}
+
+func runInterpreter(config ReplayConfig, impl string, block uint64, tx int, recording *substate.Substate) *interpreterRun {
+	run := &interpreterRun{impl: impl, tracer: config.trace.NewTracer(block, tx, recording)}
+	run.result, run.alloc, run.err = runSubstate(config, impl, block, tx, recording, copyAlloc(recording.InputAlloc), run.tracer)
+	return run
+}
+
+// reportInterpreterFailure reports a transaction which at least one of the
+// compared interpreters failed to run. The outcome of an interpreter running
+// the transaction is compared with the recording.
+func reportInterpreterFailure(config ReplayConfig, block uint64, tx int, recording *substate.Substate, runA, runB *interpreterRun) error {
+	divergence := &InterpreterDivergenceError{}
+	for _, run := range []*interpreterRun{runA, runB} {
+		if err := config.trace.Finish(run.tracer, block, tx, run.impl, true); err != nil {
+			return err
+		}
+		if run.err != nil {
+			divergence.Errs = append(divergence.Errs, fmt.Errorf("%v: %v", run.impl, run.err))
+		} else if diff := NewTransactionDiff(block, tx, recording.Message.To, recording.Result, recording.OutputAlloc, run.result, run.alloc); diff != nil {
+			diff.Interpreter = run.impl
+			divergence.Diffs = append(divergence.Diffs, diff)
+		}
+	}
+	switch {
+	case runA.err != nil && runB.err != nil:
+		divergence.Verdict = "both failed"
+	case runA.err != nil:
+		divergence.Verdict = fmt.Sprintf("%v failed", runA.impl)
+	default:
+		divergence.Verdict = fmt.Sprintf("%v failed", runB.impl)
+	}
+	return reportDivergence(config, block, tx, divergence)
+}
+
+// reportDivergence prints a divergence and writes its diffs to the report.
+func reportDivergence(config ReplayConfig, block uint64, tx int, divergence *InterpreterDivergenceError) error {
+	fmt.Printf("block: %v Transaction: %v %v\n", block, tx, divergence.Verdict)
+	for _, err := range divergence.Errs {
+		fmt.Printf("  %v\n", err)
+	}
+	for _, diff := range divergence.Diffs {
+		diff.Print()
+		if config.report != nil {
+			if err := config.report.Write(diff); err != nil {
+				return err
+			}
+		}
+	}
+	return divergence
+}
@@ -20,9 +20,17 @@ var (
 		Usage: "ChainID for replayer",
 		Value: 250,
 	}
+	ChainConfigFlag = cli.StringFlag{
+		Name:  "chain-config",
+		Usage: "JSON file with additional chain profiles",
+	}
+	ChainProfileFlag = cli.StringFlag{
+		Name:  "chain-profile",
+		Usage: "name of the chain profile to be used (default: profile matching --chainid)",
+	}
 	ProfileEVMCallFlag = cli.BoolFlag{
 		Name:  "profiling-call",
-		Usage: "enable profiling for EVM call",
+		Usage: "write the runtime and gas used of every call transaction to the contract_runtime table",
 	}
 	MicroProfilingFlag = cli.BoolFlag{
 		Name:  "micro-profiling",
@@ -40,6 +48,43 @@ var (
 		Name:  "interpreter",
 		Usage: "select the interpreter version to be used",
 	}
+	CrossCheckStateDbFlag = cli.BoolFlag{
+		Name:  "cross-check-statedb",
+		Usage: "run the off-the-chain and the in-memory StateDB side by side and report their first disagreement",
+	}
+	CompareInterpreterFlag = cli.StringFlag{
+		Name:  "compare-interpreter",
+		Usage: "replay each transaction on two interpreters and compare them, e.g. geth,lfvm",
+	}
+	TraceDirFlag = cli.StringFlag{
+		Name:  "trace",
+		Usage: "write EVM traces of the selected transactions as JSON files to the given directory",
+	}
+	TracerFlag = cli.StringFlag{
+		Name:  "tracer",
+		Usage: "tracer used by --trace, struct or call",
+		Value: "struct",
+	}
+	TraceTxFlag = cli.StringFlag{
+		Name:  "trace-tx",
+		Usage: "comma separated list of blocks and block:tx pairs to be traced (default: all)",
+	}
+	TraceAddressFlag = cli.StringFlag{
+		Name:  "trace-address",
+		Usage: "comma separated list of senders and recipients of transactions to be traced (default: all)",
+	}
+	TraceOnlyMismatchingFlag = cli.BoolFlag{
+		Name:  "trace-only-mismatching",
+		Usage: "only keep traces of transactions with inconsistent outputs",
+	}
+	CheckpointFlag = cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "periodically save the progress of the run to the given file",
+	}
+	ResumeFlag = cli.BoolFlag{
+		Name:  "resume",
+		Usage: "resume the run from the file given by --checkpoint",
+	}
 	CpuProfilingFlag = cli.StringFlag{
 		Name:  "cpuprofile",
 		Usage: "the file name where to write a CPU profile of the evaluation step to",
@@ -48,6 +93,52 @@ var (
 		Name:  "faststatedb",
 		Usage: "enables a faster, yet still experimental StateDB implementation",
 	}
+	ReportFileFlag = cli.StringFlag{
+		Name:  "report",
+		Usage: "write inconsistencies found during replay as JSON lines to the given file",
+	}
+	ContinueOnErrorFlag = cli.BoolFlag{
+		Name:  "continue-on-error",
+		Usage: "record failing transactions and continue replaying",
+	}
+	OutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "write metrics to a .csv, .jsonl or .db (SQLite) file instead of printing metric: lines",
+	}
+	TopFlag = cli.IntFlag{
+		Name:  "top",
+		Usage: "number of contracts listed in each ranking of a report",
+		Value: 10,
+	}
+	RankingsFlag = cli.StringFlag{
+		Name:  "rankings",
+		Usage: "comma separated list of report rankings: runtime, gas, storage, code-size",
+		Value: "runtime,gas,storage,code-size",
+	}
+	FormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "report format: text, json or markdown",
+		Value: "text",
+	}
+	ReportOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "write the report to the given file instead of the console",
+	}
+	StateTestDirFlag = cli.StringFlag{
+		Name:  "statetest-dir",
+		Usage: "directory to which state test fixtures are written",
+		Value: "./statetests",
+	}
+	FirstBlockFlag = cli.Uint64Flag{
+		Name:  "first-block",
+		Usage: "block number at which the first imported transaction is stored",
+		Value: 1,
+	}
+	MaxFailuresFlag = cli.IntFlag{
+		Name:  "max-failures",
+		Usage: "abort a replay with --continue-on-error after the given number of failures (0 = no limit)",
+		Value: 0,
+	}
 	DatabaseNameFlag = cli.StringFlag{
 		Name:  "db",
 		Usage: "set a database name for storing micro-profiling results",
@@ -58,6 +149,11 @@ var (
 		Usage: "set a buffer size for profiling channel",
 		Value: 100000,
 	}
+	BasicBlockDatabaseNameFlag = cli.StringFlag{
+		Name:  "basic-block-db",
+		Usage: "set a database name for storing basic-block profiling results",
+		Value: "./basic-block-profiling.db",
+	}
 	// contract-db filename
 	ContractDBFlag = cli.StringFlag{
 		Name:  "contractdb",
@@ -0,0 +1,333 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// DiffKind classifies a single difference between a recorded and a replayed
+// transaction.
+type DiffKind string
+
+const (
+	DiffStatus          DiffKind = "status"
+	DiffContractAddress DiffKind = "contract-address"
+	DiffGasUsed         DiffKind = "gas-used"
+	DiffBloom           DiffKind = "bloom"
+	DiffLogCount        DiffKind = "log-count"
+	DiffLogAddress      DiffKind = "log-address"
+	DiffLogTopics       DiffKind = "log-topics"
+	DiffLogData         DiffKind = "log-data"
+	DiffAllocSize       DiffKind = "alloc-size"
+	DiffAccountMissing  DiffKind = "account-missing"
+	DiffAccountExtra    DiffKind = "account-extra"
+	DiffNonce           DiffKind = "nonce"
+	DiffBalance         DiffKind = "balance"
+	DiffCode            DiffKind = "code"
+	DiffStorageSize     DiffKind = "storage-size"
+	DiffStorageMissing  DiffKind = "storage-missing"
+	DiffStorageExtra    DiffKind = "storage-extra"
+	DiffStorageValue    DiffKind = "storage-value"
+)
+
+// FieldDiff describes a single field whose expected (recorded) value differs
+// from the value observed while replaying.
+type FieldDiff struct {
+	Kind    DiffKind        `json:"kind"`
+	Field   string          `json:"field"`
+	Account *common.Address `json:"account,omitempty"`
+	Key     *common.Hash    `json:"key,omitempty"`
+	Want    string          `json:"want,omitempty"`
+	Have    string          `json:"have,omitempty"`
+}
+
+// TransactionDiff collects all differences found for a single transaction.
+type TransactionDiff struct {
+	Block       uint64          `json:"block"`
+	Tx          int             `json:"tx"`
+	Contract    *common.Address `json:"contract,omitempty"`
+	Interpreter string          `json:"interpreter,omitempty"`
+	Diffs       []FieldDiff     `json:"diffs"`
+}
+
+// NewTransactionDiff compares an expected and an observed transaction outcome
+// and returns nil if they are equal.
+func NewTransactionDiff(block uint64, tx int, contract *common.Address, wantResult *substate.SubstateResult, wantAlloc substate.SubstateAlloc, haveResult *substate.SubstateResult, haveAlloc substate.SubstateAlloc) *TransactionDiff {
+	if wantResult.Equal(haveResult) && wantAlloc.Equal(haveAlloc) {
+		return nil
+	}
+	diffs := DiffResult(wantResult, haveResult)
+	diffs = append(diffs, DiffAlloc(wantAlloc, haveAlloc)...)
+	return &TransactionDiff{
+		Block:    block,
+		Tx:       tx,
+		Contract: contract,
+		Diffs:    diffs,
+	}
+}
+
+// InconsistentOutputError is returned by a replay whose result or
+// post-allocation does not match the recording.
+type InconsistentOutputError struct {
+	Diff *TransactionDiff
+}
+
+func (e *InconsistentOutputError) Error() string {
+	return fmt.Sprintf("inconsistent output: %v", strings.Join(e.Diff.Kinds(), ", "))
+}
+
+// Kinds returns the sorted set of difference kinds of the transaction.
+func (d *TransactionDiff) Kinds() []string {
+	kinds := map[string]struct{}{}
+	for _, diff := range d.Diffs {
+		kinds[string(diff.Kind)] = struct{}{}
+	}
+	res := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		res = append(res, kind)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Print writes a human-readable summary of the differences to the console.
+func (d *TransactionDiff) Print() {
+	if d.Interpreter != "" {
+		fmt.Printf("block: %v Transaction: %v Interpreter: %v\n", d.Block, d.Tx, d.Interpreter)
+	} else {
+		fmt.Printf("block: %v Transaction: %v\n", d.Block, d.Tx)
+	}
+	printDiffs(d.Diffs)
+}
+
+func printDiffs(diffs []FieldDiff) {
+	for _, diff := range diffs {
+		switch diff.Kind {
+		case DiffAccountMissing, DiffAccountExtra:
+			fmt.Printf("    %s key=%v\n", strings.TrimPrefix(string(diff.Kind), "account-"), diff.Account)
+		case DiffStorageMissing:
+			fmt.Printf("    %s misses key %v\n", diff.Field, diff.Key)
+		case DiffStorageExtra:
+			fmt.Printf("    %s has extra key %v\n", diff.Field, diff.Key)
+		default:
+			fmt.Printf("  Different %s:\n", diff.Field)
+			fmt.Printf("    want: %v\n", diff.Want)
+			fmt.Printf("    have: %v\n", diff.Have)
+		}
+	}
+}
+
+// diffBuilder accumulates field differences.
+type diffBuilder struct {
+	diffs []FieldDiff
+}
+
+func (b *diffBuilder) add(kind DiffKind, field string, account *common.Address, key *common.Hash, want, have string) {
+	b.diffs = append(b.diffs, FieldDiff{
+		Kind:    kind,
+		Field:   field,
+		Account: account,
+		Key:     key,
+		Want:    want,
+		Have:    have,
+	})
+}
+
+func addIfDifferent[T comparable](b *diffBuilder, kind DiffKind, field string, account *common.Address, want, have T) bool {
+	if want != have {
+		b.add(kind, field, account, nil, fmt.Sprintf("%v", want), fmt.Sprintf("%v", have))
+		return true
+	}
+	return false
+}
+
+func addIfDifferentBytes(b *diffBuilder, kind DiffKind, field string, account *common.Address, want, have []byte) bool {
+	if !bytes.Equal(want, have) {
+		b.add(kind, field, account, nil, hexutil.Encode(want), hexutil.Encode(have))
+		return true
+	}
+	return false
+}
+
+func addIfDifferentBigInt(b *diffBuilder, kind DiffKind, field string, account *common.Address, want, have *big.Int) bool {
+	if want == nil && have == nil {
+		return false
+	}
+	if want == nil || have == nil || want.Cmp(have) != 0 {
+		b.add(kind, field, account, nil, fmt.Sprintf("%v", want), fmt.Sprintf("%v", have))
+		return true
+	}
+	return false
+}
+
+// DiffResult lists all differences between an expected and an observed result.
+func DiffResult(want, have *substate.SubstateResult) []FieldDiff {
+	b := &diffBuilder{}
+	addIfDifferent(b, DiffStatus, "status", nil, want.Status, have.Status)
+	addIfDifferent(b, DiffContractAddress, "contract address", nil, want.ContractAddress, have.ContractAddress)
+	addIfDifferent(b, DiffGasUsed, "gas usage", nil, want.GasUsed, have.GasUsed)
+	addIfDifferent(b, DiffBloom, "log bloom filter", nil, want.Bloom, have.Bloom)
+	if !addIfDifferent(b, DiffLogCount, "log size", nil, len(want.Logs), len(have.Logs)) {
+		for i := range want.Logs {
+			diffLog(b, fmt.Sprintf("log[%d]", i), want.Logs[i], have.Logs[i])
+		}
+	}
+	return b.diffs
+}
+
+func diffLog(b *diffBuilder, label string, want, have *types.Log) {
+	addIfDifferent(b, DiffLogAddress, fmt.Sprintf("%s.address", label), nil, want.Address, have.Address)
+	if !addIfDifferent(b, DiffLogTopics, fmt.Sprintf("%s.Topics size", label), nil, len(want.Topics), len(have.Topics)) {
+		for i := range want.Topics {
+			addIfDifferent(b, DiffLogTopics, fmt.Sprintf("%s.Topics[%d]", label, i), nil, want.Topics[i], have.Topics[i])
+		}
+	}
+	addIfDifferentBytes(b, DiffLogData, fmt.Sprintf("%s.data", label), nil, want.Data, have.Data)
+}
+
+// DiffAlloc lists all differences between an expected and an observed
+// substate allocation. Accounts and storage keys are reported in sorted order.
+func DiffAlloc(want, have substate.SubstateAlloc) []FieldDiff {
+	b := &diffBuilder{}
+	addIfDifferent(b, DiffAllocSize, "substate alloc size", nil, len(want), len(have))
+	for _, addr := range sortedAddresses(want) {
+		if _, present := have[addr]; !present {
+			account := addr
+			b.add(DiffAccountMissing, fmt.Sprintf("key=%v", addr), &account, nil, "", "")
+		}
+	}
+	for _, addr := range sortedAddresses(have) {
+		if _, present := want[addr]; !present {
+			account := addr
+			b.add(DiffAccountExtra, fmt.Sprintf("key=%v", addr), &account, nil, "", "")
+		}
+	}
+	for _, addr := range sortedAddresses(have) {
+		if should, present := want[addr]; present {
+			account := addr
+			diffAccount(b, fmt.Sprintf("key=%v:", addr), &account, should, have[addr])
+		}
+	}
+	return b.diffs
+}
+
+func diffAccount(b *diffBuilder, label string, account *common.Address, want, have *substate.SubstateAccount) {
+	addIfDifferent(b, DiffNonce, fmt.Sprintf("%s.Nonce", label), account, want.Nonce, have.Nonce)
+	addIfDifferentBigInt(b, DiffBalance, fmt.Sprintf("%s.Balance", label), account, want.Balance, have.Balance)
+	addIfDifferentBytes(b, DiffCode, fmt.Sprintf("%s.Code", label), account, want.Code, have.Code)
+
+	addIfDifferent(b, DiffStorageSize, fmt.Sprintf("len(%s.Storage)", label), account, len(want.Storage), len(have.Storage))
+	for _, key := range sortedKeys(want.Storage) {
+		if _, present := have.Storage[key]; !present {
+			k := key
+			b.add(DiffStorageMissing, fmt.Sprintf("%s.Storage", label), account, &k, want.Storage[key].Hex(), "")
+		}
+	}
+	for _, key := range sortedKeys(have.Storage) {
+		if _, present := want.Storage[key]; !present {
+			k := key
+			b.add(DiffStorageExtra, fmt.Sprintf("%s.Storage", label), account, &k, "", have.Storage[key].Hex())
+		}
+	}
+	for _, key := range sortedKeys(have.Storage) {
+		if should, present := want.Storage[key]; present && should != have.Storage[key] {
+			k := key
+			b.add(DiffStorageValue, fmt.Sprintf("%s.Storage[%v]", label, key), account, &k, should.Hex(), have.Storage[key].Hex())
+		}
+	}
+}
+
+func sortedAddresses(alloc substate.SubstateAlloc) []common.Address {
+	res := make([]common.Address, 0, len(alloc))
+	for addr := range alloc {
+		res = append(res, addr)
+	}
+	sort.Slice(res, func(i, j int) bool { return bytes.Compare(res[i][:], res[j][:]) < 0 })
+	return res
+}
+
+func sortedKeys(storage map[common.Hash]common.Hash) []common.Hash {
+	res := make([]common.Hash, 0, len(storage))
+	for key := range storage {
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool { return bytes.Compare(res[i][:], res[j][:]) < 0 })
+	return res
+}
+
+// DiffReport writes transaction differences as JSON lines to a file. It is
+// safe to be used by multiple workers concurrently.
+type DiffReport struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// OpenDiffReport creates (or truncates) the given report file.
+func OpenDiffReport(filename string) (*DiffReport, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create report file %s: %v", filename, err)
+	}
+	return &DiffReport{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// ResumeDiffReport opens the report file of a resumed run for appending. The
+// differences of blocks from next on, written after the checkpoint of the
+// interrupted run, are dropped since these blocks are replayed again.
+func ResumeDiffReport(filename string, next uint64) (*DiffReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read report file %s: %v", filename, err)
+	}
+	var kept bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var diff struct {
+			Block uint64 `json:"block"`
+		}
+		// a partially written line of the interrupted run is dropped as well
+		if err := json.Unmarshal(line, &diff); err != nil || diff.Block >= next {
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	// write to a temporary file first to never lose the report
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("cannot write report file %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return nil, fmt.Errorf("cannot write report file %s: %v", filename, err)
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open report file %s: %v", filename, err)
+	}
+	return &DiffReport{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Write appends the given difference to the report.
+func (r *DiffReport) Write(diff *TransactionDiff) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.encoder.Encode(diff)
+}
+
+// Close flushes and closes the report file.
+func (r *DiffReport) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,163 @@
+package replay
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+func diffKinds(diffs []FieldDiff) []DiffKind {
+	res := []DiffKind{}
+	for _, diff := range diffs {
+		res = append(res, diff.Kind)
+	}
+	return res
+}
+
+func TestDiffResult(t *testing.T) {
+	log := func(data byte, topics ...common.Hash) *types.Log {
+		return &types.Log{Address: common.Address{1}, Topics: topics, Data: []byte{data}}
+	}
+	tests := []struct {
+		name string
+		want *substate.SubstateResult
+		have *substate.SubstateResult
+		diff []DiffKind
+	}{
+		{
+			name: "equal",
+			want: &substate.SubstateResult{Status: 1, GasUsed: 21000},
+			have: &substate.SubstateResult{Status: 1, GasUsed: 21000},
+			diff: []DiffKind{},
+		},
+		{
+			name: "status and gas",
+			want: &substate.SubstateResult{Status: 1, GasUsed: 21000},
+			have: &substate.SubstateResult{Status: 0, GasUsed: 25000},
+			diff: []DiffKind{DiffStatus, DiffGasUsed},
+		},
+		{
+			name: "log count hides log fields",
+			want: &substate.SubstateResult{Logs: []*types.Log{log(1)}},
+			have: &substate.SubstateResult{Logs: []*types.Log{log(2), log(3)}},
+			diff: []DiffKind{DiffLogCount},
+		},
+		{
+			name: "log topics and data",
+			want: &substate.SubstateResult{Logs: []*types.Log{log(1, common.Hash{1})}},
+			have: &substate.SubstateResult{Logs: []*types.Log{log(2, common.Hash{2})}},
+			diff: []DiffKind{DiffLogTopics, DiffLogData},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diffKinds(DiffResult(test.want, test.have)); !reflect.DeepEqual(got, test.diff) {
+				t.Errorf("unexpected differences, wanted %v, got %v", test.diff, got)
+			}
+		})
+	}
+}
+
+func newTestAccount(nonce uint64, balance int64, storage map[common.Hash]common.Hash) *substate.SubstateAccount {
+	account := substate.NewSubstateAccount(nonce, big.NewInt(balance), nil)
+	for key, value := range storage {
+		account.Storage[key] = value
+	}
+	return account
+}
+
+func TestDiffAlloc(t *testing.T) {
+	a, b, c := common.Address{0xa}, common.Address{0xb}, common.Address{0xc}
+	tests := []struct {
+		name string
+		want substate.SubstateAlloc
+		have substate.SubstateAlloc
+		diff []DiffKind
+	}{
+		{
+			name: "equal",
+			want: substate.SubstateAlloc{a: newTestAccount(1, 10, nil)},
+			have: substate.SubstateAlloc{a: newTestAccount(1, 10, nil)},
+			diff: []DiffKind{},
+		},
+		{
+			name: "missing and extra accounts",
+			want: substate.SubstateAlloc{a: newTestAccount(1, 10, nil), c: newTestAccount(1, 10, nil)},
+			have: substate.SubstateAlloc{a: newTestAccount(1, 10, nil), b: newTestAccount(1, 10, nil)},
+			diff: []DiffKind{DiffAccountMissing, DiffAccountExtra},
+		},
+		{
+			name: "nonce and balance",
+			want: substate.SubstateAlloc{a: newTestAccount(1, 10, nil)},
+			have: substate.SubstateAlloc{a: newTestAccount(2, 11, nil)},
+			diff: []DiffKind{DiffNonce, DiffBalance},
+		},
+		{
+			name: "storage",
+			want: substate.SubstateAlloc{a: newTestAccount(1, 10, map[common.Hash]common.Hash{{1}: {1}, {2}: {2}})},
+			have: substate.SubstateAlloc{a: newTestAccount(1, 10, map[common.Hash]common.Hash{{2}: {3}, {3}: {3}})},
+			diff: []DiffKind{DiffStorageMissing, DiffStorageExtra, DiffStorageValue},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diffKinds(DiffAlloc(test.want, test.have)); !reflect.DeepEqual(got, test.diff) {
+				t.Errorf("unexpected differences, wanted %v, got %v", test.diff, got)
+			}
+		})
+	}
+}
+
+func TestResumeDiffReportDropsReplayedBlocks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "diffs.jsonl")
+	content := `{"block":1,"tx":0,"diffs":[]}
+{"block":2,"tx":3,"diffs":[]}
+{"block":3,"tx":0,"diffs":[]}
+{"block":2,"tx":4,"di`
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ResumeDiffReport(filename, 3)
+	if err != nil {
+		t.Fatalf("cannot resume report: %v", err)
+	}
+	if err := report.Write(&TransactionDiff{Block: 3, Tx: 1, Diffs: []FieldDiff{}}); err != nil {
+		t.Fatalf("cannot write report: %v", err)
+	}
+	if err := report.Close(); err != nil {
+		t.Fatalf("cannot close report: %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"block":1,"tx":0,"diffs":[]}`,
+		`{"block":2,"tx":3,"diffs":[]}`,
+		`{"block":3,"tx":1,"diffs":[]}`,
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected report after resuming at block 3, wanted %q, got %q", want, got)
+	}
+}
+
+func TestResumeDiffReportWithoutReport(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "diffs.jsonl")
+	report, err := ResumeDiffReport(filename, 10)
+	if err != nil {
+		t.Fatalf("cannot resume missing report: %v", err)
+	}
+	report.Close()
+	if data, err := os.ReadFile(filename); err != nil || len(data) != 0 {
+		t.Errorf("expected empty report, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,148 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ReplayFailure records a transaction that could not be replayed consistently.
+type ReplayFailure struct {
+	Block    uint64
+	Tx       int
+	Category string
+	Err      error
+}
+
+// FailureLog collects the failures of a replay run which continues on errors.
+// Once the configured number of failures is reached, further registrations
+// report an error such that the run is aborted. It is safe to be used by
+// multiple workers concurrently.
+type FailureLog struct {
+	mu          sync.Mutex
+	maxFailures int // 0 means unlimited
+	failures    []ReplayFailure
+	categories  map[string]int
+}
+
+// NewFailureLog creates a failure log aborting after maxFailures failures; a
+// limit of zero disables the budget.
+func NewFailureLog(maxFailures int) *FailureLog {
+	return &FailureLog{
+		maxFailures: maxFailures,
+		categories:  map[string]int{},
+	}
+}
+
+// categorizedError is an error providing its failure category.
+type categorizedError interface {
+	error
+	Category() string
+}
+
+// Failure categories of errors raised while replaying a transaction.
+const (
+	categoryMissingBlockHash = "missing block hash"
+	categoryApplyMessage     = "message not applicable"
+	categoryGasUsage         = "gas usage not computable"
+)
+
+// replayError is an error of a transaction replay with a fixed failure
+// category; the message of such errors holds block numbers or addresses.
+type replayError struct {
+	category string
+	err      error
+}
+
+func newReplayError(category string, err error) *replayError {
+	return &replayError{category: category, err: err}
+}
+
+func (e *replayError) Error() string {
+	return e.err.Error()
+}
+
+func (e *replayError) Unwrap() error {
+	return e.err
+}
+
+func (e *replayError) Category() string {
+	return e.category
+}
+
+// getFailureCategory classifies a replay error. Inconsistent outputs are
+// grouped by the kinds of differing fields, interpreter divergences by their
+// verdict and other errors by their message prefix.
+func getFailureCategory(err error) string {
+	var inconsistent *InconsistentOutputError
+	if errors.As(err, &inconsistent) {
+		return inconsistent.Error()
+	}
+	var categorized categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.Category()
+	}
+	return strings.Split(err.Error(), ":")[0]
+}
+
+// Register records the failure of a transaction. It returns an error if the
+// failure budget is exhausted.
+func (l *FailureLog) Register(block uint64, tx int, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	category := getFailureCategory(err)
+	l.failures = append(l.failures, ReplayFailure{
+		Block:    block,
+		Tx:       tx,
+		Category: category,
+		Err:      err,
+	})
+	l.categories[category]++
+	if l.maxFailures > 0 && len(l.failures) >= l.maxFailures {
+		return fmt.Errorf("maximum number of failures (%d) reached, last failure: %v", l.maxFailures, err)
+	}
+	return nil
+}
+
+// NumFailures returns the number of failures registered so far.
+func (l *FailureLog) NumFailures() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.failures)
+}
+
+// PrintSummary lists all failed transactions in block order followed by the
+// number of failures per category.
+func (l *FailureLog) PrintSummary(cli_command string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failures := make([]ReplayFailure, len(l.failures))
+	copy(failures, l.failures)
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Block != failures[j].Block {
+			return failures[i].Block < failures[j].Block
+		}
+		return failures[i].Tx < failures[j].Tx
+	})
+	for _, failure := range failures {
+		fmt.Printf("%s: failed block %v tx %v: %v\n", cli_command, failure.Block, failure.Tx, failure.Err)
+	}
+
+	categories := make([]string, 0, len(l.categories))
+	for category := range l.categories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if l.categories[categories[i]] != l.categories[categories[j]] {
+			return l.categories[categories[i]] > l.categories[categories[j]]
+		}
+		return categories[i] < categories[j]
+	})
+	fmt.Printf("%s: %v failed transactions\n", cli_command, len(failures))
+	for _, category := range categories {
+		fmt.Printf("%s: %12v %s\n", cli_command, l.categories[category], category)
+	}
+}
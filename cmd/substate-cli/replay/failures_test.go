@@ -0,0 +1,118 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core"
+)
+
+func TestGetFailureCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category string
+	}{
+		{
+			name:     "inconsistent output",
+			err:      &InconsistentOutputError{Diff: &TransactionDiff{Diffs: []FieldDiff{{Kind: DiffGasUsed}, {Kind: DiffBalance}}}},
+			category: "inconsistent output: balance, gas-used",
+		},
+		{
+			name:     "interpreter divergence",
+			err:      &InterpreterDivergenceError{Verdict: "both failed differently", Errs: []error{errors.New("a: 1"), errors.New("b: 2")}},
+			category: "interpreter divergence: both failed differently",
+		},
+		{
+			name:     "missing block hash",
+			err:      newReplayError(categoryMissingBlockHash, fmt.Errorf("getHash(%d) invoked, blockhash for that block not provided", 17)),
+			category: categoryMissingBlockHash,
+		},
+		{
+			name:     "inapplicable message",
+			err:      newReplayError(categoryApplyMessage, fmt.Errorf("%w: address %v, tx: %d state: %d", core.ErrNonceTooHigh, "0x01", 5, 3)),
+			category: categoryApplyMessage,
+		},
+		{
+			name:     "restored from checkpoint",
+			err:      &checkpointFailure{category: "some category", message: "some: failure"},
+			category: "some category",
+		},
+		{
+			name:     "uncategorized",
+			err:      errors.New("unexpected failure: block 5"),
+			category: "unexpected failure",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getFailureCategory(test.err); got != test.category {
+				t.Errorf("unexpected category, wanted %q, got %q", test.category, got)
+			}
+		})
+	}
+}
+
+func TestReplayErrorKeepsCause(t *testing.T) {
+	err := newReplayError(categoryApplyMessage, fmt.Errorf("%w: address 0x01", core.ErrNonceTooHigh))
+	if !errors.Is(err, core.ErrNonceTooHigh) {
+		t.Errorf("replay error does not wrap its cause")
+	}
+	if want := "nonce too high: address 0x01"; err.Error() != want {
+		t.Errorf("unexpected message, wanted %q, got %q", want, err.Error())
+	}
+}
+
+func TestFailureLogBudget(t *testing.T) {
+	tests := []struct {
+		maxFailures int
+		failures    int
+		abortAfter  int // 0 means never
+	}{
+		{maxFailures: 0, failures: 5, abortAfter: 0},
+		{maxFailures: 1, failures: 1, abortAfter: 1},
+		{maxFailures: 3, failures: 2, abortAfter: 0},
+		{maxFailures: 3, failures: 5, abortAfter: 3},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d of %d", test.failures, test.maxFailures), func(t *testing.T) {
+			log := NewFailureLog(test.maxFailures)
+			abortedAfter := 0
+			for i := 1; i <= test.failures; i++ {
+				if err := log.Register(uint64(i), 0, errors.New("failure")); err != nil && abortedAfter == 0 {
+					abortedAfter = i
+				}
+			}
+			if abortedAfter != test.abortAfter {
+				t.Errorf("unexpected abort, wanted after %d failures, got after %d", test.abortAfter, abortedAfter)
+			}
+			if log.NumFailures() != test.failures {
+				t.Errorf("unexpected number of failures, wanted %d, got %d", test.failures, log.NumFailures())
+			}
+		})
+	}
+}
+
+func TestFailureLogCategories(t *testing.T) {
+	log := NewFailureLog(0)
+	for block := uint64(1); block <= 3; block++ {
+		err := newReplayError(categoryMissingBlockHash, fmt.Errorf("getHash(%d) invoked, no blockhashes provided", block-1))
+		if err := log.Register(block, 0, err); err != nil {
+			t.Fatalf("unexpected abort: %v", err)
+		}
+	}
+	if err := log.Register(4, 1, errors.New("other: failure")); err != nil {
+		t.Fatalf("unexpected abort: %v", err)
+	}
+
+	want := map[string]int{categoryMissingBlockHash: 3, "other": 1}
+	if len(log.categories) != len(want) {
+		t.Errorf("unexpected categories, wanted %v, got %v", want, log.categories)
+	}
+	for category, n := range want {
+		if log.categories[category] != n {
+			t.Errorf("unexpected number of failures in category %q, wanted %d, got %d", category, n, log.categories[category])
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+var GasScheduleFlag = cli.StringFlag{
+	Name:  "gas-schedule",
+	Usage: "JSON file of gas costs of opcodes with constant gas, e.g. {\"JUMPI\": 8, \"ADDMOD\": 5}",
+}
+
+// constantGas is the gas of the opcodes charged only a constant gas by the
+// rule sets, the only opcodes a gas schedule may reprice. Opcodes with a
+// dynamic gas, e.g. for memory expansion, storage or account access, are
+// missing, their gas is not a single cost.
+var constantGas = map[vm.OpCode]uint64{
+	vm.STOP:           0,
+	vm.ADD:            vm.GasFastestStep,
+	vm.MUL:            vm.GasFastStep,
+	vm.SUB:            vm.GasFastestStep,
+	vm.DIV:            vm.GasFastStep,
+	vm.SDIV:           vm.GasFastStep,
+	vm.MOD:            vm.GasFastStep,
+	vm.SMOD:           vm.GasFastStep,
+	vm.ADDMOD:         vm.GasMidStep,
+	vm.MULMOD:         vm.GasMidStep,
+	vm.SIGNEXTEND:     vm.GasFastStep,
+	vm.LT:             vm.GasFastestStep,
+	vm.GT:             vm.GasFastestStep,
+	vm.SLT:            vm.GasFastestStep,
+	vm.SGT:            vm.GasFastestStep,
+	vm.EQ:             vm.GasFastestStep,
+	vm.ISZERO:         vm.GasFastestStep,
+	vm.AND:            vm.GasFastestStep,
+	vm.OR:             vm.GasFastestStep,
+	vm.XOR:            vm.GasFastestStep,
+	vm.NOT:            vm.GasFastestStep,
+	vm.BYTE:           vm.GasFastestStep,
+	vm.SHL:            vm.GasFastestStep,
+	vm.SHR:            vm.GasFastestStep,
+	vm.SAR:            vm.GasFastestStep,
+	vm.ADDRESS:        vm.GasQuickStep,
+	vm.ORIGIN:         vm.GasQuickStep,
+	vm.CALLER:         vm.GasQuickStep,
+	vm.CALLVALUE:      vm.GasQuickStep,
+	vm.CALLDATALOAD:   vm.GasFastestStep,
+	vm.CALLDATASIZE:   vm.GasQuickStep,
+	vm.CODESIZE:       vm.GasQuickStep,
+	vm.GASPRICE:       vm.GasQuickStep,
+	vm.RETURNDATASIZE: vm.GasQuickStep,
+	vm.BLOCKHASH:      vm.GasExtStep,
+	vm.COINBASE:       vm.GasQuickStep,
+	vm.TIMESTAMP:      vm.GasQuickStep,
+	vm.NUMBER:         vm.GasQuickStep,
+	vm.DIFFICULTY:     vm.GasQuickStep,
+	vm.GASLIMIT:       vm.GasQuickStep,
+	vm.CHAINID:        vm.GasQuickStep,
+	vm.SELFBALANCE:    vm.GasFastStep,
+	vm.BASEFEE:        vm.GasQuickStep,
+	vm.POP:            vm.GasQuickStep,
+	vm.JUMP:           vm.GasMidStep,
+	vm.JUMPI:          vm.GasSlowStep,
+	vm.PC:             vm.GasQuickStep,
+	vm.MSIZE:          vm.GasQuickStep,
+	vm.GAS:            vm.GasQuickStep,
+	vm.JUMPDEST:       params.JumpdestGas,
+}
+
+func init() {
+	for op := vm.PUSH1; op <= vm.PUSH32; op++ {
+		constantGas[op] = vm.GasFastestStep
+	}
+	for op := vm.DUP1; op <= vm.DUP16; op++ {
+		constantGas[op] = vm.GasFastestStep
+	}
+	for op := vm.SWAP1; op <= vm.SWAP16; op++ {
+		constantGas[op] = vm.GasFastestStep
+	}
+}
+
+// constantGasSince holds the rule set introducing an opcode of constantGas,
+// the other opcodes are defined by all rule sets.
+var constantGasSince = map[vm.OpCode]func(params.Rules) bool{
+	vm.RETURNDATASIZE: func(r params.Rules) bool { return r.IsByzantium },
+	vm.SHL:            func(r params.Rules) bool { return r.IsConstantinople },
+	vm.SHR:            func(r params.Rules) bool { return r.IsConstantinople },
+	vm.SAR:            func(r params.Rules) bool { return r.IsConstantinople },
+	vm.CHAINID:        func(r params.Rules) bool { return r.IsIstanbul },
+	vm.SELFBALANCE:    func(r params.Rules) bool { return r.IsIstanbul },
+	vm.BASEFEE:        func(r params.Rules) bool { return r.IsLondon },
+}
+
+// GasSchedule maps opcodes to the constant gas charged for them instead of
+// the constant gas of the rule set.
+type GasSchedule map[vm.OpCode]uint64
+
+// LoadGasSchedule reads a gas schedule from a JSON object mapping opcode
+// names to gas costs. Only opcodes charged a constant gas can be repriced.
+func LoadGasSchedule(filename string) (GasSchedule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read gas schedule %v: %v", filename, err)
+	}
+	var costs map[string]uint64
+	if err := json.Unmarshal(data, &costs); err != nil {
+		return nil, fmt.Errorf("cannot parse gas schedule %v: %v", filename, err)
+	}
+	schedule := GasSchedule{}
+	for name, cost := range costs {
+		op := vm.StringToOp(strings.ToUpper(name))
+		if op == vm.STOP && strings.ToUpper(name) != "STOP" {
+			return nil, fmt.Errorf("unknown opcode %v in gas schedule %v", name, filename)
+		}
+		if _, found := constantGas[op]; !found {
+			return nil, fmt.Errorf("opcode %v in gas schedule %v is not charged a constant gas and cannot be repriced", op, filename)
+		}
+		schedule[op] = cost
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("gas schedule %v is empty", filename)
+	}
+	return schedule, nil
+}
+
+// String lists the overrides in opcode order.
+func (s GasSchedule) String() string {
+	ops := make([]vm.OpCode, 0, len(s))
+	for op := range s {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	overrides := make([]string, len(ops))
+	for i, op := range ops {
+		overrides[i] = fmt.Sprintf("%v=%v", op, s[op])
+	}
+	return strings.Join(overrides, ", ")
+}
+
+// repricingTracer charges the gas of a schedule instead of the constant gas
+// of the rule set. The interpreter has charged the constant gas of the rule
+// set when an opcode is traced, so the difference is charged to or refunded
+// to the current call frame before the opcode is executed. The outcome is
+// approximate if the schedule changes whether a frame can pay an opcode:
+//   - a frame running out of gas at the cost of the rule set is not resumed
+//     even if it could pay the scheduled cost,
+//   - a frame that cannot pay the scheduled cost still executes the opcode,
+//     its gas is exhausted, so the next opcode fails unless it costs nothing,
+//     e.g. STOP.
+type repricingTracer struct {
+	schedule    GasSchedule
+	inner       vm.Tracer // optional tracer observing the execution
+	unpaid      bool      // the frame could not pay the last opcode
+	approximate bool
+}
+
+func newRepricingTracer(schedule GasSchedule, inner vm.Tracer) *repricingTracer {
+	return &repricingTracer{schedule: schedule, inner: inner}
+}
+
+// init checks that the EVM calls the tracer for every opcode and that its
+// rule set defines the scheduled opcodes.
+func (t *repricingTracer) init(evm *vm.EVM) error {
+	if _, ok := evm.Interpreter().(*vm.GethEVMInterpreter); !ok {
+		return fmt.Errorf("gas schedules require the geth interpreter")
+	}
+	rules := evm.ChainConfig().Rules(evm.Context.BlockNumber)
+	for op := range t.schedule {
+		if since, found := constantGasSince[op]; found && !since(rules) {
+			return fmt.Errorf("opcode %v of the gas schedule is not defined by the rule set", op)
+		}
+	}
+	return nil
+}
+
+func (t *repricingTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	if t.inner != nil {
+		t.inner.CaptureStart(env, from, to, create, input, gas, value)
+	}
+}
+
+func (t *repricingTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.inner != nil {
+		t.inner.CaptureState(env, pc, op, gas, cost, scope, rData, depth, err)
+	}
+	if t.unpaid {
+		// the frame has no gas left, only an opcode without cost completes
+		t.unpaid = false
+		if err == nil && cost == 0 {
+			t.approximate = true
+		}
+	}
+	scheduled, found := t.schedule[op]
+	if !found {
+		return
+	}
+	constant := constantGas[op]
+	if err != nil {
+		// the frame could not pay the cost of the rule set
+		if errors.Is(err, vm.ErrOutOfGas) && scheduled < constant && gas >= scheduled {
+			t.approximate = true
+		}
+		return
+	}
+	contract := scope.Contract
+	if scheduled < constant {
+		contract.Gas += constant - scheduled
+	} else if !contract.UseGas(scheduled - constant) {
+		contract.Gas = 0
+		if op == vm.STOP {
+			t.approximate = true
+		} else {
+			t.unpaid = true
+		}
+	}
+}
+
+func (t *repricingTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if t.inner != nil {
+		t.inner.CaptureEnter(typ, from, to, input, gas, value)
+	}
+}
+
+func (t *repricingTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if t.inner != nil {
+		t.inner.CaptureExit(output, gasUsed, err)
+	}
+}
+
+func (t *repricingTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if t.inner != nil {
+		t.inner.CaptureFault(env, pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+func (t *repricingTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	if t.inner != nil {
+		t.inner.CaptureEnd(output, gasUsed, d, err)
+	}
+}
+
+// replay_fork_gas table
+var repricingSchema = &metrics.Schema{
+	Table: "replay_fork_gas",
+	Columns: []metrics.Column{
+		{Name: "block_number", Type: metrics.Integer},
+		{Name: "tx_number", Type: metrics.Integer},
+		{Name: "outcome", Type: metrics.Text},
+		{Name: "recorded_status", Type: metrics.Integer},
+		{Name: "replayed_status", Type: metrics.Integer},
+		{Name: "recorded_gas", Type: metrics.Integer},
+		{Name: "replayed_gas", Type: metrics.Integer},
+		{Name: "approximate", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"block_number", "tx_number"},
+}
+
+// totals of a replay with a gas schedule
+var (
+	repricing_recorded_gas uint64
+	repricing_replayed_gas uint64
+	repricing_approximate  uint64
+)
+
+// writeRepricing writes the outcome of a transaction replayed with a gas
+// schedule. The replayed result is nil if the transaction was rejected.
+func writeRepricing(sink metrics.Sink, block uint64, tx int, outcome string, recorded *substate.SubstateResult, replayed *substate.SubstateResult, approximate bool) error {
+	var replayedStatus, replayedGas uint64
+	if replayed != nil {
+		replayedStatus, replayedGas = replayed.Status, replayed.GasUsed
+	}
+	atomic.AddUint64(&repricing_recorded_gas, recorded.GasUsed)
+	atomic.AddUint64(&repricing_replayed_gas, replayedGas)
+	approximateValue := 0
+	if approximate {
+		atomic.AddUint64(&repricing_approximate, 1)
+		approximateValue = 1
+	}
+	return sink.Write(repricingSchema.Table, block, tx, outcome, recorded.Status, replayedStatus, recorded.GasUsed, replayedGas, approximateValue)
+}
+
+// printRepricingSummary prints the total gas of a replay with a gas schedule.
+func printRepricingSummary() {
+	recorded := atomic.LoadUint64(&repricing_recorded_gas)
+	replayed := atomic.LoadUint64(&repricing_replayed_gas)
+	fmt.Printf("substate-cli replay-fork: recorded gas: %v\n", recorded)
+	fmt.Printf("substate-cli replay-fork: replayed gas: %v\n", replayed)
+	if recorded > 0 {
+		fmt.Printf("substate-cli replay-fork: gas change:   %+.2f%%\n", 100*(float64(replayed)-float64(recorded))/float64(recorded))
+	}
+	if n := atomic.LoadUint64(&repricing_approximate); n > 0 {
+		fmt.Printf("substate-cli replay-fork: the outcome of %v transactions is approximate\n", n)
+	}
+}
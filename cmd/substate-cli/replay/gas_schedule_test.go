@@ -0,0 +1,126 @@
+package replay
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fantom-foundation/substate-cli/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+func TestLoadGasScheduleRejectsDynamicGas(t *testing.T) {
+	tests := []struct {
+		schedule string
+		valid    bool
+	}{
+		{schedule: `{"JUMPI": 8, "addmod": 5, "PUSH32": 2}`, valid: true},
+		{schedule: `{"SLOAD": 2100}`, valid: false},
+		{schedule: `{"SSTORE": 200}`, valid: false},
+		{schedule: `{"CALL": 100}`, valid: false},
+		{schedule: `{"MSTORE": 2}`, valid: false},
+		{schedule: `{"FOO": 2}`, valid: false},
+		{schedule: `{}`, valid: false},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "schedule.json")
+		if err := os.WriteFile(filename, []byte(test.schedule), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadGasSchedule(filename)
+		if test.valid && err != nil {
+			t.Errorf("schedule %v was rejected: %v", test.schedule, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("schedule %v was accepted", test.schedule)
+		}
+	}
+}
+
+// runRepriced executes code with the given gas and schedule and returns the
+// gas used and whether the outcome is approximate.
+func runRepriced(t *testing.T, code []byte, gas uint64, schedule GasSchedule) (uint64, bool, error) {
+	t.Helper()
+	contract := common.Address{0xc}
+	statedb := state.MakeOffTheChainStateDB(substate.SubstateAlloc{
+		contract: substate.NewSubstateAccount(1, big.NewInt(0), code),
+	})
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1),
+		Difficulty:  big.NewInt(0),
+		BaseFee:     big.NewInt(0),
+		GasLimit:    gas,
+	}
+	tracer := newRepricingTracer(schedule, nil)
+	evm := vm.NewEVM(blockCtx, vm.TxContext{GasPrice: big.NewInt(0)}, statedb, params.AllEthashProtocolChanges, vm.Config{Debug: true, Tracer: tracer})
+	if err := tracer.init(evm); err != nil {
+		t.Fatalf("cannot initialise tracer: %v", err)
+	}
+	_, left, err := evm.Call(vm.AccountRef(common.Address{0xa}), contract, nil, gas, big.NewInt(0))
+	return gas - left, tracer.approximate, err
+}
+
+func TestRepricingTracer(t *testing.T) {
+	// PUSH1 1, PUSH1 2, ADD, POP, STOP costs 3+3+3+2+0 gas
+	addPop := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 2, byte(vm.ADD), byte(vm.POP), byte(vm.STOP)}
+	// PUSH1 1, PUSH1 2, ADD, STOP
+	addStop := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 2, byte(vm.ADD), byte(vm.STOP)}
+	tests := []struct {
+		name        string
+		code        []byte
+		gas         uint64
+		schedule    GasSchedule
+		used        uint64
+		fails       bool
+		approximate bool
+	}{
+		{name: "unchanged", code: addPop, gas: 100, schedule: GasSchedule{vm.ADD: 3}, used: 11},
+		{name: "raised", code: addPop, gas: 100, schedule: GasSchedule{vm.ADD: 10}, used: 18},
+		{name: "lowered", code: addPop, gas: 100, schedule: GasSchedule{vm.ADD: 1}, used: 9},
+		{name: "several opcodes", code: addPop, gas: 100, schedule: GasSchedule{vm.PUSH1: 1, vm.POP: 5}, used: 10},
+		// the frame cannot pay ADD, POP runs out of gas as it would if ADD failed
+		{name: "raised out of gas", code: addPop, gas: 10, schedule: GasSchedule{vm.ADD: 10}, used: 10, fails: true},
+		// the frame cannot pay ADD, but STOP costs nothing
+		{name: "raised out of gas before STOP", code: addStop, gas: 9, schedule: GasSchedule{vm.ADD: 10}, used: 9, approximate: true},
+		// the frame runs out of gas at the cost of the rule set, not at the scheduled cost
+		{name: "lowered out of gas", code: addPop, gas: 7, schedule: GasSchedule{vm.ADD: 1}, used: 7, fails: true, approximate: true},
+		{name: "out of gas elsewhere", code: addPop, gas: 5, schedule: GasSchedule{vm.ADD: 1}, used: 5, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			used, approximate, err := runRepriced(t, test.code, test.gas, test.schedule)
+			if test.fails != (err != nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if used != test.used {
+				t.Errorf("unexpected gas used, wanted %d, got %d", test.used, used)
+			}
+			if approximate != test.approximate {
+				t.Errorf("unexpected approximation, wanted %v, got %v", test.approximate, approximate)
+			}
+		})
+	}
+}
+
+func TestRepricingTracerRejectsUndefinedOpcodes(t *testing.T) {
+	statedb := state.MakeOffTheChainStateDB(substate.SubstateAlloc{})
+	blockCtx := vm.BlockContext{BlockNumber: big.NewInt(1), Difficulty: big.NewInt(0)}
+	// BASEFEE is introduced by London
+	tracer := newRepricingTracer(GasSchedule{vm.BASEFEE: 1}, nil)
+	evm := vm.NewEVM(blockCtx, vm.TxContext{}, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+	if err := tracer.init(evm); err == nil {
+		t.Errorf("BASEFEE was accepted before London")
+	}
+	tracer = newRepricingTracer(GasSchedule{vm.JUMPI: 1}, nil)
+	if err := tracer.init(evm); err != nil {
+		t.Errorf("JUMPI was rejected: %v", err)
+	}
+}
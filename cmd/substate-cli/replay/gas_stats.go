@@ -0,0 +1,323 @@
+package replay
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// record-replay: substate-cli gas-stats command
+var GetGasStatsCommand = cli.Command{
+	Action:    getGasStatsAction,
+	Name:      "gas-stats",
+	Usage:     "attributes gas used to contracts and function selectors",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
+		&InterpreterImplFlag,
+		&UseInMemoryStateDbFlag,
+		&OutputFlag,
+	},
+	Description: `
+The substate-cli gas-stats command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be analysed.
+
+The transactions are replayed and the gas used is split into the intrinsic
+gas, the execution gas and the refund. The gas is attributed to the called
+or created contract and to the 4-byte function selector of the call data.
+The selector is "create" for contract creations and empty for calls with
+less than 4 bytes of call data. Transfers to accounts without code are only
+counted in the totals.
+
+The gas per contract and per selector is written to the gas_stats_contract
+and gas_stats_selector tables, printed as metric: lines or written to the
+file given by --output.`,
+}
+
+// gas_stats_contract table
+var gasStatsContractSchema = &metrics.Schema{
+	Table: "gas_stats_contract",
+	Columns: []metrics.Column{
+		{Name: "contract", Type: metrics.Text},
+		{Name: "transactions", Type: metrics.Integer},
+		{Name: "gas_used", Type: metrics.Integer},
+		{Name: "intrinsic_gas", Type: metrics.Integer},
+		{Name: "execution_gas", Type: metrics.Integer},
+		{Name: "refund_gas", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"contract"},
+}
+
+// gas_stats_selector table
+var gasStatsSelectorSchema = &metrics.Schema{
+	Table: "gas_stats_selector",
+	Columns: []metrics.Column{
+		{Name: "contract", Type: metrics.Text},
+		{Name: "selector", Type: metrics.Text},
+		{Name: "transactions", Type: metrics.Integer},
+		{Name: "gas_used", Type: metrics.Integer},
+		{Name: "intrinsic_gas", Type: metrics.Integer},
+		{Name: "execution_gas", Type: metrics.Integer},
+		{Name: "refund_gas", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"contract", "selector"},
+}
+
+// gasUsage splits the gas used by a transaction into its components. The
+// gas used is the intrinsic gas plus the execution gas minus the refund. On
+// Opera, the execution gas includes the charge of 10% of the unused gas.
+type gasUsage struct {
+	used      uint64
+	intrinsic uint64
+	execution uint64
+	refund    uint64
+	count     uint64 // number of transactions
+}
+
+func (u *gasUsage) add(other *gasUsage) {
+	u.used += other.used
+	u.intrinsic += other.intrinsic
+	u.execution += other.execution
+	u.refund += other.refund
+	u.count += other.count
+}
+
+// getGasUsage computes the gas components of an applied message from the gas
+// used and the refund counter of the StateDB after the execution.
+func getGasUsage(msg evmcore.Message, used uint64, refundCounter uint64, london bool) (gasUsage, error) {
+	intrinsic, err := evmcore.IntrinsicGas(msg.Data(), msg.AccessList(), msg.To() == nil)
+	if err != nil {
+		return gasUsage{}, err
+	}
+	quotient := uint64(params.RefundQuotient)
+	if london {
+		quotient = params.RefundQuotientEIP3529
+	}
+	refund := getAppliedRefund(used, refundCounter, quotient)
+	return gasUsage{
+		used:      used,
+		intrinsic: intrinsic,
+		execution: used + refund - intrinsic,
+		refund:    refund,
+		count:     1,
+	}, nil
+}
+
+// getAppliedRefund computes the refund of a transaction from the gas used
+// after the refund. The refund counter is capped to a fraction of the gas used
+// before the refund; if the cap applies, the refund may be off by one.
+func getAppliedRefund(used uint64, refundCounter uint64, quotient uint64) uint64 {
+	if (used+refundCounter)/quotient >= refundCounter {
+		return refundCounter
+	}
+	// find the gas used before the refund: before - before/quotient = used
+	before := used * quotient / (quotient - 1)
+	for before > used && before-before/quotient > used {
+		before--
+	}
+	for before-before/quotient < used {
+		before++
+	}
+	return before - used
+}
+
+// gasStatsKey identifies a function of a contract.
+type gasStatsKey struct {
+	contract common.Address
+	selector string
+}
+
+// gasStatsCollector accumulates the gas usage of all functions.
+type gasStatsCollector struct {
+	mu         sync.Mutex
+	selectors  map[gasStatsKey]*gasUsage
+	total      gasUsage
+	mismatches uint64 // transactions whose replayed gas differs from the recording
+}
+
+// getSelector returns the function selector of a transaction.
+func getSelector(st *substate.Substate) string {
+	if st.Message.To == nil {
+		return "create"
+	}
+	if len(st.Message.Data) < 4 {
+		return ""
+	}
+	return fmt.Sprintf("0x%x", st.Message.Data[:4])
+}
+
+// gasStatsTask replays a transaction and attributes its gas usage.
+func gasStatsTask(config ReplayConfig, collector *gasStatsCollector, block uint64, tx int, st *substate.Substate) error {
+	var usage gasUsage
+	config.gas = &usage
+	if _, _, err := runSubstate(config, config.vm_impl, block, tx, st, copyAlloc(st.InputAlloc), nil); err != nil {
+		return fmt.Errorf("block: %v Transaction: %v: %v", block, tx, err)
+	}
+
+	// gas is attributed to the called or created contract
+	var contract *common.Address
+	switch utils.GetTxType(st.Message.To, st.InputAlloc) {
+	case "call":
+		contract = st.Message.To
+	case "create":
+		contract = &st.Result.ContractAddress
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.total.add(&usage)
+	if usage.used != st.Result.GasUsed {
+		collector.mismatches++
+	}
+	if contract != nil {
+		key := gasStatsKey{contract: *contract, selector: getSelector(st)}
+		stats, found := collector.selectors[key]
+		if !found {
+			stats = &gasUsage{}
+			collector.selectors[key] = stats
+		}
+		stats.add(&usage)
+	}
+	return nil
+}
+
+// write writes the gas usage per contract and per selector to a sink, both
+// sorted by descending gas used.
+func (c *gasStatsCollector) write(sink metrics.Sink) error {
+	contracts := map[common.Address]*gasUsage{}
+	keys := make([]gasStatsKey, 0, len(c.selectors))
+	for key, usage := range c.selectors {
+		keys = append(keys, key)
+		stats, found := contracts[key.contract]
+		if !found {
+			stats = &gasUsage{}
+			contracts[key.contract] = stats
+		}
+		stats.add(usage)
+	}
+	addresses := make([]common.Address, 0, len(contracts))
+	for address := range contracts {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		a, b := contracts[addresses[i]], contracts[addresses[j]]
+		if a.used != b.used {
+			return a.used > b.used
+		}
+		return addresses[i].Hex() < addresses[j].Hex()
+	})
+	for _, address := range addresses {
+		u := contracts[address]
+		if err := sink.Write(gasStatsContractSchema.Table, address.Hex(), u.count, u.used, u.intrinsic, u.execution, u.refund); err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := c.selectors[keys[i]], c.selectors[keys[j]]
+		if a.used != b.used {
+			return a.used > b.used
+		}
+		if keys[i].contract != keys[j].contract {
+			return keys[i].contract.Hex() < keys[j].contract.Hex()
+		}
+		return keys[i].selector < keys[j].selector
+	})
+	for _, key := range keys {
+		u := c.selectors[key]
+		if err := sink.Write(gasStatsSelectorSchema.Table, key.contract.Hex(), key.selector, u.count, u.used, u.intrinsic, u.execution, u.refund); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// func getGasStatsAction for GetGasStatsCommand
+func getGasStatsAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli gas-stats command requires exactly 2 arguments")
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli gas-stats: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("git-date: %v\n", gitDate)
+	fmt.Printf("git-commit: %v\n", gitCommit)
+
+	first, last, argErr := SetBlockRange(ctx.Args().Get(0), ctx.Args().Get(1))
+	if argErr != nil {
+		return argErr
+	}
+
+	config := ReplayConfig{
+		vm_impl:          ctx.String(InterpreterImplFlag.Name),
+		use_in_memory_db: ctx.Bool(UseInMemoryStateDbFlag.Name),
+		chain_profile:    chainProfile,
+		chain_config:     chainProfile.ChainConfig(),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		config.vm_impl = chainProfile.VM.Interpreter
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDBReadOnly()
+	defer substate.CloseSubstateDB()
+
+	sink, err := openMetricsSink(ctx, "gas-stats", gasStatsContractSchema, gasStatsSelectorSchema)
+	if err != nil {
+		return err
+	}
+
+	collector := &gasStatsCollector{selectors: map[gasStatsKey]*gasUsage{}}
+	task := func(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		return gasStatsTask(config, collector, block, tx, st)
+	}
+	taskPool := substate.NewSubstateTaskPool("substate-cli gas-stats", task, first, last, ctx)
+	err = progress.ExecuteTaskPool(taskPool)
+	if err == nil {
+		err = collector.write(sink)
+	}
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	total := &collector.total
+	fmt.Printf("substate-cli gas-stats: transactions:  %v\n", total.count)
+	fmt.Printf("substate-cli gas-stats: gas used:      %v\n", total.used)
+	fmt.Printf("substate-cli gas-stats: intrinsic gas: %v\n", total.intrinsic)
+	fmt.Printf("substate-cli gas-stats: execution gas: %v\n", total.execution)
+	fmt.Printf("substate-cli gas-stats: refund:        %v\n", total.refund)
+	if collector.mismatches > 0 {
+		fmt.Printf("substate-cli gas-stats: %v transactions used a different amount of gas than recorded\n", collector.mismatches)
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// substate-cli import command
+var ImportCommand = cli.Command{
+	Action:    importAction,
+	Name:      "import",
+	Usage:     "imports JSON substates and state test fixtures into a substate DB",
+	ArgsUsage: "<file|dir>...",
+	Flags: []cli.Flag{
+		&substate.SubstateDirFlag,
+		&FirstBlockFlag,
+		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
+		&InterpreterImplFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+	},
+	Description: `
+The substate-cli import command requires at least one argument:
+<file|dir>...
+
+Every argument is a JSON file or a directory whose .json files are imported
+in lexical order. A file holds JSON substates with the fields inputAlloc,
+outputAlloc, env, message and result, either as a single object, an array,
+or one object per line, or GeneralStateTests fixtures.
+
+Every imported transaction is stored as transaction 0 of its own block,
+starting at --first-block, and its environment takes the number of that
+block. A JSON substate recorded at another block is executed at its new
+block under the selected chain profile and skipped if its output changes;
+its block hashes keep their distance to the current block. The output of a
+state test is computed by executing its transaction under the fork active at
+its new block in the selected chain profile, once for every post state of
+that fork. State tests provide no block hashes, so tests whose code may
+execute BLOCKHASH are skipped.`,
+}
+
+// stateTestFixture is a GeneralStateTests fixture as read by the import
+// command. Numbers may be hex or decimal, and storage slots may be short.
+type stateTestFixture struct {
+	Env struct {
+		Coinbase   common.Address        `json:"currentCoinbase"`
+		Difficulty *math.HexOrDecimal256 `json:"currentDifficulty"`
+		GasLimit   math.HexOrDecimal64   `json:"currentGasLimit"`
+		Number     math.HexOrDecimal64   `json:"currentNumber"`
+		Timestamp  math.HexOrDecimal64   `json:"currentTimestamp"`
+		BaseFee    *math.HexOrDecimal256 `json:"currentBaseFee"`
+	} `json:"env"`
+	Pre map[common.Address]struct {
+		Balance *math.HexOrDecimal256 `json:"balance"`
+		Code    hexutil.Bytes         `json:"code"`
+		Nonce   math.HexOrDecimal64   `json:"nonce"`
+		Storage map[string]string     `json:"storage"`
+	} `json:"pre"`
+	Transaction struct {
+		Data                 []hexutil.Bytes         `json:"data"`
+		GasLimit             []math.HexOrDecimal64   `json:"gasLimit"`
+		Value                []*math.HexOrDecimal256 `json:"value"`
+		Nonce                math.HexOrDecimal64     `json:"nonce"`
+		To                   string                  `json:"to"`
+		Sender               *common.Address         `json:"sender"`
+		SecretKey            hexutil.Bytes           `json:"secretKey"`
+		GasPrice             *math.HexOrDecimal256   `json:"gasPrice"`
+		MaxFeePerGas         *math.HexOrDecimal256   `json:"maxFeePerGas"`
+		MaxPriorityFeePerGas *math.HexOrDecimal256   `json:"maxPriorityFeePerGas"`
+		AccessLists          []*types.AccessList     `json:"accessLists"`
+	} `json:"transaction"`
+	Post map[string][]struct {
+		Indexes struct {
+			Data  int `json:"data"`
+			Gas   int `json:"gas"`
+			Value int `json:"value"`
+		} `json:"indexes"`
+		ExpectException string `json:"expectException"`
+	} `json:"post"`
+}
+
+// substateImporter stores imported substates at consecutive blocks.
+type substateImporter struct {
+	config   ReplayConfig
+	block    uint64
+	imported int
+	skipped  int
+	progress *progress.Reporter
+}
+
+func (i *substateImporter) put(st *substate.Substate, source string) error {
+	if substate.HasSubstate(i.block, 0) {
+		return fmt.Errorf("block %v already holds a substate, choose another --%v", i.block, FirstBlockFlag.Name)
+	}
+	substate.PutSubstate(i.block, 0, st)
+	fmt.Printf("substate-cli import: %v -> block %v tx 0\n", source, i.block)
+	i.progress.Add(i.block, 1, 1, st.Result.GasUsed)
+	i.block++
+	i.imported++
+	return nil
+}
+
+func (i *substateImporter) skip(source string, err error) {
+	fmt.Printf("substate-cli import: skipped %v: %v\n", source, err)
+	i.skipped++
+}
+
+// newImportedSubstate converts a JSON substate. Missing optional values are
+// set to the defaults used by recorded substates.
+func newImportedSubstate(stJSON *substate.SubstateJSON) (*substate.Substate, error) {
+	if stJSON.Env == nil || stJSON.Message == nil || stJSON.Result == nil {
+		return nil, errors.New("substate requires env, message and result")
+	}
+	if stJSON.Message.GasPrice == nil || stJSON.Message.Value == nil {
+		return nil, errors.New("message requires gasPrice and value")
+	}
+	if stJSON.Env.Difficulty == nil {
+		stJSON.Env.Difficulty = new(math.HexOrDecimal256)
+	}
+	if stJSON.Env.BaseFee == nil {
+		stJSON.Env.BaseFee = new(math.HexOrDecimal256)
+	}
+	if stJSON.Message.GasFeeCap == nil {
+		stJSON.Message.GasFeeCap = new(math.HexOrDecimal256)
+	}
+	if stJSON.Message.GasTipCap == nil {
+		stJSON.Message.GasTipCap = new(math.HexOrDecimal256)
+	}
+	for _, alloc := range []substate.SubstateAllocJSON{stJSON.InputAlloc, stJSON.OutputAlloc} {
+		for _, account := range alloc {
+			if account.Balance == nil {
+				account.Balance = new(math.HexOrDecimal256)
+			}
+		}
+	}
+	st := &substate.Substate{
+		Env:     &substate.SubstateEnv{},
+		Message: &substate.SubstateMessage{},
+		Result:  &substate.SubstateResult{},
+	}
+	st.SetJSON(stJSON)
+	return st, nil
+}
+
+// renumber moves a JSON substate to the block it is stored at. The block
+// hashes keep their distance to the current block, and the transaction must
+// reproduce the recorded output at the new block.
+func (i *substateImporter) renumber(st *substate.Substate) error {
+	number := st.Env.Number
+	if number == i.block {
+		return nil
+	}
+	hashes := make(map[uint64]common.Hash, len(st.Env.BlockHashes))
+	for hashNumber, hash := range st.Env.BlockHashes {
+		if hashNumber >= number || number-hashNumber > i.block {
+			return fmt.Errorf("block hash of block %v cannot be moved from block %v to block %v", hashNumber, number, i.block)
+		}
+		hashes[i.block-(number-hashNumber)] = hash
+	}
+	st.Env.Number = i.block
+	st.Env.BlockHashes = hashes
+	result, outputAlloc, err := runSubstate(i.config, i.config.vm_impl, i.block, 0, st, copyAlloc(st.InputAlloc), nil)
+	if err != nil {
+		return fmt.Errorf("execution at block %v instead of %v failed: %v", i.block, number, err)
+	}
+	if diff := NewTransactionDiff(i.block, 0, st.Message.To, st.Result, st.OutputAlloc, result, outputAlloc); diff != nil {
+		return fmt.Errorf("execution at block %v instead of %v: %v", i.block, number, &InconsistentOutputError{Diff: diff})
+	}
+	return nil
+}
+
+// newStateTestSubstate executes a transaction variant of a state test and
+// returns it as a substate.
+func (i *substateImporter) newStateTestSubstate(test *stateTestFixture, dataIndex, gasIndex, valueIndex int) (*substate.Substate, error) {
+	tx := &test.Transaction
+	if dataIndex >= len(tx.Data) || gasIndex >= len(tx.GasLimit) || valueIndex >= len(tx.Value) {
+		return nil, errors.New("post state index out of range")
+	}
+
+	inputAlloc := substate.SubstateAlloc{}
+	for address, account := range test.Pre {
+		balance := new(big.Int)
+		if account.Balance != nil {
+			balance = (*big.Int)(account.Balance)
+		}
+		sa := substate.NewSubstateAccount(uint64(account.Nonce), balance, account.Code)
+		for key, value := range account.Storage {
+			sa.Storage[common.HexToHash(key)] = common.HexToHash(value)
+		}
+		inputAlloc[address] = sa
+	}
+
+	env := &substate.SubstateEnv{
+		Coinbase:    test.Env.Coinbase,
+		Difficulty:  new(big.Int),
+		GasLimit:    uint64(test.Env.GasLimit),
+		Number:      i.block,
+		Timestamp:   uint64(test.Env.Timestamp),
+		BlockHashes: map[uint64]common.Hash{},
+	}
+	if test.Env.Difficulty != nil {
+		env.Difficulty = (*big.Int)(test.Env.Difficulty)
+	}
+	if test.Env.BaseFee != nil {
+		env.BaseFee = (*big.Int)(test.Env.BaseFee)
+	}
+
+	msg := &substate.SubstateMessage{
+		Nonce:      uint64(tx.Nonce),
+		CheckNonce: true,
+		Gas:        uint64(tx.GasLimit[gasIndex]),
+		Value:      new(big.Int),
+		Data:       tx.Data[dataIndex],
+	}
+	if tx.Value[valueIndex] != nil {
+		msg.Value = (*big.Int)(tx.Value[valueIndex])
+	}
+	if tx.To != "" {
+		to := common.HexToAddress(tx.To)
+		msg.To = &to
+	}
+	switch {
+	case tx.Sender != nil:
+		msg.From = *tx.Sender
+	case len(tx.SecretKey) > 0:
+		key, err := crypto.ToECDSA(tx.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secretKey: %v", err)
+		}
+		msg.From = crypto.PubkeyToAddress(key.PublicKey)
+	default:
+		return nil, errors.New("transaction requires a sender or a secretKey")
+	}
+	switch {
+	case tx.GasPrice != nil:
+		msg.GasPrice = (*big.Int)(tx.GasPrice)
+		msg.GasFeeCap = msg.GasPrice
+		msg.GasTipCap = msg.GasPrice
+	case tx.MaxFeePerGas != nil && tx.MaxPriorityFeePerGas != nil:
+		msg.GasFeeCap = (*big.Int)(tx.MaxFeePerGas)
+		msg.GasTipCap = (*big.Int)(tx.MaxPriorityFeePerGas)
+		msg.GasPrice = msg.GasFeeCap
+		if env.BaseFee != nil {
+			msg.GasPrice = math.BigMin(msg.GasFeeCap, new(big.Int).Add(env.BaseFee, msg.GasTipCap))
+		}
+	default:
+		return nil, errors.New("transaction requires a gasPrice or maxFeePerGas and maxPriorityFeePerGas")
+	}
+	if dataIndex < len(tx.AccessLists) && tx.AccessLists[dataIndex] != nil {
+		msg.AccessList = *tx.AccessLists[dataIndex]
+	}
+
+	st := &substate.Substate{
+		InputAlloc: inputAlloc,
+		Env:        env,
+		Message:    msg,
+		Result:     &substate.SubstateResult{},
+	}
+	if utils.MayExecuteBlockHash(st) {
+		return nil, errors.New("code may execute BLOCKHASH, but state tests provide no block hashes")
+	}
+	result, outputAlloc, err := runSubstate(i.config, i.config.vm_impl, i.block, 0, st, copyAlloc(inputAlloc), nil)
+	if err != nil {
+		return nil, err
+	}
+	st.OutputAlloc = outputAlloc
+	st.Result = result
+	return st, nil
+}
+
+// importStateTests imports all post states of the state tests in a fixture
+// file for the fork active at the block they are stored at.
+func (i *substateImporter) importStateTests(tests map[string]*stateTestFixture, source string) error {
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		test := tests[name]
+		testSource := fmt.Sprintf("%v:%v", source, name)
+		fork, err := stateTestFork(i.config.chain_config, i.block)
+		if err != nil {
+			i.skip(testSource, err)
+			continue
+		}
+		posts, found := test.Post[fork]
+		if !found {
+			forks := make([]string, 0, len(test.Post))
+			for name := range test.Post {
+				forks = append(forks, name)
+			}
+			sort.Strings(forks)
+			i.skip(testSource, fmt.Errorf("no post state for %v at block %v, available forks are %v", fork, i.block, strings.Join(forks, ", ")))
+			continue
+		}
+		for index, post := range posts {
+			postSource := fmt.Sprintf("%v/%v/%d", testSource, fork, index)
+			if post.ExpectException != "" {
+				i.skip(postSource, fmt.Errorf("invalid transaction (%v)", post.ExpectException))
+				continue
+			}
+			st, err := i.newStateTestSubstate(test, post.Indexes.Data, post.Indexes.Gas, post.Indexes.Value)
+			if err != nil {
+				i.skip(postSource, err)
+				continue
+			}
+			if err := i.put(st, postSource); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importValue imports a JSON substate, an array of JSON substates, or a
+// state test fixture.
+func (i *substateImporter) importValue(value json.RawMessage, source string) error {
+	value = bytes.TrimSpace(value)
+	if len(value) > 0 && value[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(value, &list); err != nil {
+			return fmt.Errorf("%v: %v", source, err)
+		}
+		for index, entry := range list {
+			if err := i.importValue(entry, fmt.Sprintf("%v[%d]", source, index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return fmt.Errorf("%v: %v", source, err)
+	}
+	if _, isSubstate := fields["inputAlloc"]; isSubstate {
+		var stJSON substate.SubstateJSON
+		if err := json.Unmarshal(value, &stJSON); err != nil {
+			return fmt.Errorf("%v: %v", source, err)
+		}
+		st, err := newImportedSubstate(&stJSON)
+		if err != nil {
+			return fmt.Errorf("%v: %v", source, err)
+		}
+		if err := i.renumber(st); err != nil {
+			i.skip(source, err)
+			return nil
+		}
+		return i.put(st, source)
+	}
+
+	var tests map[string]*stateTestFixture
+	if err := json.Unmarshal(value, &tests); err != nil {
+		return fmt.Errorf("%v: neither a substate nor a state test fixture: %v", source, err)
+	}
+	return i.importStateTests(tests, source)
+}
+
+func (i *substateImporter) importFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	for index := 0; ; index++ {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+		source := filename
+		if index > 0 || decoder.More() {
+			source = fmt.Sprintf("%v#%d", filename, index)
+		}
+		if err := i.importValue(value, source); err != nil {
+			return err
+		}
+	}
+}
+
+// func importAction for import command
+func importAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() < 1 {
+		return fmt.Errorf("substate-cli import command requires at least 1 argument")
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli import: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("chain-profile: %v\n", chainProfile.Name)
+
+	importer := &substateImporter{
+		config: ReplayConfig{
+			vm_impl:       ctx.String(InterpreterImplFlag.Name),
+			chain_profile: chainProfile,
+			chain_config:  chainProfile.ChainConfig(),
+		},
+		block: ctx.Uint64(FirstBlockFlag.Name),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		importer.config.vm_impl = chainProfile.VM.Interpreter
+	}
+
+	// collect input files
+	files := []string{}
+	for _, arg := range ctx.Args().Slice() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return fmt.Errorf("substate-cli import: %v", err)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(path, ".json") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("substate-cli import: %v", err)
+		}
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDB()
+	defer substate.CloseSubstateDB()
+
+	first := importer.block
+	importer.progress, err = progress.NewReporter(ctx, "substate-cli import", first, progress.Unbounded)
+	if err != nil {
+		return fmt.Errorf("substate-cli import: %v", err)
+	}
+	for _, filename := range files {
+		if err := importer.importFile(filename); err != nil {
+			importer.progress.Close()
+			return fmt.Errorf("substate-cli import: %v", err)
+		}
+	}
+	if err := importer.progress.Close(); err != nil {
+		return fmt.Errorf("substate-cli import: %v", err)
+	}
+	if importer.imported > 0 {
+		fmt.Printf("substate-cli import: imported %v transactions into blocks %v-%v\n", importer.imported, first, importer.block-1)
+	} else {
+		fmt.Printf("substate-cli import: no transactions imported\n")
+	}
+	if importer.skipped > 0 {
+		fmt.Printf("substate-cli import: skipped %v transactions\n", importer.skipped)
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package replay
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
 
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
@@ -16,8 +20,12 @@ var GetKeyStatsCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&OutputFlag,
 	},
 	Description: `
 The substate-cli key-stats command requires two arguments:
@@ -27,29 +35,49 @@ The substate-cli key-stats command requires two arguments:
 last block of the inclusive range of blocks to be analysed.
 
 Statistics on the usage of accessed storage locations are printed to the console.
+With --output, the reference frequency and key length distributions are also
+written to a CSV, JSON-lines or SQLite file.
 `,
 }
 
 // getKeyStatsAction collects statistical information on the usage
 // of keys (=addresses of storage locations) in transactions.
 func getKeyStatsAction(ctx *cli.Context) error {
-	return getReferenceStatsActionWithConsumer(ctx, "key-stats", func(info *TransactionInfo) []common.Hash {
-		keys := []common.Hash{}
-		for _, account := range info.st.InputAlloc {
-			for key := range account.Storage {
-				keys = append(keys, key)
-			}
+	return getReferenceStatsActionWithConsumer(ctx, "key-stats", extractKeys, printKeyValueDistribution)
+}
+
+// extractKeys returns the storage keys accessed by a transaction.
+func extractKeys(info *TransactionInfo) []common.Hash {
+	keys := []common.Hash{}
+	for _, account := range info.st.InputAlloc {
+		for key := range account.Storage {
+			keys = append(keys, key)
 		}
-		for _, account := range info.st.OutputAlloc {
-			for key := range account.Storage {
-				keys = append(keys, key)
-			}
+	}
+	for _, account := range info.st.OutputAlloc {
+		for key := range account.Storage {
+			keys = append(keys, key)
 		}
-		return keys
-	}, printKeyValueDistribution)
+	}
+	// sorted, since the order of map iteration is random
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	return keys
+}
+
+// keyLengthSchema declares the key length distribution of the key-stats command.
+var keyLengthSchema = &metrics.Schema{
+	Table: "key_length_distribution",
+	Columns: []metrics.Column{
+		{Name: "length", Type: metrics.Integer},
+		{Name: "keys", Type: metrics.Integer},
+		{Name: "accesses", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"length"},
 }
 
-func printKeyValueDistribution(stats *AccessStatistics[common.Hash]) {
+func printKeyValueDistribution(stats *AccessStatistics[common.Hash], sink metrics.Sink) error {
 
 	counts := [common.HashLength + 1]int64{}
 	accesses := [common.HashLength + 1]int64{}
@@ -63,6 +91,18 @@ func printKeyValueDistribution(stats *AccessStatistics[common.Hash]) {
 		fmt.Printf("%d, %d, %d\n", i, c, accesses[i])
 	}
 	fmt.Printf("------------------------\n")
+	if sink == nil {
+		return nil
+	}
+	if err := sink.Declare(keyLengthSchema); err != nil {
+		return err
+	}
+	for i, c := range counts {
+		if err := sink.Write(keyLengthSchema.Table, i, c, accesses[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func getLength(h *common.Hash) int {
@@ -0,0 +1,680 @@
+package replay
+
+import (
+	"container/list"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// command line options of the locality commands
+var (
+	WindowSizesFlag = cli.StringFlag{
+		Name:  "window-sizes",
+		Usage: "comma-separated sizes in blocks of the sliding windows measuring the working set",
+		Value: "1,10,100,1000",
+	}
+	CacheSizesFlag = cli.StringFlag{
+		Name:  "cache-sizes",
+		Usage: "comma-separated numbers of targets held by the simulated LRU and LFU caches",
+		Value: "1000,10000,100000,1000000",
+	}
+)
+
+// record-replay: substate-cli address-locality command
+var GetAddressLocalityCommand = cli.Command{
+	Action:    getAddressLocalityAction,
+	Name:      "address-locality",
+	Usage:     "computes temporal locality statistics of addresses",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&WindowSizesFlag,
+		&CacheSizesFlag,
+		&OutputFlag,
+	},
+	Description: `
+The substate-cli address-locality command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be analysed.
+
+The addresses accessed by the transactions are processed in order to
+compute their reuse distance distribution, the working set in sliding
+windows of --window-sizes blocks and the hit rates of LRU and LFU caches
+holding --cache-sizes addresses. The statistics are printed to the console.
+With --output, they are also written to a CSV, JSON-lines or SQLite file.
+`,
+}
+
+// record-replay: substate-cli key-locality command
+var GetKeyLocalityCommand = cli.Command{
+	Action:    getKeyLocalityAction,
+	Name:      "key-locality",
+	Usage:     "computes temporal locality statistics of storage keys",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&WindowSizesFlag,
+		&CacheSizesFlag,
+		&OutputFlag,
+	},
+	Description: `
+The substate-cli key-locality command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be analysed.
+
+The storage keys accessed by the transactions are processed in order to
+compute their reuse distance distribution, the working set in sliding
+windows of --window-sizes blocks and the hit rates of LRU and LFU caches
+holding --cache-sizes keys. The statistics are printed to the console.
+With --output, they are also written to a CSV, JSON-lines or SQLite file.
+`,
+}
+
+// record-replay: substate-cli location-locality command
+var GetLocationLocalityCommand = cli.Command{
+	Action:    getLocationLocalityAction,
+	Name:      "location-locality",
+	Usage:     "computes temporal locality statistics of storage locations",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&WindowSizesFlag,
+		&CacheSizesFlag,
+		&OutputFlag,
+	},
+	Description: `
+The substate-cli location-locality command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be analysed.
+
+The storage locations, identified by a contract address and a key, accessed
+by the transactions are processed in order to compute their reuse distance
+distribution, the working set in sliding windows of --window-sizes blocks
+and the hit rates of LRU and LFU caches holding --cache-sizes locations.
+The statistics are printed to the console. With --output, they are also
+written to a CSV, JSON-lines or SQLite file.
+`,
+}
+
+// getAddressLocalityAction collects temporal locality statistics of the
+// addresses accessed by transactions.
+func getAddressLocalityAction(ctx *cli.Context) error {
+	return getLocalityStatsAction(ctx, "address-locality", extractAddresses)
+}
+
+// getKeyLocalityAction collects temporal locality statistics of the storage
+// keys accessed by transactions.
+func getKeyLocalityAction(ctx *cli.Context) error {
+	return getLocalityStatsAction(ctx, "key-locality", extractKeys)
+}
+
+// getLocationLocalityAction collects temporal locality statistics of the
+// storage locations accessed by transactions.
+func getLocationLocalityAction(ctx *cli.Context) error {
+	return getLocalityStatsAction(ctx, "location-locality", newLocationExtractor())
+}
+
+// -------------------- Locality Statistic Data Structure --------------------------
+
+// localityTarget is the last reference of a target.
+type localityTarget struct {
+	position int    // position of the reference in the reference stream
+	block    uint64 // block of the reference
+}
+
+// workingSet measures the number of distinct targets referenced in a sliding
+// window of blocks.
+type workingSet struct {
+	blocks  uint64 // size of the window
+	size    int    // number of targets referenced in the current window
+	samples uint64
+	sum     uint64
+	min     int
+	max     int
+}
+
+func (w *workingSet) sample() {
+	if w.samples == 0 || w.size < w.min {
+		w.min = w.size
+	}
+	if w.size > w.max {
+		w.max = w.size
+	}
+	w.samples++
+	w.sum += uint64(w.size)
+}
+
+// LocalityStatistics collects the temporal locality of references to targets.
+// References must be registered in the order of their transactions.
+//
+// The reuse distance of a reference is the number of distinct targets
+// referenced since the previous reference of the same target. The last
+// reference of every target is marked in a Fenwick tree over the positions
+// of the reference stream, so the distance is the number of marks between
+// two references. An LRU cache of size K hits exactly the references whose
+// reuse distance is less than K, LFU caches are simulated.
+type LocalityStatistics[T comparable] struct {
+	targets map[T]*localityTarget
+	tree    []int // Fenwick tree of last references, indexed from 1
+	next    int   // position of the next reference
+
+	references uint64
+	cold       uint64   // first references of targets
+	distances  []uint64 // references by reuse distance bucket, see getDistanceBucket
+
+	cacheSizes []int
+	lruHits    []uint64
+	lfus       []*lfuCache[T]
+
+	// targets by the block of their last reference, indexed by block modulo
+	// the largest window
+	lastReferences []int
+	windows        []*workingSet
+	started        bool
+	first          uint64
+	block          uint64
+}
+
+// minimum number of positions of the Fenwick tree
+const minLocalityPositions = 1 << 16
+
+func newLocalityStatistics[T comparable](windowSizes []int, cacheSizes []int) *LocalityStatistics[T] {
+	s := &LocalityStatistics[T]{
+		targets:    map[T]*localityTarget{},
+		tree:       make([]int, minLocalityPositions+1),
+		next:       1,
+		cacheSizes: cacheSizes,
+		lruHits:    make([]uint64, len(cacheSizes)),
+	}
+	for _, size := range cacheSizes {
+		s.lfus = append(s.lfus, newLFUCache[T](size))
+	}
+	for _, size := range windowSizes {
+		s.windows = append(s.windows, &workingSet{blocks: uint64(size)})
+	}
+	s.lastReferences = make([]int, windowSizes[len(windowSizes)-1])
+	return s
+}
+
+// RegisterTransaction registers the references of a transaction. Repeated
+// references within a transaction count once, in the order of their first
+// occurrence.
+func (s *LocalityStatistics[T]) RegisterTransaction(block uint64, references []T) {
+	if !s.started {
+		s.started, s.first, s.block = true, block, block
+	} else if block != s.block {
+		s.finishBlock()
+		s.advance(block)
+	}
+	seen := make(map[T]struct{}, len(references))
+	for _, reference := range references {
+		if _, found := seen[reference]; found {
+			continue
+		}
+		seen[reference] = struct{}{}
+		s.registerReference(reference)
+	}
+}
+
+func (s *LocalityStatistics[T]) registerReference(reference T) {
+	// compact while the last reference of every target is marked once
+	if s.next == len(s.tree) {
+		s.compact()
+	}
+	s.references++
+	for _, lfu := range s.lfus {
+		lfu.access(reference)
+	}
+
+	ring := uint64(len(s.lastReferences))
+	target, found := s.targets[reference]
+	if !found {
+		s.cold++
+		target = &localityTarget{}
+		s.targets[reference] = target
+		for _, w := range s.windows {
+			w.size++
+		}
+	} else {
+		distance := s.count(s.next-1) - s.count(target.position)
+		s.addDistance(distance)
+		for i, size := range s.cacheSizes {
+			if distance < size {
+				s.lruHits[i]++
+			}
+		}
+		s.update(target.position, -1)
+
+		if target.block == s.block {
+			// the target is in the working sets already
+			s.lastReferences[s.block%ring]--
+		} else {
+			age := s.block - target.block
+			if age < ring {
+				s.lastReferences[target.block%ring]--
+			}
+			for _, w := range s.windows {
+				if age >= w.blocks {
+					w.size++
+				}
+			}
+		}
+	}
+	s.lastReferences[s.block%ring]++
+
+	target.position = s.next
+	target.block = s.block
+	s.update(s.next, 1)
+	s.next++
+}
+
+// finishBlock samples the working sets of the windows ending at the current
+// block. Windows reaching before the first block are not sampled.
+func (s *LocalityStatistics[T]) finishBlock() {
+	for _, w := range s.windows {
+		if s.block-s.first+1 >= w.blocks {
+			w.sample()
+		}
+	}
+}
+
+// advance moves the windows to end at the given block.
+func (s *LocalityStatistics[T]) advance(block uint64) {
+	ring := uint64(len(s.lastReferences))
+	if block-s.block >= ring {
+		for i := range s.lastReferences {
+			s.lastReferences[i] = 0
+		}
+		for _, w := range s.windows {
+			w.size = 0
+		}
+		s.block = block
+		return
+	}
+	for b := s.block + 1; b <= block; b++ {
+		// the block leaving a window ending at b
+		for _, w := range s.windows {
+			if b >= s.first+w.blocks {
+				w.size -= s.lastReferences[(b-w.blocks)%ring]
+			}
+		}
+		s.lastReferences[b%ring] = 0
+	}
+	s.block = block
+}
+
+// count returns the number of last references up to a position.
+func (s *LocalityStatistics[T]) count(position int) int {
+	sum := 0
+	for ; position > 0; position -= position & -position {
+		sum += s.tree[position]
+	}
+	return sum
+}
+
+func (s *LocalityStatistics[T]) update(position int, delta int) {
+	for ; position < len(s.tree); position += position & -position {
+		s.tree[position] += delta
+	}
+}
+
+// compact renumbers the last references to consecutive positions and resizes
+// the Fenwick tree to twice the number of targets.
+func (s *LocalityStatistics[T]) compact() {
+	targets := make([]*localityTarget, 0, len(s.targets))
+	for _, target := range s.targets {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].position < targets[j].position })
+	size := 2 * len(targets)
+	if size < minLocalityPositions {
+		size = minLocalityPositions
+	}
+	s.tree = make([]int, size+1)
+	for i, target := range targets {
+		target.position = i + 1
+		s.tree[i+1] = 1
+	}
+	// build the tree in linear time
+	for i := 1; i < len(s.tree); i++ {
+		if parent := i + (i & -i); parent < len(s.tree) {
+			s.tree[parent] += s.tree[i]
+		}
+	}
+	s.next = len(targets) + 1
+}
+
+// getDistanceBucket returns the bucket of a reuse distance. Bucket 0 holds
+// the distance 0, bucket i > 0 the distances from 2^(i-1) to 2^i-1.
+func getDistanceBucket(distance int) int {
+	return bits.Len(uint(distance))
+}
+
+// getDistanceRange returns the smallest and the largest distance of a bucket.
+func getDistanceRange(bucket int) (int, int) {
+	if bucket == 0 {
+		return 0, 0
+	}
+	return 1 << (bucket - 1), 1<<bucket - 1
+}
+
+func (s *LocalityStatistics[T]) addDistance(distance int) {
+	bucket := getDistanceBucket(distance)
+	for len(s.distances) <= bucket {
+		s.distances = append(s.distances, 0)
+	}
+	s.distances[bucket]++
+}
+
+// Finish samples the working sets of the last block.
+func (s *LocalityStatistics[T]) Finish() {
+	if s.started {
+		s.finishBlock()
+	}
+}
+
+func getRate(hits uint64, references uint64) float64 {
+	if references == 0 {
+		return 0
+	}
+	return float64(hits) / float64(references)
+}
+
+func (s *LocalityStatistics[T]) PrintSummary() {
+	fmt.Printf("Reuse distance distribution:\n")
+	for bucket, references := range s.distances {
+		low, high := getDistanceRange(bucket)
+		fmt.Printf("%d-%d, %d\n", low, high, references)
+	}
+	fmt.Printf("Working set size (blocks, windows, min, average, max):\n")
+	for _, w := range s.windows {
+		if w.samples == 0 {
+			fmt.Printf("%d, 0, -, -, -\n", w.blocks)
+			continue
+		}
+		fmt.Printf("%d, %d, %d, %.2f, %d\n", w.blocks, w.samples, w.min, float64(w.sum)/float64(w.samples), w.max)
+	}
+	fmt.Printf("Cache hit rate (size, LRU, LFU):\n")
+	for i, size := range s.cacheSizes {
+		fmt.Printf("%d, %.4f, %.4f\n", size, getRate(s.lruHits[i], s.references), getRate(s.lfus[i].hits, s.references))
+	}
+	fmt.Printf("Number of targets:          %15d\n", len(s.targets))
+	fmt.Printf("Number of references:       %15d\n", s.references)
+	fmt.Printf("First references:           %15d\n", s.cold)
+}
+
+// newLocalitySchemas declares the tables of a locality command.
+func newLocalitySchemas(cli_command string) (reuse, workingSet, cache *metrics.Schema) {
+	prefix := strings.ReplaceAll(cli_command, "-", "_")
+	reuse = &metrics.Schema{
+		Table: prefix + "_reuse_distance",
+		Columns: []metrics.Column{
+			{Name: "min_distance", Type: metrics.Integer},
+			{Name: "max_distance", Type: metrics.Integer},
+			{Name: "reference_count", Type: metrics.Integer},
+		},
+		PrimaryKey: []string{"min_distance"},
+	}
+	workingSet = &metrics.Schema{
+		Table: prefix + "_working_set",
+		Columns: []metrics.Column{
+			{Name: "window_blocks", Type: metrics.Integer},
+			{Name: "windows", Type: metrics.Integer},
+			{Name: "min_targets", Type: metrics.Integer},
+			{Name: "avg_targets", Type: metrics.Real},
+			{Name: "max_targets", Type: metrics.Integer},
+		},
+		PrimaryKey: []string{"window_blocks"},
+	}
+	cache = &metrics.Schema{
+		Table: prefix + "_cache",
+		Columns: []metrics.Column{
+			{Name: "cache_size", Type: metrics.Integer},
+			{Name: "reference_count", Type: metrics.Integer},
+			{Name: "lru_hits", Type: metrics.Integer},
+			{Name: "lru_hit_rate", Type: metrics.Real},
+			{Name: "lfu_hits", Type: metrics.Integer},
+			{Name: "lfu_hit_rate", Type: metrics.Real},
+		},
+		PrimaryKey: []string{"cache_size"},
+	}
+	return reuse, workingSet, cache
+}
+
+// Write writes the reuse distance distribution, the working sets and the
+// cache hit rates to a sink. Windows without samples are skipped.
+func (s *LocalityStatistics[T]) Write(sink metrics.Sink, reuse, workingSet, cache *metrics.Schema) error {
+	for bucket, references := range s.distances {
+		low, high := getDistanceRange(bucket)
+		if err := sink.Write(reuse.Table, low, high, references); err != nil {
+			return err
+		}
+	}
+	for _, w := range s.windows {
+		if w.samples == 0 {
+			continue
+		}
+		if err := sink.Write(workingSet.Table, w.blocks, w.samples, w.min, float64(w.sum)/float64(w.samples), w.max); err != nil {
+			return err
+		}
+	}
+	for i, size := range s.cacheSizes {
+		lfuHits := s.lfus[i].hits
+		if err := sink.Write(cache.Table, size, s.references, s.lruHits[i], getRate(s.lruHits[i], s.references), lfuHits, getRate(lfuHits, s.references)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lfuCache simulates a cache evicting the least frequently used target,
+// and the least recently used one among equally frequent targets. Frequencies
+// are counted while a target is cached.
+type lfuCache[T comparable] struct {
+	capacity    int
+	entries     map[T]*list.Element
+	frequencies map[uint64]*list.List // entries by frequency, most recent first
+	min         uint64                // lowest frequency of a cached target
+	hits        uint64
+}
+
+type lfuEntry[T comparable] struct {
+	target    T
+	frequency uint64
+}
+
+func newLFUCache[T comparable](capacity int) *lfuCache[T] {
+	return &lfuCache[T]{
+		capacity:    capacity,
+		entries:     map[T]*list.Element{},
+		frequencies: map[uint64]*list.List{},
+	}
+}
+
+// access references a target and reports whether it was cached.
+func (c *lfuCache[T]) access(target T) bool {
+	if element, found := c.entries[target]; found {
+		entry := element.Value.(*lfuEntry[T])
+		c.remove(element, entry.frequency)
+		if c.min == entry.frequency && c.frequencies[entry.frequency] == nil {
+			c.min++
+		}
+		entry.frequency++
+		c.entries[target] = c.push(entry)
+		c.hits++
+		return true
+	}
+	if len(c.entries) >= c.capacity {
+		victim := c.frequencies[c.min].Back()
+		c.remove(victim, c.min)
+		delete(c.entries, victim.Value.(*lfuEntry[T]).target)
+	}
+	c.entries[target] = c.push(&lfuEntry[T]{target: target, frequency: 1})
+	c.min = 1
+	return false
+}
+
+func (c *lfuCache[T]) push(entry *lfuEntry[T]) *list.Element {
+	entries, found := c.frequencies[entry.frequency]
+	if !found {
+		entries = list.New()
+		c.frequencies[entry.frequency] = entries
+	}
+	return entries.PushFront(entry)
+}
+
+func (c *lfuCache[T]) remove(element *list.Element, frequency uint64) {
+	entries := c.frequencies[frequency]
+	entries.Remove(element)
+	if entries.Len() == 0 {
+		delete(c.frequencies, frequency)
+	}
+}
+
+// ----------------------------- Locality Statistic Tools ---------------------------------
+
+// parseSizes parses a comma-separated list of positive sizes of a flag and
+// returns them in ascending order.
+func parseSizes(flag string, list string) ([]int, error) {
+	sizes := []int{}
+	seen := map[int]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(entry))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size in --%v: %q", flag, entry)
+		}
+		if !seen[size] {
+			seen[size] = true
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// getLocalityStatsAction a generic utility to collect temporal locality
+// statistics from recorded substate data. The transactions are processed in
+// order, the substates are decoded in parallel.
+func getLocalityStatsAction[T comparable](ctx *cli.Context, cli_command string, extract Extractor[T]) error {
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli %v command requires exactly 2 arguments", cli_command)
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("git-date: %v\n", gitDate)
+	fmt.Printf("git-commit: %v\n", gitCommit)
+
+	first, last, argErr := SetBlockRange(ctx.Args().Get(0), ctx.Args().Get(1))
+	if argErr != nil {
+		return argErr
+	}
+	windowSizes, err := parseSizes(WindowSizesFlag.Name, ctx.String(WindowSizesFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli %v: %v", cli_command, err)
+	}
+	cacheSizes, err := parseSizes(CacheSizesFlag.Name, ctx.String(CacheSizesFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli %v: %v", cli_command, err)
+	}
+	workers := ctx.Int(substate.WorkersFlag.Name)
+	if workers < 1 {
+		workers = 1
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDBReadOnly()
+	defer substate.CloseSubstateDB()
+
+	reporter, err := progress.NewReporter(ctx, fmt.Sprintf("substate-cli %v", cli_command), first, last)
+	if err != nil {
+		return fmt.Errorf("substate-cli %v: %v", cli_command, err)
+	}
+
+	// Process all transactions in order.
+	stats := newLocalityStatistics[T](windowSizes, cacheSizes)
+	iter := substate.NewSubstateIterator(first, workers)
+	lastBlock, hasBlock := uint64(0), false
+	for iter.Next() {
+		tx := iter.Value()
+		if tx.Block > last {
+			break
+		}
+		info := TransactionInfo{
+			block: tx.Block,
+			tx:    tx.Transaction,
+			st:    tx.Substate,
+		}
+		stats.RegisterTransaction(tx.Block, extract(&info))
+
+		// blocks without substates count as progress as well
+		if !hasBlock {
+			reporter.Add(tx.Block, tx.Block-first+1, 0, 0)
+		} else if tx.Block != lastBlock {
+			reporter.Add(tx.Block, tx.Block-lastBlock, 0, 0)
+		}
+		lastBlock, hasBlock = tx.Block, true
+		reporter.Add(tx.Block, 0, 1, tx.Substate.Result.GasUsed)
+	}
+	iter.Release()
+	if last != progress.Unbounded {
+		if hasBlock {
+			reporter.Add(last, last-lastBlock, 0, 0)
+		} else {
+			reporter.Add(last, last-first+1, 0, 0)
+		}
+	}
+	if err := reporter.Close(); err != nil {
+		return fmt.Errorf("substate-cli %v: %v", cli_command, err)
+	}
+	stats.Finish()
+
+	// Print the statistics.
+	fmt.Printf("\n\n----- Summary: -------\n")
+	stats.PrintSummary()
+	fmt.Printf("----------------------\n")
+
+	// Write the statistics if requested.
+	if ctx.String(OutputFlag.Name) == "" {
+		return nil
+	}
+	reuse, workingSet, cache := newLocalitySchemas(cli_command)
+	sink, err := openMetricsSink(ctx, cli_command, reuse, workingSet, cache)
+	if err != nil {
+		return err
+	}
+	err = stats.Write(sink, reuse, workingSet, cache)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+)
+
+// registerStream registers every reference as a transaction of the block.
+func registerStream(s *LocalityStatistics[string], block uint64, stream string) {
+	for _, reference := range stream {
+		s.RegisterTransaction(block, []string{string(reference)})
+	}
+}
+
+func TestLocalityStatisticsReuseDistance(t *testing.T) {
+	s := newLocalityStatistics[string]([]int{1}, []int{1, 2, 3})
+	// A and B and C are cold, the reuses of A, B and A have the distances
+	// 1 (B), 2 (A, C) and 2 (C, B)
+	registerStream(s, 1, "ABACBA")
+	s.Finish()
+
+	if s.references != 6 || s.cold != 3 {
+		t.Errorf("unexpected references, wanted 6 with 3 cold, got %d with %d cold", s.references, s.cold)
+	}
+	if want := []uint64{0, 1, 2}; !reflect.DeepEqual(s.distances, want) {
+		t.Errorf("unexpected distances, wanted %v, got %v", want, s.distances)
+	}
+	if want := []uint64{0, 1, 3}; !reflect.DeepEqual(s.lruHits, want) {
+		t.Errorf("unexpected LRU hits, wanted %v, got %v", want, s.lruHits)
+	}
+}
+
+func TestLocalityStatisticsIgnoresRepeatedReferencesOfTransaction(t *testing.T) {
+	s := newLocalityStatistics[string]([]int{1}, []int{1})
+	s.RegisterTransaction(1, []string{"A", "B", "A"})
+	s.RegisterTransaction(1, []string{"A"})
+	// the second transaction reuses A at distance 1 (B)
+	if s.references != 3 || s.cold != 2 {
+		t.Errorf("unexpected references, wanted 3 with 2 cold, got %d with %d cold", s.references, s.cold)
+	}
+	if want := []uint64{0, 1}; !reflect.DeepEqual(s.distances, want) {
+		t.Errorf("unexpected distances, wanted %v, got %v", want, s.distances)
+	}
+}
+
+func TestLocalityStatisticsCompaction(t *testing.T) {
+	s := newLocalityStatistics[string]([]int{1}, []int{2, 3})
+	// the stream is longer than the Fenwick tree, so it is compacted several
+	// times; every reuse of the cycle has the distance 2
+	cycles := minLocalityPositions
+	for i := 0; i < cycles; i++ {
+		registerStream(s, 1, "ABC")
+	}
+	reuses := uint64(3*cycles - 3)
+	if want := []uint64{0, 0, reuses}; !reflect.DeepEqual(s.distances, want) {
+		t.Errorf("unexpected distances, wanted %v, got %v", want, s.distances)
+	}
+	if want := []uint64{0, reuses}; !reflect.DeepEqual(s.lruHits, want) {
+		t.Errorf("unexpected LRU hits, wanted %v, got %v", want, s.lruHits)
+	}
+	if marks := s.count(len(s.tree) - 1); marks != 3 {
+		t.Errorf("unexpected number of marked last references, wanted 3, got %d", marks)
+	}
+}
+
+func TestLocalityStatisticsWorkingSet(t *testing.T) {
+	s := newLocalityStatistics[string]([]int{1, 2}, []int{1})
+	registerStream(s, 1, "AB")
+	registerStream(s, 2, "A")
+	registerStream(s, 3, "C")
+	// block 4 holds no transactions
+	registerStream(s, 5, "A")
+	s.Finish()
+
+	tests := []struct {
+		samples, sum uint64
+		min, max     int
+	}{
+		// blocks 1, 2, 3 and 5 reference 2, 1, 1 and 1 targets
+		{samples: 4, sum: 5, min: 1, max: 2},
+		// windows ending at blocks 2, 3 and 5 reference {A, B}, {A, C} and {A}
+		{samples: 3, sum: 5, min: 1, max: 2},
+	}
+	for i, test := range tests {
+		w := s.windows[i]
+		if w.samples != test.samples || w.sum != test.sum || w.min != test.min || w.max != test.max {
+			t.Errorf("unexpected working set of %d blocks, wanted %+v, got samples %d, sum %d, min %d, max %d", w.blocks, test, w.samples, w.sum, w.min, w.max)
+		}
+	}
+}
+
+func TestLocalityStatisticsLFU(t *testing.T) {
+	s := newLocalityStatistics[string]([]int{1}, []int{1, 2})
+	// with 2 targets: A hits, C evicts B (frequency 1), A hits, B evicts C
+	registerStream(s, 1, "AABCAB")
+	if s.lfus[0].hits != 1 || s.lfus[1].hits != 2 {
+		t.Errorf("unexpected LFU hits, wanted 1 and 2, got %d and %d", s.lfus[0].hits, s.lfus[1].hits)
+	}
+	if cache := s.lfus[1].entries; cache["A"] == nil || cache["B"] == nil || cache["C"] != nil {
+		t.Errorf("unexpected targets cached by LFU of size 2")
+	}
+}
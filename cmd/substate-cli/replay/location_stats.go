@@ -1,8 +1,11 @@
 package replay
 
 import (
+	"bytes"
+	"sort"
 	"sync"
 
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
@@ -16,8 +19,12 @@ var GetLocationStatsCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&OutputFlag,
 	},
 	Description: `
 The substate-cli location-stats command requires two arguments:
@@ -27,6 +34,8 @@ The substate-cli location-stats command requires two arguments:
 last block of the inclusive range of blocks to be analysed.
 
 Statistics on the usage of accessed storage locations are printed to the console.
+With --output, the reference frequency distribution is also written to a
+CSV, JSON-lines or SQLite file.
 `,
 }
 
@@ -59,24 +68,37 @@ type Location struct {
 // of storage locations identified by a contracts address and the memory
 // location key.
 func getLocationStatsAction(ctx *cli.Context) error {
+	return getReferenceStatsAction(ctx, "location-stats", newLocationExtractor())
+}
+
+// newLocationExtractor creates an extractor of the storage locations accessed
+// by a transaction. Addresses and keys are indexed by the extractor.
+func newLocationExtractor() Extractor[Location] {
 	var address_index Index[common.Address]
 	var key_index Index[common.Hash]
-	return getReferenceStatsAction(ctx, "location-stats", func(info *TransactionInfo) []Location {
-		locations := []Location{}
-		for address, account := range info.st.InputAlloc {
-			address_id := address_index.Get(&address)
-			for key := range account.Storage {
-				key_id := key_index.Get(&key)
-				locations = append(locations, Location{address_id, key_id})
+	return func(info *TransactionInfo) []Location {
+		slots := []storageSlot{}
+		for _, alloc := range []substate.SubstateAlloc{info.st.InputAlloc, info.st.OutputAlloc} {
+			for address, account := range alloc {
+				for key := range account.Storage {
+					slots = append(slots, storageSlot{address, key})
+				}
 			}
 		}
-		for address, account := range info.st.OutputAlloc {
-			address_id := address_index.Get(&address)
-			for key := range account.Storage {
-				key_id := key_index.Get(&key)
-				locations = append(locations, Location{address_id, key_id})
+		// sorted before they are indexed, since the order of map iteration
+		// is random
+		sort.Slice(slots, func(i, j int) bool {
+			if res := bytes.Compare(slots[i].address[:], slots[j].address[:]); res != 0 {
+				return res < 0
 			}
+			return bytes.Compare(slots[i].key[:], slots[j].key[:]) < 0
+		})
+		locations := make([]Location, 0, len(slots))
+		for _, slot := range slots {
+			address_id := address_index.Get(&slot.address)
+			key_id := key_index.Get(&slot.key)
+			locations = append(locations, Location{address_id, key_id})
 		}
 		return locations
-	})
+	}
 }
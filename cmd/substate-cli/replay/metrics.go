@@ -0,0 +1,29 @@
+package replay
+
+import (
+	"fmt"
+
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/urfave/cli/v2"
+)
+
+// openMetricsSink opens the sink selected by --output for an experiment of
+// the given type and declares the tables written by the command.
+func openMetricsSink(ctx *cli.Context, experiment string, schemas ...*metrics.Schema) (metrics.Sink, error) {
+	sink, err := metrics.Open(ctx.String(OutputFlag.Name), metrics.Experiment{
+		Type:      experiment,
+		ChainID:   chainID,
+		GitDate:   gitDate,
+		GitCommit: gitCommit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("substate-cli %v: %v", experiment, err)
+	}
+	for _, schema := range schemas {
+		if err := sink.Declare(schema); err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("substate-cli %v: %v", experiment, err)
+		}
+	}
+	return sink, nil
+}
@@ -0,0 +1,66 @@
+package replay
+
+import (
+	"context"
+)
+
+// ProfilingCollectorContext is the execution context of a data collector.
+type ProfilingCollectorContext[T any] struct {
+	stats  T
+	ctx    context.Context
+	cancel context.CancelFunc
+	ch     chan struct{}
+}
+
+// ProfilingCollectorFunc is a data collector of the VM. It processes profiling
+// records into stats until ctx is cancelled, and closes done when finished.
+type ProfilingCollectorFunc[T any] func(ctx context.Context, done chan struct{}, stats T)
+
+// ProfilingCollectorPool runs data collectors of a profiler in the background.
+// Every collector accumulates its own statistic, the statistics are merged
+// when the pool is stopped.
+type ProfilingCollectorPool[T any] struct {
+	collectors []*ProfilingCollectorContext[T]
+	newStats   func() T
+	merge      func(dst T, src T)
+}
+
+// NewProfilingCollectorPool starts n data collectors.
+func NewProfilingCollectorPool[T any](n int, collect ProfilingCollectorFunc[T], newStats func() T, merge func(dst T, src T)) *ProfilingCollectorPool[T] {
+	if n < 1 {
+		n = 1
+	}
+	pool := &ProfilingCollectorPool[T]{
+		collectors: make([]*ProfilingCollectorContext[T], n),
+		newStats:   newStats,
+		merge:      merge,
+	}
+	for i := range pool.collectors {
+		dcc := new(ProfilingCollectorContext[T])
+		dcc.ctx, dcc.cancel = context.WithCancel(context.Background())
+		dcc.ch = make(chan struct{})
+		dcc.stats = newStats()
+		pool.collectors[i] = dcc
+		go collect(dcc.ctx, dcc.ch, dcc.stats)
+	}
+	return pool
+}
+
+// NumCollectors returns the number of data collectors of the pool.
+func (p *ProfilingCollectorPool[T]) NumCollectors() int {
+	return len(p.collectors)
+}
+
+// Stop stops all data collectors after the pending records are processed
+// and returns the merged statistic.
+func (p *ProfilingCollectorPool[T]) Stop() T {
+	for _, dcc := range p.collectors {
+		dcc.cancel() // stop data collector
+		<-dcc.ch     // wait for data collector to finish
+	}
+	stats := p.newStats()
+	for _, dcc := range p.collectors {
+		p.merge(stats, dcc.stats)
+	}
+	return stats
+}
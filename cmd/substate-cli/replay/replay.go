@@ -1,16 +1,18 @@
 package replay
 
 import (
-	"bytes"
-	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"runtime/pprof"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 
@@ -36,51 +38,143 @@ var ReplayCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SkipTransferTxsFlag,
 		&substate.SkipCallTxsFlag,
 		&substate.SkipCreateTxsFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
 		&ProfileEVMCallFlag,
+		&OutputFlag,
 		&MicroProfilingFlag,
 		&BasicBlockProfilingFlag,
 		&DatabaseNameFlag,
 		&ChannelBufferSizeFlag,
+		&BasicBlockDatabaseNameFlag,
 		&InterpreterImplFlag,
 		&OnlySuccessfulFlag,
 		&CpuProfilingFlag,
 		&UseInMemoryStateDbFlag,
+		&CrossCheckStateDbFlag,
+		&ReportFileFlag,
+		&ContinueOnErrorFlag,
+		&MaxFailuresFlag,
+		&CompareInterpreterFlag,
+		&TraceDirFlag,
+		&TracerFlag,
+		&TraceTxFlag,
+		&TraceAddressFlag,
+		&TraceOnlyMismatchingFlag,
+		&CheckpointFlag,
+		&ResumeFlag,
 	},
 	Description: `
 The substate-cli replay command requires two arguments:
 <blockNumFirst> <blockNumLast>
 
 <blockNumFirst> and <blockNumLast> are the first and
-last block of the inclusive range of blocks to replay transactions.`,
+last block of the inclusive range of blocks to replay transactions.
+
+If --report is set, every inconsistent transaction is additionally written
+as a JSON line listing the differing result and account fields.
+
+By default, the replay stops at the first failing transaction. With
+--continue-on-error, failures are recorded and the replay continues until
+--max-failures failures have been seen (0 = no limit). A summary of all
+failed transactions grouped by failure category is printed at the end.
+
+With --compare-interpreter <impl1>,<impl2>, every transaction is executed on
+both interpreters starting from the same input substate. The results are
+compared with the recording and with each other, and the diverging side is
+reported. The net VM time of each interpreter is printed at the end.
+
+With --cross-check-statedb, every transaction is executed on the off-the-chain
+and the in-memory StateDB at the same time. All results of StateDB calls, the
+logs, and the post-allocations are compared, and the sequence of StateDB calls
+up to the first disagreement is printed.
+
+With --trace <dir>, a struct-log or call trace (--tracer struct|call) is
+written to <dir>/<block>_<tx>.json for every selected transaction.
+Transactions are selected with --trace-tx, a list of blocks and
+block:tx pairs, and --trace-address, a list of senders and recipients. With
+--trace-only-mismatching, only traces of inconsistent transactions are kept.
+
+With --profiling-call, the runtime and the gas used of every transaction
+calling an account are written to the contract_runtime table, printed as
+metric: lines or written to the file given by --output.
+
+With --checkpoint <file>, the highest block up to which all blocks are
+completed and the statistics accumulated so far are periodically written to
+the given file. An interrupted run continues from there with --resume and the
+same block range; its --report file is continued as well.`,
 }
 
 var vm_duration time.Duration
+var statedb_duration time.Duration
 
 type ReplayConfig struct {
 	vm_impl          string
 	only_successful  bool
 	use_in_memory_db bool
+	cross_check_db   bool
+	chain_profile    *ChainProfile
+	chain_config     *params.ChainConfig
+	trace            *TraceConfig
+	compare_impls    []string
+	report           *DiffReport
+	stats            *replayStats             // statistics of the current block, if checkpointing
+	gas              *gasUsage                // gas usage of the transaction, if requested
+	storage_writes   map[storageSlot]struct{} // storage slots written by the transaction, if requested
+	call_profile     metrics.Sink             // sink of the runtime of calls, if profiling
 }
 
-// data collection execution context
-type MicroProfilingCollectorContext struct {
-	stats  *vm.MicroProfileStatistic
-	ctx    context.Context
-	cancel context.CancelFunc
-	ch     chan struct{}
+// contract_runtime table
+var contractRuntimeSchema = &metrics.Schema{
+	Table: "contract_runtime",
+	Columns: []metrics.Column{
+		{Name: "block_number", Type: metrics.Integer},
+		{Name: "tx_number", Type: metrics.Integer},
+		{Name: "contract", Type: metrics.Text},
+		{Name: "runtime", Type: metrics.Integer},
+		{Name: "gas_used", Type: metrics.Integer},
+	},
+}
+
+// replayStats are the statistics of a replay run saved in checkpoints.
+type replayStats struct {
+	VmDuration           time.Duration            `json:"vmDuration"`
+	InterpreterDurations map[string]time.Duration `json:"interpreterDurations,omitempty"`
+	Failures             []replayStatsFailure     `json:"failures,omitempty"`
+}
+
+type replayStatsFailure struct {
+	Block    uint64 `json:"block"`
+	Tx       int    `json:"tx"`
+	Category string `json:"category,omitempty"`
+	Err      string `json:"error"`
+}
+
+func (s *replayStats) addVmDuration(vm_impl string, delta time.Duration) {
+	s.VmDuration += delta
+	if s.InterpreterDurations == nil {
+		s.InterpreterDurations = map[string]time.Duration{}
+	}
+	s.InterpreterDurations[vm_impl] += delta
 }
 
-// data collection execution context
-type BasicBlockProfilingCollectorContext struct {
-	stats  *vm.BasicBlockProfileStatistic
-	ctx    context.Context
-	cancel context.CancelFunc
-	ch     chan struct{}
+func mergeReplayStats(total *replayStats, block *replayStats) {
+	total.VmDuration += block.VmDuration
+	for vm_impl, duration := range block.InterpreterDurations {
+		if total.InterpreterDurations == nil {
+			total.InterpreterDurations = map[string]time.Duration{}
+		}
+		total.InterpreterDurations[vm_impl] += duration
+	}
+	total.Failures = append(total.Failures, block.Failures...)
 }
 
 func resetVmDuration() {
@@ -95,6 +189,70 @@ func getVmDuration() time.Duration {
 	return time.Duration(atomic.LoadInt64((*int64)(&vm_duration)))
 }
 
+func resetStateDbDuration() {
+	atomic.StoreInt64((*int64)(&statedb_duration), 0)
+}
+
+func addStateDbDuration(delta time.Duration) {
+	atomic.AddInt64((*int64)(&statedb_duration), (int64)(delta))
+}
+
+func getStateDbDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&statedb_duration)))
+}
+
+// number of replay errors per failure category
+var (
+	mismatch_categories_mutex sync.Mutex
+	mismatch_categories       = map[string]int{}
+)
+
+func resetMismatches() {
+	mismatch_categories_mutex.Lock()
+	defer mismatch_categories_mutex.Unlock()
+	mismatch_categories = map[string]int{}
+}
+
+func addMismatch(err error) {
+	mismatch_categories_mutex.Lock()
+	defer mismatch_categories_mutex.Unlock()
+	mismatch_categories[getFailureCategory(err)]++
+}
+
+func getMismatches() map[string]float64 {
+	mismatch_categories_mutex.Lock()
+	defer mismatch_categories_mutex.Unlock()
+	mismatches := make(map[string]float64, len(mismatch_categories))
+	for category, n := range mismatch_categories {
+		mismatches[category] = float64(n)
+	}
+	return mismatches
+}
+
+// net VM time per interpreter implementation
+var (
+	interpreter_durations_mutex sync.Mutex
+	interpreter_durations       = map[string]time.Duration{}
+)
+
+func resetInterpreterVmDurations() {
+	interpreter_durations_mutex.Lock()
+	defer interpreter_durations_mutex.Unlock()
+	interpreter_durations = map[string]time.Duration{}
+}
+
+func addInterpreterVmDuration(vm_impl string, delta time.Duration) {
+	interpreter_durations_mutex.Lock()
+	defer interpreter_durations_mutex.Unlock()
+	interpreter_durations[vm_impl] += delta
+}
+
+func getInterpreterVmDuration(vm_impl string) time.Duration {
+	interpreter_durations_mutex.Lock()
+	defer interpreter_durations_mutex.Unlock()
+	return interpreter_durations[vm_impl]
+}
+
 // replayTask replays a transaction substate
 func replayTask(config ReplayConfig, block uint64, tx int, recording *substate.Substate, taskPool *substate.SubstateTaskPool) error {
 
@@ -103,51 +261,93 @@ func replayTask(config ReplayConfig, block uint64, tx int, recording *substate.S
 		return nil
 	}
 
-	inputAlloc := recording.InputAlloc
-	inputEnv := recording.Env
-	inputMessage := recording.Message
+	// If requested, run the transaction on two interpreters and compare them.
+	if len(config.compare_impls) > 0 {
+		return compareInterpretersTask(config, block, tx, recording)
+	}
 
+	inputMessage := recording.Message
 	outputAlloc := recording.OutputAlloc
 	outputResult := recording.Result
 
-	var (
-		vmConfig    vm.Config
-		chainConfig *params.ChainConfig
-	)
-
-	vmConfig = opera.DefaultVMConfig
-	vmConfig.NoBaseFee = true
+	tracer := config.trace.NewTracer(block, tx, recording)
+	evmResult, evmAlloc, err := runSubstate(config, config.vm_impl, block, tx, recording, recording.InputAlloc, tracer)
+	if err != nil {
+		if traceErr := config.trace.Finish(tracer, block, tx, "", true); traceErr != nil {
+			return traceErr
+		}
+		return err
+	}
 
-	chainConfig = params.AllEthashProtocolChanges
-	chainConfig.ChainID = big.NewInt(int64(chainID))
-	switch chainID {
-	case 250:
-		chainConfig.LondonBlock = new(big.Int).SetUint64(37534833)
-		chainConfig.BerlinBlock = new(big.Int).SetUint64(37455223)
-	case 4002:
-		chainConfig.LondonBlock = new(big.Int).SetUint64(7513335)
-		chainConfig.BerlinBlock = new(big.Int).SetUint64(1559470)
+	r := outputResult.Equal(evmResult)
+	a := outputAlloc.Equal(evmAlloc)
+	if err := config.trace.Finish(tracer, block, tx, "", !(r && a)); err != nil {
+		return err
+	}
+	if !(r && a) {
+		diff := &TransactionDiff{Block: block, Tx: tx, Contract: inputMessage.To}
+		fmt.Printf("block: %v Transaction: %v\n", block, tx)
+		if !r {
+			fmt.Printf("inconsistent output: result\n")
+			resultDiffs := DiffResult(outputResult, evmResult)
+			printDiffs(resultDiffs)
+			diff.Diffs = append(diff.Diffs, resultDiffs...)
+		}
+		if !a {
+			fmt.Printf("inconsistent output: alloc\n")
+			allocDiffs := DiffAlloc(outputAlloc, evmAlloc)
+			printDiffs(allocDiffs)
+			diff.Diffs = append(diff.Diffs, allocDiffs...)
+		}
+		if config.report != nil {
+			if err := config.report.Write(diff); err != nil {
+				return err
+			}
+		}
+		return &InconsistentOutputError{Diff: diff}
 	}
 
+	return nil
+}
+
+// runSubstate executes the message of a recorded transaction on the given
+// input allocation using the selected interpreter and returns the observed
+// result and post-allocation.
+func runSubstate(config ReplayConfig, vm_impl string, block uint64, tx int, recording *substate.Substate, inputAlloc substate.SubstateAlloc, tracer TransactionTracer) (*substate.SubstateResult, substate.SubstateAlloc, error) {
+	inputEnv := recording.Env
+	inputMessage := recording.Message
+
+	vmConfig := opera.DefaultVMConfig
+	config.chain_profile.ApplyVMConfig(&vmConfig)
+	chainConfig := config.chain_config
+
 	var hashError error
 	getHash := func(num uint64) common.Hash {
 		if inputEnv.BlockHashes == nil {
-			hashError = fmt.Errorf("getHash(%d) invoked, no blockhashes provided", num)
+			hashError = newReplayError(categoryMissingBlockHash, fmt.Errorf("getHash(%d) invoked, no blockhashes provided", num))
 			return common.Hash{}
 		}
 		h, ok := inputEnv.BlockHashes[num]
 		if !ok {
-			hashError = fmt.Errorf("getHash(%d) invoked, blockhash for that block not provided", num)
+			hashError = newReplayError(categoryMissingBlockHash, fmt.Errorf("getHash(%d) invoked, blockhash for that block not provided", num))
 		}
 		return h
 	}
 
 	var statedb state.StateDB
-	if config.use_in_memory_db {
+	statedb_start := time.Now()
+	if config.cross_check_db {
+		shadowAlloc := copyAlloc(inputAlloc)
+		statedb = state.MakeShadowStateDB(state.MakeOffTheChainStateDB(inputAlloc), state.MakeInMemoryStateDB(&shadowAlloc, block))
+	} else if config.use_in_memory_db {
 		statedb = state.MakeInMemoryStateDB(&inputAlloc, block)
 	} else {
 		statedb = state.MakeOffTheChainStateDB(inputAlloc)
 	}
+	addStateDbDuration(time.Since(statedb_start))
+	if config.storage_writes != nil {
+		statedb = &storageWriteRecorder{StateDB: statedb, writes: config.storage_writes}
+	}
 
 	// Apply Message
 	var (
@@ -177,7 +377,11 @@ func replayTask(config ReplayConfig, block uint64, tx int, recording *substate.S
 
 	vmConfig.Tracer = nil
 	vmConfig.Debug = false
-	vmConfig.InterpreterImpl = config.vm_impl
+	if tracer != nil {
+		vmConfig.Tracer = tracer
+		vmConfig.Debug = true
+	}
+	vmConfig.InterpreterImpl = vm_impl
 	statedb.Prepare(txHash, txIndex)
 
 	txCtx := evmcore.NewEVMTxContext(msg)
@@ -187,15 +391,34 @@ func replayTask(config ReplayConfig, block uint64, tx int, recording *substate.S
 	snapshot := statedb.Snapshot()
 	start := time.Now()
 	msgResult, err := evmcore.ApplyMessage(evm, msg, gaspool)
-	addVmDuration(time.Since(start))
+	elapsed := time.Since(start)
+	addVmDuration(elapsed)
+	addInterpreterVmDuration(vm_impl, elapsed)
+	if config.stats != nil {
+		config.stats.addVmDuration(vm_impl, elapsed)
+	}
 
 	if err != nil {
 		statedb.RevertToSnapshot(snapshot)
-		return err
+		return nil, nil, newReplayError(categoryApplyMessage, err)
+	}
+
+	if config.call_profile != nil && msg.To() != nil {
+		if err := config.call_profile.Write(contractRuntimeSchema.Table, block, tx, msg.To().Hex(), elapsed.Nanoseconds(), msgResult.UsedGas); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	if hashError != nil {
-		return hashError
+		return nil, nil, hashError
+	}
+
+	// the refund counter is cleared when the StateDB is finalised
+	if config.gas != nil {
+		*config.gas, err = getGasUsage(msg, msgResult.UsedGas, statedb.GetRefund(), chainConfig.IsLondon(blockCtx.BlockNumber))
+		if err != nil {
+			return nil, nil, newReplayError(categoryGasUsage, err)
+		}
 	}
 
 	if chainConfig.IsByzantium(blockCtx.BlockNumber) {
@@ -219,147 +442,64 @@ func replayTask(config ReplayConfig, block uint64, tx int, recording *substate.S
 
 	evmAlloc := statedb.GetSubstatePostAlloc()
 
-	r := outputResult.Equal(evmResult)
-	a := outputAlloc.Equal(evmAlloc)
-	if !(r && a) {
-		fmt.Printf("block: %v Transaction: %v\n", block, tx)
-		if !r {
-			fmt.Printf("inconsistent output: result\n")
-			PrintResultDiffSummary(outputResult, evmResult)
+	if shadow, ok := statedb.(state.ShadowStateDB); ok {
+		if mismatch := shadow.GetMismatch(); mismatch != nil {
+			fmt.Printf("block: %v Transaction: %v StateDB call sequence up to the first mismatch:\n", block, tx)
+			mismatch.PrintCalls()
+			return nil, nil, mismatch
 		}
-		if !a {
-			fmt.Printf("inconsistent output: alloc\n")
-			PrintAllocationDiffSummary(&outputAlloc, &evmAlloc)
-		}
-		return fmt.Errorf("inconsistent output")
-	}
-
-	return nil
-}
-
-func printIfDifferent[T comparable](label string, want, have T) bool {
-	if want != have {
-		fmt.Printf("  Different %s:\n", label)
-		fmt.Printf("    want: %v\n", want)
-		fmt.Printf("    have: %v\n", have)
-		return true
-	}
-	return false
-}
-
-func printIfDifferentBytes(label string, want, have []byte) bool {
-	if !bytes.Equal(want, have) {
-		fmt.Printf("  Different %s:\n", label)
-		fmt.Printf("    want: %v\n", want)
-		fmt.Printf("    have: %v\n", have)
-		return true
 	}
-	return false
-}
 
-func printIfDifferentBigInt(label string, want, have *big.Int) bool {
-	if want == nil && have == nil {
-		return false
-	}
-	if want == nil || have == nil || want.Cmp(have) != 0 {
-		fmt.Printf("  Different %s:\n", label)
-		fmt.Printf("    want: %v\n", want)
-		fmt.Printf("    have: %v\n", have)
-		return true
-	}
-	return false
+	return evmResult, evmAlloc, nil
 }
 
-func PrintResultDiffSummary(want, have *substate.SubstateResult) {
-	printIfDifferent("status", want.Status, have.Status)
-	printIfDifferent("contract address", want.ContractAddress, have.ContractAddress)
-	printIfDifferent("gas usage", want.GasUsed, have.GasUsed)
-	printIfDifferent("log bloom filter", want.Bloom, have.Bloom)
-	if !printIfDifferent("log size", len(want.Logs), len(have.Logs)) {
-		for i := range want.Logs {
-			printLogDiffSummary(fmt.Sprintf("log[%d]", i), want.Logs[i], have.Logs[i])
-		}
-	}
+// checkpointFailure is a failure restored from a checkpoint. It keeps the
+// category of the original error.
+type checkpointFailure struct {
+	category string
+	message  string
 }
 
-func printLogDiffSummary(label string, want, have *types.Log) {
-	printIfDifferent(fmt.Sprintf("%s.address", label), want.Address, have.Address)
-	if !printIfDifferent(fmt.Sprintf("%s.Topics size", label), len(want.Topics), len(have.Topics)) {
-		for i := range want.Topics {
-			printIfDifferent(fmt.Sprintf("%s.Topics[%d]", label, i), want.Topics[i], have.Topics[i])
-		}
-	}
-	printIfDifferentBytes(fmt.Sprintf("%s.data", label), want.Data, have.Data)
+func (e *checkpointFailure) Error() string {
+	return e.message
 }
 
-func PrintAllocationDiffSummary(want, have *substate.SubstateAlloc) {
-	printIfDifferent("substate alloc size", len(*want), len(*have))
-	for key := range *want {
-		_, present := (*have)[key]
-		if !present {
-			fmt.Printf("    missing key=%v\n", key)
-		}
-	}
-
-	for key := range *have {
-		_, present := (*want)[key]
-		if !present {
-			fmt.Printf("    extra key=%v\n", key)
-		}
-	}
-
-	for key, is := range *have {
-		should, present := (*want)[key]
-		if present {
-			printAccountDiffSummary(fmt.Sprintf("key=%v:", key), should, is)
-		}
-	}
+func (e *checkpointFailure) Category() string {
+	return e.category
 }
 
-func printAccountDiffSummary(label string, want, have *substate.SubstateAccount) {
-	printIfDifferent(fmt.Sprintf("%s.Nonce", label), want.Nonce, have.Nonce)
-	printIfDifferentBigInt(fmt.Sprintf("%s.Balance", label), want.Balance, have.Balance)
-	printIfDifferentBytes(fmt.Sprintf("%s.Code", label), want.Code, have.Code)
-
-	printIfDifferent(fmt.Sprintf("len(%s.Storage)", label), len(want.Storage), len(have.Storage))
-	for key := range want.Storage {
-		_, present := have.Storage[key]
-		if !present {
-			fmt.Printf("    %s.Storage misses key %v\n", label, key)
+// restoreReplayStats adds the statistics of a checkpoint to the statistics of
+// the current run.
+func restoreReplayStats(stats *replayStats, failures *FailureLog) error {
+	addVmDuration(stats.VmDuration)
+	for vm_impl, duration := range stats.InterpreterDurations {
+		addInterpreterVmDuration(vm_impl, duration)
+	}
+	if len(stats.Failures) > 0 && failures == nil {
+		return fmt.Errorf("checkpoint contains failures, resume with --%v", ContinueOnErrorFlag.Name)
+	}
+	for _, failure := range stats.Failures {
+		err := &checkpointFailure{category: failure.Category, message: failure.Err}
+		if err.category == "" {
+			// checkpoints of earlier versions do not hold the category
+			err.category = getFailureCategory(errors.New(failure.Err))
 		}
-	}
-
-	for key := range have.Storage {
-		_, present := want.Storage[key]
-		if !present {
-			fmt.Printf("    %s.Storage has extra key %v\n", label, key)
-		}
-	}
-
-	for key, is := range have.Storage {
-		should, present := want.Storage[key]
-		if present {
-			printIfDifferent(fmt.Sprintf("%s.Storage[%v]", label, key), should, is)
+		addMismatch(err)
+		if err := failures.Register(failure.Block, failure.Tx, err); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-// create new execution context for a data collector
-func NewMicroProfilingCollectorContext() *MicroProfilingCollectorContext {
-	dcc := new(MicroProfilingCollectorContext)
-	dcc.ctx, dcc.cancel = context.WithCancel(context.Background())
-	dcc.ch = make(chan struct{})
-	dcc.stats = vm.NewMicroProfileStatistic()
-	return dcc
+// PrintResultDiffSummary prints the differences between two results.
+func PrintResultDiffSummary(want, have *substate.SubstateResult) {
+	printDiffs(DiffResult(want, have))
 }
 
-// create new execution context for a data collector
-func NewBasicBlockProfilingCollectorContext() *BasicBlockProfilingCollectorContext {
-	dcc := new(BasicBlockProfilingCollectorContext)
-	dcc.ctx, dcc.cancel = context.WithCancel(context.Background())
-	dcc.ch = make(chan struct{})
-	dcc.stats = vm.NewBasicBlockProfileStatistic()
-	return dcc
+// PrintAllocationDiffSummary prints the differences between two substate allocations.
+func PrintAllocationDiffSummary(want, have *substate.SubstateAlloc) {
+	printDiffs(DiffAlloc(*want, *have))
 }
 
 // record-replay: func replayAction for replay command
@@ -370,59 +510,37 @@ func replayAction(ctx *cli.Context) error {
 		return fmt.Errorf("substate-cli replay command requires exactly 2 arguments")
 	}
 
-	// spawn contexts for data collector workers
+	// spawn data collectors, one per worker
+	collectors := ctx.Int(substate.WorkersFlag.Name)
 	if ctx.Bool(MicroProfilingFlag.Name) {
-		var dcc [5]*MicroProfilingCollectorContext
-		for i := 0; i < 5; i++ {
-			dcc[i] = NewMicroProfilingCollectorContext()
-			go vm.MicroProfilingCollector(dcc[i].ctx, dcc[i].ch, dcc[i].stats)
-		}
-
+		pool := NewProfilingCollectorPool(collectors, vm.MicroProfilingCollector, vm.NewMicroProfileStatistic, (*vm.MicroProfileStatistic).Merge)
+		fmt.Printf("substate-cli replay: micro profiling with %v collectors\n", pool.NumCollectors())
 		defer func() {
-			// cancel collectors
-			for i := 0; i < 5; i++ {
-				(dcc[i].cancel)() // stop data collector
-				<-(dcc[i].ch)     // wait for data collector to finish
-			}
-
-			// merge all stats from collectors
-			var stats = vm.NewMicroProfileStatistic()
-			for i := 0; i < 5; i++ {
-				stats.Merge(dcc[i].stats)
-			}
+			stats := pool.Stop()
 			version := fmt.Sprintf("git-date:%v, git-commit:%v, chaind-id:%v", gitDate, gitCommit, chainID)
 			stats.Dump(version)
 			fmt.Printf("substate-cli replay: recorded micro profiling statistics in %v\n", vm.MicroProfilingDB)
 		}()
-
 	}
 
 	if ctx.Bool(BasicBlockProfilingFlag.Name) {
-		var dcc [5]*BasicBlockProfilingCollectorContext
-		for i := 0; i < 5; i++ {
-			dcc[i] = NewBasicBlockProfilingCollectorContext()
-			go vm.BasicBlockProfilingCollector(dcc[i].ctx, dcc[i].ch, dcc[i].stats)
-		}
-
+		pool := NewProfilingCollectorPool(collectors, vm.BasicBlockProfilingCollector, vm.NewBasicBlockProfileStatistic, (*vm.BasicBlockProfileStatistic).Merge)
+		fmt.Printf("substate-cli replay: basic block profiling with %v collectors\n", pool.NumCollectors())
 		defer func() {
-			// cancel collectors
-			for i := 0; i < 5; i++ {
-				(dcc[i].cancel)() // stop data collector
-				<-(dcc[i].ch)     // wait for data collector to finish
-			}
-
-			// merge all stats from collectors
-			var stats = vm.NewBasicBlockProfileStatistic()
-			for i := 0; i < 5; i++ {
-				stats.Merge(dcc[i].stats)
-			}
+			stats := pool.Stop()
 			stats.Dump()
 			fmt.Printf("substate-cli replay: recorded basic block profiling statistics in %v\n", vm.BasicBlockProfilingDB)
 		}()
 	}
 
 	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
 	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("chain-profile: %v\n", chainProfile.Name)
 	fmt.Printf("git-date: %v\n", gitDate)
 	fmt.Printf("git-commit: %v\n", gitCommit)
 
@@ -431,10 +549,6 @@ func replayAction(ctx *cli.Context) error {
 		return argErr
 	}
 
-	if ctx.Bool(ProfileEVMCallFlag.Name) {
-		evmcore.ProfileEVMCall = true
-	}
-
 	if ctx.Bool(MicroProfilingFlag.Name) {
 		vm.MicroProfiling = true
 		vm.MicroProfilingBufferSize = ctx.Int(ChannelBufferSizeFlag.Name)
@@ -444,7 +558,7 @@ func replayAction(ctx *cli.Context) error {
 	if ctx.Bool(BasicBlockProfilingFlag.Name) {
 		vm.BasicBlockProfiling = true
 		vm.BasicBlockProfilingBufferSize = ctx.Int(ChannelBufferSizeFlag.Name)
-		vm.BasicBlockProfilingDB = ctx.String(DatabaseNameFlag.Name)
+		vm.BasicBlockProfilingDB = ctx.String(BasicBlockDatabaseNameFlag.Name)
 	}
 
 	substate.SetSubstateFlags(ctx)
@@ -466,20 +580,150 @@ func replayAction(ctx *cli.Context) error {
 		vm_impl:          ctx.String(InterpreterImplFlag.Name),
 		only_successful:  ctx.Bool(OnlySuccessfulFlag.Name),
 		use_in_memory_db: ctx.Bool(UseInMemoryStateDbFlag.Name),
+		cross_check_db:   ctx.Bool(CrossCheckStateDbFlag.Name),
+		chain_profile:    chainProfile,
+		chain_config:     chainProfile.ChainConfig(),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		config.vm_impl = chainProfile.VM.Interpreter
+	}
+
+	config.trace, err = NewTraceConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %v", err)
+	}
+	if config.trace != nil {
+		fmt.Printf("substate-cli replay: writing %v traces to %v\n", config.trace.tracer, config.trace.dir)
+	}
+
+	if impls := ctx.String(CompareInterpreterFlag.Name); impls != "" {
+		config.compare_impls, err = parseInterpreterPair(impls)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("substate-cli replay: comparing interpreters %v and %v\n", config.compare_impls[0], config.compare_impls[1])
+	}
+
+	if ctx.Bool(ProfileEVMCallFlag.Name) {
+		if len(config.compare_impls) > 0 {
+			return fmt.Errorf("substate-cli replay: --%v cannot be combined with --%v", ProfileEVMCallFlag.Name, CompareInterpreterFlag.Name)
+		}
+		config.call_profile, err = openMetricsSink(ctx, "replayed-runtime", contractRuntimeSchema)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A resumed run continues from the checkpoint of the interrupted run.
+	checkpoint_file_name := ctx.String(CheckpointFlag.Name)
+	var checkpoint *Checkpoint[replayStats]
+	if ctx.Bool(ResumeFlag.Name) {
+		if checkpoint_file_name == "" {
+			return fmt.Errorf("substate-cli replay: --%v requires --%v", ResumeFlag.Name, CheckpointFlag.Name)
+		}
+		checkpoint, err = LoadCheckpoint[replayStats](checkpoint_file_name, "replay", first, last)
+		if err != nil {
+			return fmt.Errorf("substate-cli replay: %v", err)
+		}
+	}
+
+	if report_file_name := ctx.String(ReportFileFlag.Name); report_file_name != "" {
+		if checkpoint != nil {
+			config.report, err = ResumeDiffReport(report_file_name, checkpoint.Next())
+		} else {
+			config.report, err = OpenDiffReport(report_file_name)
+		}
+		if err != nil {
+			return err
+		}
+		defer config.report.Close()
+		fmt.Printf("substate-cli replay: writing mismatch report to %v\n", report_file_name)
+	}
+
+	// In continue-on-error mode, failures are collected instead of aborting the run.
+	var failures *FailureLog
+	if ctx.Bool(ContinueOnErrorFlag.Name) {
+		failures = NewFailureLog(ctx.Int(MaxFailuresFlag.Name))
 	}
 
 	task := func(block uint64, tx int, recording *substate.Substate, taskPool *substate.SubstateTaskPool) error {
-		return replayTask(config, block, tx, recording, taskPool)
+		err := replayTask(config, block, tx, recording, taskPool)
+		if err != nil {
+			addMismatch(err)
+		}
+		if err != nil && failures != nil {
+			return failures.Register(block, tx, err)
+		}
+		return err
 	}
 
 	resetVmDuration()
-	taskPool := substate.NewSubstateTaskPool("substate-cli replay", task, first, last, ctx)
-	err = taskPool.Execute()
+	resetStateDbDuration()
+	resetInterpreterVmDurations()
+	resetMismatches()
+
+	metrics, err := progress.StartMetricsServer(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay: %v", err)
+	}
+	defer metrics.Close()
+	metrics.Counter("substate_cli_replay_vm_seconds_total", "Net VM time of the replayed transactions.", func() float64 {
+		return getVmDuration().Seconds()
+	})
+	metrics.Counter("substate_cli_replay_statedb_seconds_total", "Time spent constructing StateDBs of the replayed transactions.", func() float64 {
+		return getStateDbDuration().Seconds()
+	})
+	metrics.CounterVec("substate_cli_replay_mismatches_total", "Number of failed transactions by category.", "category", getMismatches)
+
+	if checkpoint_file_name == "" {
+		taskPool := substate.NewSubstateTaskPool("substate-cli replay", task, first, last, ctx)
+		err = progress.ExecuteTaskPool(taskPool)
+	} else {
+		if checkpoint == nil {
+			checkpoint = &Checkpoint[replayStats]{Command: "replay", First: first, Last: last}
+		} else {
+			if err := restoreReplayStats(&checkpoint.Stats, failures); err != nil {
+				return fmt.Errorf("substate-cli replay: %v", err)
+			}
+			fmt.Printf("substate-cli replay: resuming from block %v\n", checkpoint.Next())
+		}
+		checkpointer := NewBlockCheckpointer(checkpoint_file_name, checkpoint, mergeReplayStats)
+		taskPool := substate.NewSubstateTaskPool("substate-cli replay", nil, checkpoint.Next(), last, ctx)
+		err = executeWithCheckpoints(taskPool, checkpointer, func(block uint64, tx int, recording *substate.Substate, stats *replayStats) error {
+			blockConfig := config
+			blockConfig.stats = stats
+			err := replayTask(blockConfig, block, tx, recording, taskPool)
+			if err != nil {
+				addMismatch(err)
+			}
+			if err != nil && failures != nil {
+				stats.Failures = append(stats.Failures, replayStatsFailure{Block: block, Tx: tx, Category: getFailureCategory(err), Err: err.Error()})
+				return failures.Register(block, tx, err)
+			}
+			return err
+		})
+	}
+
+	if config.call_profile != nil {
+		if closeErr := config.call_profile.Close(); err == nil {
+			err = closeErr
+		}
+	}
 
 	fmt.Printf("substate-cli replay: net VM time: %v\n", getVmDuration())
+	for _, vm_impl := range config.compare_impls {
+		fmt.Printf("substate-cli replay: net VM time of %v: %v\n", vm_impl, getInterpreterVmDuration(vm_impl))
+	}
 	if strings.HasSuffix(ctx.String(InterpreterImplFlag.Name), "-stats") {
 		lfvm.PrintCollectedInstructionStatistics()
 	}
 
+	if failures != nil {
+		failures.PrintSummary("substate-cli replay")
+		if n := failures.NumFailures(); err == nil && n > 0 {
+			err = fmt.Errorf("substate-cli replay: %v transactions failed", n)
+		}
+	}
+
 	return err
 }
@@ -9,6 +9,10 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/Fantom-foundation/substate-cli/state"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -17,7 +21,6 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/substate"
-	"github.com/ethereum/go-ethereum/tests"
 	"github.com/urfave/cli/v2"
 )
 
@@ -29,10 +32,21 @@ var ReplayForkCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SkipTransferTxsFlag,
 		&substate.SkipCallTxsFlag,
 		&substate.SkipCreateTxsFlag,
 		&HardForkFlag,
+		&ChainIDFlag,
+		&TraceDirFlag,
+		&TracerFlag,
+		&TraceTxFlag,
+		&TraceAddressFlag,
+		&TraceOnlyMismatchingFlag,
+		&GasScheduleFlag,
+		&OutputFlag,
 		&substate.SubstateDirFlag,
 	},
 	Description: `
@@ -42,54 +56,64 @@ The replay-fork command requires two arguments:
 <blockNumFirst> and <blockNumLast> are the first and
 last block of the inclusive range of blocks to replay transactions.
 
---hard-fork parameter is recommended for this command.`,
+--hard-fork selects the Opera rule set (pre-berlin, berlin, london, llr)
+under which the recorded transactions are re-executed. The number of
+transactions per outcome is printed at the end of the run.
+
+--trace and its selection options work as for the replay command; with
+--trace-only-mismatching, only traces of transactions whose outcome changed
+under the selected rule set are kept.
+
+--gas-schedule replays the transactions with the constant gas of opcodes
+replaced by the costs of a JSON file, e.g. {"JUMPI": 8, "ADDMOD": 5}. Opcodes
+with a dynamic gas cost, e.g. SLOAD or SSTORE, cannot be repriced.
+The outcome, status and gas used of every transaction are written to the
+replay_fork_gas table, printed as metric: lines or written to the file given
+by --output.`,
 }
 
-var HardForkName = map[int64]string{
-	1:          "Frontier",
-	1_150_000:  "Homestead",
-	2_463_000:  "Tangerine Whistle",
-	2_675_000:  "Spurious Dragon",
-	4_370_000:  "Byzantium",
-	7_280_000:  "Constantinople + Petersburg",
-	9_069_000:  "Istanbul",
-	12_244_000: "Berlin",
-	12_965_000: "London",
+// OperaUpgradeSet is a named combination of Opera network upgrades whose
+// chain rules are used to re-execute recorded transactions.
+type OperaUpgradeSet struct {
+	Name     string
+	Upgrades opera.Upgrades
 }
 
-func hardForkFlagDefault() int64 {
-	var v int64 = 0
-	for num64 := range HardForkName {
-		if num64 > v {
-			v = num64
+// OperaUpgradeSets lists the supported rule sets, ordered from the oldest to
+// the most recent network upgrade.
+var OperaUpgradeSets = []OperaUpgradeSet{
+	{Name: "pre-berlin", Upgrades: opera.Upgrades{}},
+	{Name: "berlin", Upgrades: opera.Upgrades{Berlin: true}},
+	{Name: "london", Upgrades: opera.Upgrades{Berlin: true, London: true}},
+	{Name: "llr", Upgrades: opera.Upgrades{Berlin: true, London: true, Llr: true}},
+}
+
+// getOperaUpgradeSet returns the rule set with the given name.
+func getOperaUpgradeSet(name string) (OperaUpgradeSet, bool) {
+	for _, set := range OperaUpgradeSets {
+		if set.Name == strings.ToLower(name) {
+			return set, true
 		}
 	}
-	if v <= 0 {
-		panic(fmt.Errorf("substate-cli replay-fork: corrupted --hard-fork default value: %v", v))
-	}
-	return v
+	return OperaUpgradeSet{}, false
 }
 
-var HardForkFlag = cli.Int64Flag{
+var HardForkFlag = cli.StringFlag{
 	Name: "hard-fork",
 	Usage: func() string {
-		s := ""
-		s += "Hard-fork block number, won't change block number in Env for NUMBER instruction"
-
-		hardForkNums := make([]int64, 0, len(HardForkName))
-		for num64 := range HardForkName {
-			hardForkNums = append(hardForkNums, num64)
-		}
-		sort.Slice(hardForkNums, func(i, j int) bool { return hardForkNums[i] < hardForkNums[j] })
-		for _, num64 := range hardForkNums {
-			s += fmt.Sprintf("\n\t  %v: %s", num64, HardForkName[num64])
+		s := "Opera rule set used for replaying, won't change block number in Env for NUMBER instruction"
+		for _, set := range OperaUpgradeSets {
+			s += fmt.Sprintf("\n\t  %s: Berlin=%v, London=%v, LLR=%v", set.Name, set.Upgrades.Berlin, set.Upgrades.London, set.Upgrades.Llr)
 		}
 		return s
 	}(),
-	Value: hardForkFlagDefault(),
+	Value: OperaUpgradeSets[len(OperaUpgradeSets)-1].Name,
 }
 
 var ReplayForkChainConfig *params.ChainConfig = &params.ChainConfig{}
+var ReplayForkTraceConfig *TraceConfig
+var ReplayForkGasSchedule GasSchedule
+var ReplayForkGasSink metrics.Sink
 
 type ReplayForkStat struct {
 	Count  int64
@@ -98,6 +122,7 @@ type ReplayForkStat struct {
 
 var ReplayForkStatChan chan *ReplayForkStat = make(chan *ReplayForkStat, 1_000_000)
 var ReplayForkStatMap map[string]*ReplayForkStat = make(map[string]*ReplayForkStat)
+var ReplayForkStatMutex sync.Mutex
 
 var (
 	ErrReplayForkOutOfGas     = errors.New("out of gas in replay-fork")
@@ -107,11 +132,26 @@ var (
 	ErrReplayForkMisc         = errors.New("misc in replay-fork")
 )
 
-func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+// replayForkUnchanged labels transactions whose outcome is not affected by
+// the selected rule set.
+const replayForkUnchanged = "unchanged in replay-fork"
+
+func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool *substate.SubstateTaskPool) (err error) {
 	var stat *ReplayForkStat
+	var repricer *repricingTracer
+	var evmResult *substate.SubstateResult
+	tracer := ReplayForkTraceConfig.NewTracer(block, tx, recording)
 	defer func() {
 		if stat != nil {
 			ReplayForkStatChan <- stat
+			if repricer != nil {
+				if sinkErr := writeRepricing(ReplayForkGasSink, block, tx, stat.ErrStr, recording.Result, evmResult, repricer.approximate); err == nil {
+					err = sinkErr
+				}
+			}
+			if traceErr := ReplayForkTraceConfig.Finish(tracer, block, tx, "", stat.ErrStr != replayForkUnchanged); err == nil {
+				err = traceErr
+			}
 		}
 	}()
 	inputAlloc := recording.InputAlloc
@@ -121,16 +161,8 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 	outputAlloc := recording.OutputAlloc
 	outputResult := recording.Result
 
-	var (
-		vmConfig    vm.Config
-		getTracerFn func(txIndex int, txHash common.Hash) (tracer vm.Tracer, err error)
-	)
-
-	vmConfig = vm.Config{}
-
-	getTracerFn = func(txIndex int, txHash common.Hash) (tracer vm.Tracer, err error) {
-		return nil, nil
-	}
+	vmConfig := opera.DefaultVMConfig
+	vmConfig.NoBaseFee = true
 
 	// getHash returns zero for block hash that does not exist
 	getHash := func(num uint64) common.Hash {
@@ -144,7 +176,7 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 	// Apply Message
 	var (
 		statedb   = state.MakeOffTheChainStateDB(inputAlloc)
-		gaspool   = new(core.GasPool)
+		gaspool   = new(evmcore.GasPool)
 		txHash    = common.Hash{0x01}
 		blockHash = common.Hash{0x02}
 		txIndex   = tx
@@ -168,18 +200,18 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 
 	msg := inputMessage.AsMessage()
 
-	tracer, err := getTracerFn(txIndex, txHash)
-	if err != nil {
-		return err
+	if tracer != nil {
+		vmConfig.Tracer = tracer
+		vmConfig.Debug = true
+	}
+	if ReplayForkGasSchedule != nil {
+		repricer = newRepricingTracer(ReplayForkGasSchedule, vmConfig.Tracer)
+		vmConfig.Tracer = repricer
+		vmConfig.Debug = true
 	}
-	vmConfig.Tracer = tracer
-	vmConfig.Debug = (tracer != nil)
 	statedb.Prepare(txHash, txIndex)
 
-	txCtx := vm.TxContext{
-		GasPrice: msg.GasPrice(),
-		Origin:   msg.From(),
-	}
+	txCtx := evmcore.NewEVMTxContext(msg)
 
 	chainConfig := ReplayForkChainConfig
 	if chainConfig.IsLondon(blockCtx.BlockNumber) && blockCtx.BaseFee == nil {
@@ -187,8 +219,13 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 		blockCtx.BaseFee = new(big.Int)
 	}
 	evm := vm.NewEVM(blockCtx, txCtx, statedb, chainConfig, vmConfig)
+	if repricer != nil {
+		if err := repricer.init(evm); err != nil {
+			return err
+		}
+	}
 	snapshot := statedb.Snapshot()
-	msgResult, err := core.ApplyMessage(evm, msg, gaspool)
+	msgResult, err := evmcore.ApplyMessage(evm, msg, gaspool)
 
 	if err != nil {
 		statedb.RevertToSnapshot(snapshot)
@@ -205,7 +242,7 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 		statedb.IntermediateRoot(chainConfig.IsEIP158(blockCtx.BlockNumber))
 	}
 
-	evmResult := &substate.SubstateResult{}
+	evmResult = &substate.SubstateResult{}
 	if msgResult.Failed() {
 		evmResult.Status = types.ReceiptStatusFailed
 	} else {
@@ -218,7 +255,7 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 	}
 	evmResult.GasUsed = msgResult.UsedGas
 
-	evmAlloc := statedb.SubstatePostAlloc
+	evmAlloc := statedb.GetSubstatePostAlloc()
 
 	if r, a := outputResult.Equal(evmResult), outputAlloc.Equal(evmAlloc); !(r && a) {
 		if outputResult.Status == types.ReceiptStatusSuccessful &&
@@ -326,6 +363,10 @@ func replayForkTask(block uint64, tx int, recording *substate.Substate, taskPool
 		}
 	}
 
+	stat = &ReplayForkStat{
+		Count:  1,
+		ErrStr: replayForkUnchanged,
+	}
 	return nil
 }
 
@@ -342,37 +383,56 @@ func replayForkAction(ctx *cli.Context) error {
 		return argErr
 	}
 
-	hardFork := ctx.Int64(HardForkFlag.Name)
-	if hardForkName, exist := HardForkName[hardFork]; !exist {
-		return fmt.Errorf("substate-cli replay-fork: invalid hard-fork block number %v", hardFork)
-	} else {
-		fmt.Printf("substate-cli replay-fork: hard-fork: block %v (%s)\n", hardFork, hardForkName)
+	chainID = ctx.Int(ChainIDFlag.Name)
+	fmt.Printf("chain-id: %v\n", chainID)
+
+	upgradeSet, exist := getOperaUpgradeSet(ctx.String(HardForkFlag.Name))
+	if !exist {
+		return fmt.Errorf("substate-cli replay-fork: invalid hard-fork %v", ctx.String(HardForkFlag.Name))
+	}
+	fmt.Printf("substate-cli replay-fork: hard-fork: %s\n", upgradeSet.Name)
+	rules := opera.Rules{
+		NetworkID: uint64(chainID),
+		Upgrades:  upgradeSet.Upgrades,
 	}
-	switch hardFork {
-	case 1:
-		*ReplayForkChainConfig = *tests.Forks["Frontier"]
-	case 1_150_000:
-		*ReplayForkChainConfig = *tests.Forks["Homestead"]
-	case 2_463_000:
-		*ReplayForkChainConfig = *tests.Forks["EIP150"] // Tangerine Whistle
-	case 2_675_000:
-		*ReplayForkChainConfig = *tests.Forks["EIP158"] // Spurious Dragon
-	case 4_370_000:
-		*ReplayForkChainConfig = *tests.Forks["Byzantium"]
-	case 7_280_000:
-		*ReplayForkChainConfig = *tests.Forks["ConstantinopleFix"]
-	case 9_069_000:
-		*ReplayForkChainConfig = *tests.Forks["Istanbul"]
-	case 12_244_000:
-		*ReplayForkChainConfig = *tests.Forks["Berlin"]
-	case 12_965_000:
-		*ReplayForkChainConfig = *tests.Forks["London"]
+	ReplayForkChainConfig = rules.EvmChainConfig()
+
+	ReplayForkTraceConfig, err = NewTraceConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay-fork: %v", err)
+	}
+
+	if filename := ctx.String(GasScheduleFlag.Name); filename != "" {
+		ReplayForkGasSchedule, err = LoadGasSchedule(filename)
+		if err != nil {
+			return fmt.Errorf("substate-cli replay-fork: %v", err)
+		}
+		fmt.Printf("substate-cli replay-fork: gas schedule: %v\n", ReplayForkGasSchedule)
+		ReplayForkGasSink, err = openMetricsSink(ctx, "replay-fork", repricingSchema)
+		if err != nil {
+			return err
+		}
 	}
 
 	substate.SetSubstateFlags(ctx)
 	substate.OpenSubstateDBReadOnly()
 	defer substate.CloseSubstateDB()
 
+	metrics, err := progress.StartMetricsServer(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli replay-fork: %v", err)
+	}
+	defer metrics.Close()
+	metrics.CounterVec("substate_cli_replay_fork_results_total", "Number of replayed transactions by outcome.", "category", func() map[string]float64 {
+		ReplayForkStatMutex.Lock()
+		defer ReplayForkStatMutex.Unlock()
+		results := make(map[string]float64, len(ReplayForkStatMap))
+		for errstr, stat := range ReplayForkStatMap {
+			results[errstr] = float64(stat.Count)
+		}
+		return results
+	})
+
 	statWg := &sync.WaitGroup{}
 	statWg.Add(1)
 	go func() {
@@ -380,6 +440,7 @@ func replayForkAction(ctx *cli.Context) error {
 			count := stat.Count
 			errstr := stat.ErrStr
 
+			ReplayForkStatMutex.Lock()
 			if ReplayForkStatMap[errstr] == nil {
 				ReplayForkStatMap[errstr] = &ReplayForkStat{
 					Count:  0,
@@ -388,26 +449,35 @@ func replayForkAction(ctx *cli.Context) error {
 			}
 
 			ReplayForkStatMap[errstr].Count += count
+			ReplayForkStatMutex.Unlock()
 		}
 		statWg.Done()
 	}()
 
 	taskPool := substate.NewSubstateTaskPool("substate-cli replay-fork", replayForkTask, first, last, ctx)
-	err = taskPool.Execute()
-	if err == nil {
-		close(ReplayForkStatChan)
-	}
+	err = progress.ExecuteTaskPool(taskPool)
+	close(ReplayForkStatChan)
 
 	statWg.Wait()
 	errstrSlice := make([]string, 0, len(ReplayForkStatMap))
 	for errstr := range ReplayForkStatMap {
 		errstrSlice = append(errstrSlice, errstr)
 	}
+	sort.Slice(errstrSlice, func(i, j int) bool {
+		return ReplayForkStatMap[errstrSlice[i]].Count > ReplayForkStatMap[errstrSlice[j]].Count
+	})
 	for _, errstr := range errstrSlice {
 		stat := ReplayForkStatMap[errstr]
 		count := stat.Count
 		fmt.Printf("substate-cli replay-fork: %12v %s\n", count, errstr)
 	}
 
+	if ReplayForkGasSink != nil {
+		printRepricingSummary()
+		if closeErr := ReplayForkGasSink.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
 	return err
 }
@@ -0,0 +1,434 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/urfave/cli/v2"
+)
+
+// substate-cli report command
+var ReportCommand = cli.Command{
+	Action:    reportAction,
+	Name:      "report",
+	Usage:     "ranks smart contracts by runtime, gas, storage growth and code size from collected metrics",
+	ArgsUsage: "<metricsFile> [<metricsFile>...]",
+	Flags: []cli.Flag{
+		&TopFlag,
+		&RankingsFlag,
+		&FormatFlag,
+		&ReportOutputFlag,
+	},
+	Description: `
+The substate-cli report command requires at least one argument:
+<metricsFile> [<metricsFile>...]
+
+<metricsFile> is a CSV, JSON-lines or SQLite file written by the --output
+option of an analysis command. A "csv:", "jsonl:" or "sqlite:" prefix selects
+the format, else the file extension does.
+
+The report lists the --top contracts of each ranking selected by --rankings,
+read from the following tables of the metrics files:
+  runtime    contract_runtime table of replay --profiling-call
+  gas        contract_runtime table of replay --profiling-call
+  storage    storage_update table of the storage-size command
+  code-size  substate_code_size table of the code-size command
+Rows of the same table in several files are added up. Rankings of the default
+--rankings whose table is missing are skipped.
+The report is printed as text, JSON or Markdown tables selected by --format,
+either to the console or to the file given by --output.`,
+}
+
+// names of the supported rankings
+const (
+	runtimeRanking  = "runtime"
+	gasRanking      = "gas"
+	storageRanking  = "storage"
+	codeSizeRanking = "code-size"
+)
+
+// contractStats are the metrics of a single contract collected by a report.
+type contractStats struct {
+	invocations  uint64
+	runtime      float64
+	transactions uint64
+	gas          uint64
+	storageDelta int64
+	codeSize     uint64
+	nonce        uint64
+}
+
+// reportCollector accumulates the metrics of all contracts.
+type reportCollector struct {
+	contracts map[string]*contractStats
+}
+
+func (c *reportCollector) get(contract string) *contractStats {
+	stats, found := c.contracts[contract]
+	if !found {
+		stats = &contractStats{}
+		c.contracts[contract] = stats
+	}
+	return stats
+}
+
+// rankingSource is the metrics table a ranking is computed from.
+type rankingSource struct {
+	table   string
+	columns []string // the contract followed by the metrics of a row
+	add     func(stats *contractStats, values []string) error
+}
+
+var rankingSources = map[string]rankingSource{
+	runtimeRanking: {
+		table:   contractRuntimeSchema.Table,
+		columns: []string{"contract", "runtime"},
+		add: func(stats *contractStats, values []string) error {
+			runtime, err := strconv.ParseFloat(values[0], 64)
+			if err != nil {
+				return fmt.Errorf("invalid runtime %q", values[0])
+			}
+			stats.invocations++
+			stats.runtime += runtime
+			return nil
+		},
+	},
+	gasRanking: {
+		table:   contractRuntimeSchema.Table,
+		columns: []string{"contract", "gas_used"},
+		add: func(stats *contractStats, values []string) error {
+			gas, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid gas %q", values[0])
+			}
+			stats.transactions++
+			stats.gas += gas
+			return nil
+		},
+	},
+	storageRanking: {
+		table:   storageUpdateSchema.Table,
+		columns: []string{"contract", "storage_update_bytes"},
+		add: func(stats *contractStats, values []string) error {
+			delta, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid storage update %q", values[0])
+			}
+			stats.storageDelta += delta
+			return nil
+		},
+	},
+	codeSizeRanking: {
+		table:   codeSizeSchema.Table,
+		columns: []string{"contract", "code_size_bytes", "nonce"},
+		add: func(stats *contractStats, values []string) error {
+			codeSize, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid code size %q", values[0])
+			}
+			nonce, err := strconv.ParseUint(values[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid nonce %q", values[1])
+			}
+			if codeSize > stats.codeSize {
+				stats.codeSize = codeSize
+			}
+			if nonce > stats.nonce {
+				stats.nonce = nonce
+			}
+			return nil
+		},
+	},
+}
+
+// collect reads the table of a ranking from all metrics files and reports
+// whether any of them holds it.
+func (c *reportCollector) collect(inputs []string, source rankingSource) (bool, error) {
+	found := false
+	for _, input := range inputs {
+		hasTable, err := metrics.ReadTable(input, source.table, source.columns, func(values []string) error {
+			return source.add(c.get(values[0]), values[1:])
+		})
+		if err != nil {
+			return found, err
+		}
+		found = found || hasTable
+	}
+	return found, nil
+}
+
+// Report is the result of the report command.
+type Report struct {
+	Inputs    []string       `json:"inputs"`
+	Contracts int            `json:"contracts"`
+	Rankings  []*ReportTable `json:"rankings"`
+}
+
+// ReportTable is a single ranking of a report.
+type ReportTable struct {
+	Name    string          `json:"name"`
+	Title   string          `json:"title"`
+	Table   string          `json:"table"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type rankedContract struct {
+	contract string
+	stats    *contractStats
+}
+
+// rank returns the top contracts sorted by the given key in descending order.
+// Contracts with a non-positive key are omitted.
+func (c *reportCollector) rank(top int, key func(*contractStats) float64) []rankedContract {
+	list := []rankedContract{}
+	for contract, stats := range c.contracts {
+		if key(stats) > 0 {
+			list = append(list, rankedContract{contract, stats})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ki, kj := key(list[i].stats), key(list[j].stats)
+		if ki != kj {
+			return ki > kj
+		}
+		return list[i].contract < list[j].contract
+	})
+	if len(list) > top {
+		list = list[:top]
+	}
+	return list
+}
+
+func (c *reportCollector) newTable(name string, top int) *ReportTable {
+	switch name {
+	case runtimeRanking:
+		table := &ReportTable{
+			Name:    name,
+			Title:   "Smart contracts with the highest total runtime",
+			Table:   rankingSources[name].table,
+			Columns: []string{"contract", "invocations", "total_runtime", "runtime_per_invocation"},
+			Rows:    [][]interface{}{},
+		}
+		for _, entry := range c.rank(top, func(s *contractStats) float64 { return s.runtime }) {
+			table.Rows = append(table.Rows, []interface{}{
+				entry.contract,
+				entry.stats.invocations,
+				entry.stats.runtime,
+				entry.stats.runtime / float64(entry.stats.invocations),
+			})
+		}
+		return table
+	case gasRanking:
+		table := &ReportTable{
+			Name:    name,
+			Title:   "Smart contracts with the highest total gas",
+			Table:   rankingSources[name].table,
+			Columns: []string{"contract", "transactions", "gas", "gas_per_transaction"},
+			Rows:    [][]interface{}{},
+		}
+		for _, entry := range c.rank(top, func(s *contractStats) float64 { return float64(s.gas) }) {
+			perTransaction := uint64(0)
+			if entry.stats.transactions > 0 {
+				perTransaction = entry.stats.gas / entry.stats.transactions
+			}
+			table.Rows = append(table.Rows, []interface{}{
+				entry.contract,
+				entry.stats.transactions,
+				entry.stats.gas,
+				perTransaction,
+			})
+		}
+		return table
+	case storageRanking:
+		table := &ReportTable{
+			Name:    name,
+			Title:   "Smart contracts with the largest storage growth",
+			Table:   rankingSources[name].table,
+			Columns: []string{"contract", "storage_delta_bytes"},
+			Rows:    [][]interface{}{},
+		}
+		for _, entry := range c.rank(top, func(s *contractStats) float64 { return float64(s.storageDelta) }) {
+			table.Rows = append(table.Rows, []interface{}{
+				entry.contract,
+				entry.stats.storageDelta,
+			})
+		}
+		return table
+	case codeSizeRanking:
+		table := &ReportTable{
+			Name:    name,
+			Title:   "Smart contracts with the largest code size",
+			Table:   rankingSources[name].table,
+			Columns: []string{"contract", "code_size_bytes", "nonce"},
+			Rows:    [][]interface{}{},
+		}
+		for _, entry := range c.rank(top, func(s *contractStats) float64 { return float64(s.codeSize) }) {
+			table.Rows = append(table.Rows, []interface{}{
+				entry.contract,
+				entry.stats.codeSize,
+				entry.stats.nonce,
+			})
+		}
+		return table
+	}
+	return nil
+}
+
+// formatValue prints a value of a report table.
+func formatValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return fmt.Sprintf("%.3f", f)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// WriteText writes the report as aligned plain text tables.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Metrics:\t%v\n", strings.Join(r.Inputs, ", "))
+	fmt.Fprintf(tw, "Contracts:\t%v\n", r.Contracts)
+	for _, table := range r.Rankings {
+		fmt.Fprintf(tw, "\n%v (%v)\n", table.Title, table.Table)
+		fmt.Fprintf(tw, "%v\n", strings.Join(table.Columns, "\t"))
+		for _, row := range table.Rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				fields[i] = formatValue(value)
+			}
+			fmt.Fprintf(tw, "%v\n", strings.Join(fields, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteMarkdown writes the report as Markdown tables.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	fmt.Fprintf(w, "# Substate Report\n\n")
+	fmt.Fprintf(w, "| metric | value |\n|---|---|\n")
+	fmt.Fprintf(w, "| metrics | %v |\n", strings.Join(r.Inputs, ", "))
+	fmt.Fprintf(w, "| contracts | %v |\n", r.Contracts)
+	for _, table := range r.Rankings {
+		fmt.Fprintf(w, "\n## %v\n\n", table.Title)
+		fmt.Fprintf(w, "Source table: `%v`\n\n", table.Table)
+		fmt.Fprintf(w, "| # | %v |\n", strings.Join(table.Columns, " | "))
+		fmt.Fprintf(w, "|---|%v\n", strings.Repeat("---|", len(table.Columns)))
+		for i, row := range table.Rows {
+			fields := make([]string, len(row))
+			for j, value := range row {
+				fields[j] = formatValue(value)
+			}
+			fmt.Fprintf(w, "| %d | %v |\n", i+1, strings.Join(fields, " | "))
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// parseRankings checks the list of rankings selected by --rankings.
+func parseRankings(list string) ([]string, error) {
+	rankings := []string{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case runtimeRanking, gasRanking, storageRanking, codeSizeRanking:
+			rankings = append(rankings, name)
+		default:
+			return nil, fmt.Errorf("unknown ranking %q, supported rankings are %v, %v, %v and %v", name, runtimeRanking, gasRanking, storageRanking, codeSizeRanking)
+		}
+	}
+	return rankings, nil
+}
+
+// func reportAction for report command
+func reportAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() < 1 {
+		return fmt.Errorf("substate-cli report command requires at least 1 argument")
+	}
+
+	rankings, err := parseRankings(ctx.String(RankingsFlag.Name))
+	if err != nil {
+		return fmt.Errorf("substate-cli report: %v", err)
+	}
+	top := ctx.Int(TopFlag.Name)
+	if top <= 0 {
+		return fmt.Errorf("substate-cli report: --%v must be positive", TopFlag.Name)
+	}
+	format := ctx.String(FormatFlag.Name)
+	if format != "text" && format != "json" && format != "markdown" {
+		return fmt.Errorf("substate-cli report: unknown format %q, supported formats are text, json and markdown", format)
+	}
+
+	inputs := ctx.Args().Slice()
+	collector := &reportCollector{contracts: map[string]*contractStats{}}
+	report := &Report{Inputs: inputs}
+	for _, name := range rankings {
+		source := rankingSources[name]
+		found, err := collector.collect(inputs, source)
+		if err != nil {
+			return fmt.Errorf("substate-cli report: %v", err)
+		}
+		if !found {
+			// rankings requested explicitly must be computed
+			if ctx.IsSet(RankingsFlag.Name) {
+				return fmt.Errorf("substate-cli report: no metrics file holds the %v table of the %v ranking", source.table, name)
+			}
+			fmt.Fprintf(os.Stderr, "substate-cli report: skipping %v ranking, no metrics file holds the %v table\n", name, source.table)
+			continue
+		}
+		report.Rankings = append(report.Rankings, collector.newTable(name, top))
+	}
+	if len(report.Rankings) == 0 {
+		return fmt.Errorf("substate-cli report: the metrics files hold none of the tables of the rankings")
+	}
+	report.Contracts = len(collector.contracts)
+
+	filename := ctx.String(ReportOutputFlag.Name)
+	if filename == "" {
+		if err := writeReport(report, format, os.Stdout); err != nil {
+			return fmt.Errorf("substate-cli report: %v", err)
+		}
+		return nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("substate-cli report: cannot create %v: %v", filename, err)
+	}
+	err = writeReport(report, format, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("substate-cli report: cannot write %v: %v", filename, err)
+	}
+	fmt.Printf("substate-cli report: report written to %v\n", filename)
+	return nil
+}
+
+func writeReport(report *Report, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		return report.WriteJSON(w)
+	case "markdown":
+		return report.WriteMarkdown(w)
+	}
+	return report.WriteText(w)
+}
@@ -0,0 +1,431 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/urfave/cli/v2"
+)
+
+// substate-cli export-statetest command
+var ExportStateTestCommand = cli.Command{
+	Action:    exportStateTestAction,
+	Name:      "export-statetest",
+	Usage:     "converts substates into GeneralStateTests fixtures",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
+		&InterpreterImplFlag,
+		&StateTestDirFlag,
+	},
+	Description: `
+The substate-cli export-statetest command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be exported.
+
+Every transaction is written as a state test in the GeneralStateTests fixture
+format to <statetest-dir>/<block>_<tx>.json. The post section holds the state
+root and the logs hash computed by the state test runner of go-ethereum under
+the fork active at its block, so they follow Ethereum's fee rules rather than
+Opera's. Transactions are skipped if their replay is inconsistent with the
+recording, if the hash of the previous block is not recorded, or if they may
+execute BLOCKHASH, or CHAINID on chains other than the chain of the runner.
+
+The keys of recorded senders are unknown, so every transaction is re-keyed to
+the secretKey commonly used by GeneralStateTests: the sender's account is
+moved to the address of this key in the pre state. Transactions whose status or
+gas used change by the re-keying are skipped.`,
+}
+
+// StateTest is a state test fixture in the GeneralStateTests format.
+type StateTest struct {
+	Info        stateTestInfo                    `json:"_info"`
+	Env         stateTestEnv                     `json:"env"`
+	Pre         map[common.Address]stateTestAcct `json:"pre"`
+	Transaction stateTestTx                      `json:"transaction"`
+	Post        map[string][]stateTestPost       `json:"post"`
+}
+
+type stateTestInfo struct {
+	Comment string `json:"comment"`
+	Source  string `json:"source"`
+	ChainID uint64 `json:"chainId"`
+}
+
+type stateTestEnv struct {
+	Coinbase   common.Address `json:"currentCoinbase"`
+	Difficulty *hexutil.Big   `json:"currentDifficulty"`
+	GasLimit   hexutil.Uint64 `json:"currentGasLimit"`
+	Number     hexutil.Uint64 `json:"currentNumber"`
+	Timestamp  hexutil.Uint64 `json:"currentTimestamp"`
+	BaseFee    *hexutil.Big   `json:"currentBaseFee,omitempty"`
+	PrevHash   common.Hash    `json:"previousHash"`
+}
+
+type stateTestAcct struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Code    hexutil.Bytes               `json:"code"`
+	Nonce   hexutil.Uint64              `json:"nonce"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// stateTestTx is a transaction with a single data, gas and value variant.
+type stateTestTx struct {
+	Data                 []hexutil.Bytes     `json:"data"`
+	GasLimit             []hexutil.Uint64    `json:"gasLimit"`
+	Value                []*hexutil.Big      `json:"value"`
+	Nonce                hexutil.Uint64      `json:"nonce"`
+	To                   string              `json:"to"`
+	SecretKey            hexutil.Bytes       `json:"secretKey"`
+	GasPrice             *hexutil.Big        `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big        `json:"maxPriorityFeePerGas,omitempty"`
+	AccessLists          []*types.AccessList `json:"accessLists,omitempty"`
+}
+
+type stateTestPost struct {
+	Root    common.Hash      `json:"hash"`
+	Logs    common.Hash      `json:"logs"`
+	Indexes stateTestIndexes `json:"indexes"`
+}
+
+type stateTestIndexes struct {
+	Data  int `json:"data"`
+	Gas   int `json:"gas"`
+	Value int `json:"value"`
+}
+
+// stateTestKey is the secret key of the sender of exported transactions, the
+// key of stateTestSender used by most GeneralStateTests.
+var (
+	stateTestKey    = hexutil.MustDecode("0x45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8")
+	stateTestSender = common.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+)
+
+// rekeySubstate returns a copy of a substate sent by stateTestSender. The
+// account of the recorded sender is moved to stateTestSender, also in the
+// access list.
+func rekeySubstate(recording *substate.Substate) (*substate.Substate, error) {
+	from := recording.Message.From
+	if from == stateTestSender {
+		return recording, nil
+	}
+	if _, exists := recording.InputAlloc[stateTestSender]; exists {
+		return nil, fmt.Errorf("the address %v of the sender key is part of the input substate", stateTestSender.Hex())
+	}
+	alloc := copyAlloc(recording.InputAlloc)
+	if account, exists := alloc[from]; exists {
+		alloc[stateTestSender] = account
+		delete(alloc, from)
+	}
+	message := *recording.Message
+	message.From = stateTestSender
+	if message.AccessList != nil {
+		message.AccessList = make(types.AccessList, len(recording.Message.AccessList))
+		for i, tuple := range recording.Message.AccessList {
+			if tuple.Address == from {
+				tuple.Address = stateTestSender
+			}
+			message.AccessList[i] = tuple
+		}
+	}
+	return &substate.Substate{
+		InputAlloc: alloc,
+		Env:        recording.Env,
+		Message:    &message,
+		Result:     recording.Result,
+	}, nil
+}
+
+// stateTestFork returns the name of the fork active at the given block as
+// used by GeneralStateTests.
+func stateTestFork(config *params.ChainConfig, block uint64) (string, error) {
+	number := new(big.Int).SetUint64(block)
+	switch {
+	case config.IsLondon(number):
+		return "London", nil
+	case config.IsBerlin(number):
+		return "Berlin", nil
+	case config.IsIstanbul(number):
+		return "Istanbul", nil
+	case config.IsPetersburg(number):
+		return "ConstantinopleFix", nil
+	case config.IsConstantinople(number):
+		return "Constantinople", nil
+	case config.IsByzantium(number):
+		return "Byzantium", nil
+	case config.IsEIP158(number):
+		return "EIP158", nil
+	case config.IsEIP150(number):
+		return "EIP150", nil
+	case config.IsHomestead(number):
+		return "Homestead", nil
+	}
+	return "", fmt.Errorf("no state test fork for block %v", block)
+}
+
+// logsHash returns the hash of the RLP encoded logs as used in state tests.
+func logsHash(logs []*types.Log) (common.Hash, error) {
+	if logs == nil {
+		logs = []*types.Log{}
+	}
+	data, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+func newStateTestAlloc(alloc substate.SubstateAlloc) map[common.Address]stateTestAcct {
+	res := make(map[common.Address]stateTestAcct, len(alloc))
+	for address, account := range alloc {
+		storage := make(map[common.Hash]common.Hash, len(account.Storage))
+		for key, value := range account.Storage {
+			storage[key] = value
+		}
+		res[address] = stateTestAcct{
+			Balance: (*hexutil.Big)(account.Balance),
+			Code:    account.Code,
+			Nonce:   hexutil.Uint64(account.Nonce),
+			Storage: storage,
+		}
+	}
+	return res
+}
+
+func newStateTestTx(msg *substate.SubstateMessage) stateTestTx {
+	tx := stateTestTx{
+		Data:      []hexutil.Bytes{msg.Data},
+		GasLimit:  []hexutil.Uint64{hexutil.Uint64(msg.Gas)},
+		Value:     []*hexutil.Big{(*hexutil.Big)(msg.Value)},
+		Nonce:     hexutil.Uint64(msg.Nonce),
+		SecretKey: stateTestKey,
+	}
+	if msg.To != nil {
+		tx.To = msg.To.Hex()
+	}
+	if msg.GasFeeCap != nil && msg.GasTipCap != nil && (msg.GasFeeCap.Cmp(msg.GasPrice) != 0 || msg.GasTipCap.Cmp(msg.GasPrice) != 0) {
+		tx.MaxFeePerGas = (*hexutil.Big)(msg.GasFeeCap)
+		tx.MaxPriorityFeePerGas = (*hexutil.Big)(msg.GasTipCap)
+	} else {
+		tx.GasPrice = (*hexutil.Big)(msg.GasPrice)
+	}
+	if len(msg.AccessList) > 0 {
+		accessList := msg.AccessList
+		tx.AccessLists = []*types.AccessList{&accessList}
+	}
+	return tx
+}
+
+// computeStateTestPost computes the post state of the single transaction of a state
+// test under the given fork with the state test runner of go-ethereum, so the
+// post state follows Ethereum's rules for fees and refunds rather than Opera's.
+func computeStateTestPost(test *StateTest, fork string) (stateTestPost, error) {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return stateTestPost{}, err
+	}
+	var runner tests.StateTest
+	if err := runner.UnmarshalJSON(data); err != nil {
+		return stateTestPost{}, err
+	}
+	_, statedb, root, err := runner.RunNoVerify(tests.StateSubtest{Fork: fork, Index: 0}, vm.Config{}, false)
+	if err != nil {
+		return stateTestPost{}, err
+	}
+	// the runner reverts transactions not applicable to the pre state, which
+	// leaves the nonce of the sender unchanged
+	if nonce := uint64(test.Pre[stateTestSender].Nonce); statedb.GetNonce(stateTestSender) != nonce+1 {
+		return stateTestPost{}, fmt.Errorf("transaction is not applicable under %v", fork)
+	}
+	logs, err := logsHash(statedb.Logs())
+	if err != nil {
+		return stateTestPost{}, err
+	}
+	return stateTestPost{Root: root, Logs: logs}, nil
+}
+
+// stateTestUnsupportedOps returns the instructions whose results differ
+// between the recording and the state test runner of go-ethereum under the
+// given fork, which provides no block hashes and uses its own chain ID.
+func stateTestUnsupportedOps(config *params.ChainConfig, fork string) (map[vm.OpCode]bool, error) {
+	forkConfig, _, err := tests.GetChainConfig(fork)
+	if err != nil {
+		return nil, err
+	}
+	ops := map[vm.OpCode]bool{vm.BLOCKHASH: true}
+	if forkConfig.ChainID.Cmp(config.ChainID) != 0 {
+		ops[vm.CHAINID] = true
+	}
+	return ops, nil
+}
+
+// NewStateTest replays a substate and converts it into a state test sent by
+// stateTestSender. It returns an error if the replayed output differs from
+// the recording, if the re-keyed transaction has a different status or gas
+// used, or if the transaction may execute an instruction the state test runner
+// cannot reproduce.
+func NewStateTest(config ReplayConfig, block uint64, tx int, recording *substate.Substate) (*StateTest, error) {
+	fork, err := stateTestFork(config.chain_config, block)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := stateTestUnsupportedOps(config.chain_config, fork)
+	if err != nil {
+		return nil, err
+	}
+	if utils.MayExecuteOpCode(recording, ops) {
+		return nil, fmt.Errorf("transaction may execute BLOCKHASH or CHAINID, which differ in state tests")
+	}
+	env := recording.Env
+	prevHash, exists := env.BlockHashes[env.Number-1]
+	if !exists {
+		return nil, fmt.Errorf("hash of block %v is not recorded", env.Number-1)
+	}
+	evmResult, evmAlloc, err := runSubstate(config, config.vm_impl, block, tx, recording, copyAlloc(recording.InputAlloc), nil)
+	if err != nil {
+		return nil, err
+	}
+	if diff := NewTransactionDiff(block, tx, recording.Message.To, recording.Result, recording.OutputAlloc, evmResult, evmAlloc); diff != nil {
+		return nil, &InconsistentOutputError{Diff: diff}
+	}
+
+	// the fixture is sent with the sender key
+	rekeyed, err := rekeySubstate(recording)
+	if err != nil {
+		return nil, err
+	}
+	evmResult, _, err = runSubstate(config, config.vm_impl, block, tx, rekeyed, copyAlloc(rekeyed.InputAlloc), nil)
+	if err != nil {
+		return nil, fmt.Errorf("re-keyed transaction: %v", err)
+	}
+	if evmResult.Status != recording.Result.Status || evmResult.GasUsed != recording.Result.GasUsed {
+		return nil, fmt.Errorf("re-keyed transaction has status %v and gas used %v instead of %v and %v", evmResult.Status, evmResult.GasUsed, recording.Result.Status, recording.Result.GasUsed)
+	}
+
+	test := &StateTest{
+		Info: stateTestInfo{
+			Comment: fmt.Sprintf("block %v transaction %v", block, tx),
+			Source:  fmt.Sprintf("substate-cli export-statetest, git-commit: %v", gitCommit),
+			ChainID: config.chain_config.ChainID.Uint64(),
+		},
+		Env: stateTestEnv{
+			Coinbase:   env.Coinbase,
+			Difficulty: (*hexutil.Big)(env.Difficulty),
+			GasLimit:   hexutil.Uint64(env.GasLimit),
+			Number:     hexutil.Uint64(env.Number),
+			Timestamp:  hexutil.Uint64(env.Timestamp),
+			PrevHash:   prevHash,
+		},
+		Pre:         newStateTestAlloc(rekeyed.InputAlloc),
+		Transaction: newStateTestTx(rekeyed.Message),
+		Post: map[string][]stateTestPost{
+			fork: {{}},
+		},
+	}
+	if env.BaseFee != nil {
+		test.Env.BaseFee = (*hexutil.Big)(env.BaseFee)
+	}
+	post, err := computeStateTestPost(test, fork)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute post state: %v", err)
+	}
+	test.Post[fork][0] = post
+	return test, nil
+}
+
+// exportStateTestTask writes the state test of a transaction.
+func exportStateTestTask(config ReplayConfig, dir string, skipped *uint64, block uint64, tx int, recording *substate.Substate) error {
+	test, err := NewStateTest(config, block, tx, recording)
+	if err != nil {
+		fmt.Printf("substate-cli export-statetest: skipped block %v tx %v: %v\n", block, tx, err)
+		atomic.AddUint64(skipped, 1)
+		return nil
+	}
+	name := fmt.Sprintf("%d_%d", block, tx)
+	data, err := json.MarshalIndent(map[string]*StateTest{name: test}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode state test of block %v tx %v: %v", block, tx, err)
+	}
+	filename := filepath.Join(dir, name+".json")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("cannot write state test %v: %v", filename, err)
+	}
+	return nil
+}
+
+// func exportStateTestAction for export-statetest command
+func exportStateTestAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli export-statetest command requires exactly 2 arguments")
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli export-statetest: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("chain-profile: %v\n", chainProfile.Name)
+
+	first, last, argErr := SetBlockRange(ctx.Args().Get(0), ctx.Args().Get(1))
+	if argErr != nil {
+		return argErr
+	}
+
+	var config = ReplayConfig{
+		vm_impl:       ctx.String(InterpreterImplFlag.Name),
+		chain_profile: chainProfile,
+		chain_config:  chainProfile.ChainConfig(),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		config.vm_impl = chainProfile.VM.Interpreter
+	}
+
+	dir := ctx.String(StateTestDirFlag.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("substate-cli export-statetest: cannot create %v: %v", dir, err)
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDBReadOnly()
+	defer substate.CloseSubstateDB()
+
+	var skipped uint64
+	task := func(block uint64, tx int, recording *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		return exportStateTestTask(config, dir, &skipped, block, tx, recording)
+	}
+	taskPool := substate.NewSubstateTaskPool("substate-cli export-statetest", task, first, last, ctx)
+	err = progress.ExecuteTaskPool(taskPool)
+	if skipped > 0 {
+		fmt.Printf("substate-cli export-statetest: skipped %v transactions\n", skipped)
+	}
+	return err
+}
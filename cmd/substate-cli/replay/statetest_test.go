@@ -0,0 +1,90 @@
+package replay
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+func newTransferStateTest(fork string) *StateTest {
+	to := common.Address{0xb}
+	return &StateTest{
+		Env: stateTestEnv{
+			Coinbase:   common.Address{0xc},
+			Difficulty: (*hexutil.Big)(big.NewInt(1)),
+			GasLimit:   10000000,
+			Number:     1,
+			Timestamp:  1000,
+		},
+		Pre: map[common.Address]stateTestAcct{
+			stateTestSender: {Balance: (*hexutil.Big)(big.NewInt(1e18)), Nonce: 3},
+		},
+		Transaction: stateTestTx{
+			Data:      []hexutil.Bytes{{}},
+			GasLimit:  []hexutil.Uint64{21000},
+			Value:     []*hexutil.Big{(*hexutil.Big)(big.NewInt(1000))},
+			Nonce:     3,
+			To:        to.Hex(),
+			SecretKey: stateTestKey,
+			GasPrice:  (*hexutil.Big)(big.NewInt(10)),
+		},
+		Post: map[string][]stateTestPost{fork: {{}}},
+	}
+}
+
+func TestComputeStateTestPostIsAcceptedByRunner(t *testing.T) {
+	test := newTransferStateTest("Istanbul")
+	post, err := computeStateTestPost(test, "Istanbul")
+	if err != nil {
+		t.Fatalf("cannot compute post state: %v", err)
+	}
+	test.Post["Istanbul"][0] = post
+
+	data, err := json.Marshal(test)
+	if err != nil {
+		t.Fatalf("cannot encode state test: %v", err)
+	}
+	var runner tests.StateTest
+	if err := runner.UnmarshalJSON(data); err != nil {
+		t.Fatalf("cannot decode state test: %v", err)
+	}
+	for _, subtest := range runner.Subtests() {
+		if _, _, err := runner.Run(subtest, vm.Config{}, false); err != nil {
+			t.Errorf("post state rejected by the state test runner: %v", err)
+		}
+	}
+}
+
+func TestComputeStateTestPostRejectsInapplicableTransaction(t *testing.T) {
+	test := newTransferStateTest("Istanbul")
+	test.Transaction.Nonce = 4
+	if _, err := computeStateTestPost(test, "Istanbul"); err == nil {
+		t.Errorf("transaction with a wrong nonce was accepted")
+	}
+}
+
+func TestStateTestUnsupportedOps(t *testing.T) {
+	ops, err := stateTestUnsupportedOps(&params.ChainConfig{ChainID: big.NewInt(250)}, "Berlin")
+	if err != nil {
+		t.Fatalf("cannot get instructions: %v", err)
+	}
+	if !ops[vm.BLOCKHASH] || !ops[vm.CHAINID] {
+		t.Errorf("BLOCKHASH and CHAINID are not rejected on a chain other than the runner's: %v", ops)
+	}
+	ops, err = stateTestUnsupportedOps(&params.ChainConfig{ChainID: big.NewInt(1)}, "Berlin")
+	if err != nil {
+		t.Fatalf("cannot get instructions: %v", err)
+	}
+	if !ops[vm.BLOCKHASH] || ops[vm.CHAINID] {
+		t.Errorf("unexpected instructions on the chain of the runner: %v", ops)
+	}
+	if _, err := stateTestUnsupportedOps(params.MainnetChainConfig, "Unknown"); err == nil {
+		t.Errorf("unknown fork was accepted")
+	}
+}
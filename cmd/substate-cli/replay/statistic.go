@@ -3,7 +3,10 @@ package replay
 import (
 	"fmt"
 	"sort"
+	"strings"
 
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
 )
@@ -22,12 +25,11 @@ func (a *AccessStatistics[T]) RegisterAccess(reference *T) {
 	a.accesses[*reference]++
 }
 
-func (a *AccessStatistics[T]) PrintSummary() {
-	var count = len(a.accesses)
-	var sum int64 = 0
+// getDistribution returns the accumulated number of references of the
+// least referenced targets for each percentile 0..100 of the targets.
+func (a *AccessStatistics[T]) getDistribution() []int {
 	list := make([]int, 0, len(a.accesses))
 	for _, count := range a.accesses {
-		sum += int64(count)
 		list = append(list, count)
 	}
 	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
@@ -38,17 +40,56 @@ func (a *AccessStatistics[T]) PrintSummary() {
 		prefix_sum = list[i]
 	}
 
-	fmt.Printf("Reference frequency distribution:\n")
+	distribution := make([]int, 101)
 	for i := 0; i < 100; i++ {
-		fmt.Printf("%d, %d\n", i, list[i*len(list)/100])
+		distribution[i] = list[i*len(list)/100]
+	}
+	distribution[100] = list[len(list)-1]
+	return distribution
+}
+
+func (a *AccessStatistics[T]) PrintSummary() {
+	var count = len(a.accesses)
+	var sum int64 = 0
+	for _, count := range a.accesses {
+		sum += int64(count)
+	}
+
+	fmt.Printf("Reference frequency distribution:\n")
+	for i, references := range a.getDistribution() {
+		fmt.Printf("%d, %d\n", i, references)
 	}
-	fmt.Printf("100, %d\n", list[len(list)-1])
 	fmt.Printf("Number of targets:          %15d\n", count)
 	fmt.Printf("Number of references:       %15d\n", sum)
 	fmt.Printf("Average references/target:  %15.2f\n", float32(sum)/float32(count))
 }
 
-type AccessStatisticsConsumer[T comparable] func(*AccessStatistics[T])
+// newFrequencySchema declares the reference frequency distribution table of
+// a statistics command.
+func newFrequencySchema(cli_command string) *metrics.Schema {
+	return &metrics.Schema{
+		Table: strings.ReplaceAll(cli_command, "-", "_") + "_frequency",
+		Columns: []metrics.Column{
+			{Name: "percentile", Type: metrics.Integer},
+			{Name: "cumulative_references", Type: metrics.Integer},
+		},
+		PrimaryKey: []string{"percentile"},
+	}
+}
+
+// WriteDistribution writes the reference frequency distribution to a sink.
+func (a *AccessStatistics[T]) WriteDistribution(sink metrics.Sink, table string) error {
+	for i, references := range a.getDistribution() {
+		if err := sink.Write(table, i, references); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AccessStatisticsConsumer post-processes collected statistics. The sink is
+// nil if no --output was requested.
+type AccessStatisticsConsumer[T comparable] func(*AccessStatistics[T], metrics.Sink) error
 
 // ----------------------------- Access Statistic Tools ---------------------------------
 
@@ -90,7 +131,7 @@ func collectStats[T comparable](dest chan<- T, extract Extractor[T], block uint6
 // getReferenceStatsAction a generic utility to collect access statistics from recorded
 // substate data.
 func getReferenceStatsAction[T comparable](ctx *cli.Context, cli_command string, extract Extractor[T]) error {
-	return getReferenceStatsActionWithConsumer(ctx, cli_command, extract, func(*AccessStatistics[T]) {})
+	return getReferenceStatsActionWithConsumer(ctx, cli_command, extract, func(*AccessStatistics[T], metrics.Sink) error { return nil })
 }
 
 // getReferenceStatsActionWithConsumer extends the abilitities of the function above by
@@ -130,7 +171,7 @@ func getReferenceStatsActionWithConsumer[T comparable](ctx *cli.Context, cli_com
 
 	// Process all transactions in parallel, out-of-order.
 	taskPool := substate.NewSubstateTaskPool(fmt.Sprintf("substate-cli %v", cli_command), task, first, last, ctx)
-	err = taskPool.Execute()
+	err = progress.ExecuteTaskPool(taskPool)
 	if err != nil {
 		return err
 	}
@@ -149,6 +190,22 @@ func getReferenceStatsActionWithConsumer[T comparable](ctx *cli.Context, cli_com
 	fmt.Printf("\n\n----- Summary: -------\n")
 	stats.PrintSummary()
 	fmt.Printf("----------------------\n")
-	consume(&stats)
-	return nil
+
+	// Write the distribution if requested.
+	if ctx.String(OutputFlag.Name) == "" {
+		return consume(&stats, nil)
+	}
+	schema := newFrequencySchema(cli_command)
+	sink, err := openMetricsSink(ctx, cli_command, schema)
+	if err != nil {
+		return err
+	}
+	err = stats.WriteDistribution(sink, schema.Table)
+	if err == nil {
+		err = consume(&stats, sink)
+	}
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
@@ -3,6 +3,8 @@ package replay
 import (
 	"fmt"
 
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
@@ -16,8 +18,12 @@ var GetStorageUpdateSizeCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 		&ChainIDFlag,
+		&OutputFlag,
 	},
 	Description: `
 The substate-cli storage-size command requires two arguments:
@@ -26,7 +32,27 @@ The substate-cli storage-size command requires two arguments:
 <blockNumFirst> and <blockNumLast> are the first and
 last block of the inclusive range of blocks to replay transactions.
 
-Output log format: (block, timestamp, transaction, account, storage update size, storage size in input substate, storage size in output substate)`,
+Output log format: (block, timestamp, transaction, account, storage update size, storage size in input substate, storage size in output substate)
+
+With --output, the rows are written to the storage_update table of a CSV,
+JSON-lines or SQLite file instead.`,
+}
+
+// storageUpdateSchema declares the rows of the storage-size command.
+var storageUpdateSchema = &metrics.Schema{
+	Table: "storage_update",
+	Columns: []metrics.Column{
+		{Name: "block_number", Type: metrics.Integer},
+		{Name: "block_timestamp", Type: metrics.Integer},
+		{Name: "tx_number", Type: metrics.Integer},
+		{Name: "contract", Type: metrics.Text},
+		{Name: "storage_update_bytes", Type: metrics.Integer},
+		{Name: "input_update_bytes", Type: metrics.Integer},
+		// TEXT like the column created by scripts/metric_scripts/create_storage_table,
+		// such that existing databases remain compatible
+		{Name: "output_update_bytes", Type: metrics.Text},
+	},
+	PrimaryKey: []string{"block_number", "tx_number", "contract"},
 }
 
 // computeStorageSize computes the number of non-zero storage entries
@@ -73,8 +99,16 @@ func computeStorageSizes(inUpdateSet map[common.Hash]common.Hash, outUpdateSet m
 	return deltaSize * int64(wordSize), inUpdateSize * wordSize, outUpdateSize * wordSize
 }
 
+// newStorageUpdateSizeTask creates a task writing the storage update sizes to
+// the given sink.
+func newStorageUpdateSizeTask(sink metrics.Sink) substate.SubstateTaskFunc {
+	return func(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		return getStorageUpdateSizeTask(sink, block, tx, st)
+	}
+}
+
 // getStorageUpdateSizeTask replays storage access of accounts in each transaction
-func getStorageUpdateSizeTask(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+func getStorageUpdateSizeTask(sink metrics.Sink, block uint64, tx int, st *substate.Substate) error {
 	timestamp := st.Env.Timestamp
 	for wallet, outputAccount := range st.OutputAlloc {
 		var (
@@ -89,13 +123,17 @@ func getStorageUpdateSizeTask(block uint64, tx int, st *substate.Substate, taskP
 		} else {
 			deltaSize, inUpdateSize, outUpdateSize = computeStorageSizes(map[common.Hash]common.Hash{}, outputAccount.Storage)
 		}
-		fmt.Printf("metric: %v,%v,%v,%v,%v,%v,%v\n", block, timestamp, tx, wallet.Hex(), deltaSize, inUpdateSize, outUpdateSize)
+		if err := sink.Write(storageUpdateSchema.Table, block, timestamp, tx, wallet.Hex(), deltaSize, inUpdateSize, outUpdateSize); err != nil {
+			return err
+		}
 	}
 	// account exists in input substate but not output substate
 	for wallet, inputAccount := range st.InputAlloc {
 		if _, found := st.OutputAlloc[wallet]; !found {
 			deltaSize, inUpdateSize, outUpdateSize := computeStorageSizes(inputAccount.Storage, map[common.Hash]common.Hash{})
-			fmt.Printf("metric: %v,%v,%v,%v,%v,%v,%v\n", block, timestamp, tx, wallet.Hex(), deltaSize, inUpdateSize, outUpdateSize)
+			if err := sink.Write(storageUpdateSchema.Table, block, timestamp, tx, wallet.Hex(), deltaSize, inUpdateSize, outUpdateSize); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -123,7 +161,15 @@ func getStorageUpdateSizeAction(ctx *cli.Context) error {
 	substate.OpenSubstateDBReadOnly()
 	defer substate.CloseSubstateDB()
 
-	taskPool := substate.NewSubstateTaskPool("substate-cli storage", getStorageUpdateSizeTask, first, last, ctx)
-	err = taskPool.Execute()
+	sink, err := openMetricsSink(ctx, "storage", storageUpdateSchema)
+	if err != nil {
+		return err
+	}
+
+	taskPool := substate.NewSubstateTaskPool("substate-cli storage", newStorageUpdateSizeTask(sink), first, last, ctx)
+	err = progress.ExecuteTaskPool(taskPool)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
 	return err
 }
@@ -0,0 +1,385 @@
+package replay
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Fantom-foundation/substate-cli/metrics"
+	"github.com/Fantom-foundation/substate-cli/progress"
+	"github.com/Fantom-foundation/substate-cli/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// record-replay: substate-cli storage-access command
+var GetStorageAccessCommand = cli.Command{
+	Action:    getStorageAccessAction,
+	Name:      "storage-access",
+	Usage:     "classifies storage accesses into reads and kinds of writes",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Flags: []cli.Flag{
+		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
+		&substate.SubstateDirFlag,
+		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
+		&InterpreterImplFlag,
+		&UseInMemoryStateDbFlag,
+		&OutputFlag,
+	},
+	Description: `
+The substate-cli storage-access command requires two arguments:
+<blockNumFirst> <blockNumLast>
+
+<blockNumFirst> and <blockNumLast> are the first and
+last block of the inclusive range of blocks to be analysed.
+
+The transactions are replayed to observe which storage slots are written.
+Every storage slot accessed by a transaction is classified by its value
+before and after the transaction:
+  read-only         the slot is not written
+  write-same-value  the slot is written, but its value is unchanged
+  write-new         a non-zero value is replaced by another non-zero value
+  cleared           a non-zero value is replaced by zero
+  created           a zero value is replaced by a non-zero value
+
+The accesses per contract are written to the storage_access_contract table
+and the number of transactions accessing and writing a given number of
+slots to the storage_access_distribution table, printed as metric: lines or
+written to the file given by --output. A summary is printed to the console.`,
+}
+
+// storage access classes
+const (
+	storageReadOnly = iota
+	storageWriteSameValue
+	storageWriteNew
+	storageCleared
+	storageCreated
+	numStorageAccessClasses
+)
+
+var storageAccessClassNames = [numStorageAccessClasses]string{
+	"read-only",
+	"write-same-value",
+	"write-new",
+	"cleared",
+	"created",
+}
+
+// storage_access_contract table
+var storageAccessContractSchema = &metrics.Schema{
+	Table: "storage_access_contract",
+	Columns: []metrics.Column{
+		{Name: "contract", Type: metrics.Text},
+		{Name: "transactions", Type: metrics.Integer},
+		{Name: "read_only", Type: metrics.Integer},
+		{Name: "write_same_value", Type: metrics.Integer},
+		{Name: "write_new", Type: metrics.Integer},
+		{Name: "cleared", Type: metrics.Integer},
+		{Name: "created", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"contract"},
+}
+
+// storage_access_distribution table
+var storageAccessDistributionSchema = &metrics.Schema{
+	Table: "storage_access_distribution",
+	Columns: []metrics.Column{
+		{Name: "slots", Type: metrics.Integer},
+		{Name: "accessing_transactions", Type: metrics.Integer},
+		{Name: "writing_transactions", Type: metrics.Integer},
+	},
+	PrimaryKey: []string{"slots"},
+}
+
+// storageSlot identifies a storage slot of an account.
+type storageSlot struct {
+	address common.Address
+	key     common.Hash
+}
+
+// storageWriteRecorder is a StateDB recording the storage slots written by
+// a transaction. Writes reverted with RevertToSnapshot are dropped.
+type storageWriteRecorder struct {
+	state.StateDB
+	writes    map[storageSlot]struct{}
+	journal   []storageSlot          // slots in the order of their first write
+	snapshots []storageWriteSnapshot // open snapshots, innermost last
+}
+
+// storageWriteSnapshot is the length of the journal when a snapshot was taken.
+type storageWriteSnapshot struct {
+	id      int
+	journal int
+}
+
+func (db *storageWriteRecorder) SetState(address common.Address, key common.Hash, value common.Hash) {
+	slot := storageSlot{address, key}
+	if _, found := db.writes[slot]; !found {
+		db.writes[slot] = struct{}{}
+		db.journal = append(db.journal, slot)
+	}
+	db.StateDB.SetState(address, key, value)
+}
+
+func (db *storageWriteRecorder) Snapshot() int {
+	id := db.StateDB.Snapshot()
+	db.snapshots = append(db.snapshots, storageWriteSnapshot{id: id, journal: len(db.journal)})
+	return id
+}
+
+func (db *storageWriteRecorder) RevertToSnapshot(id int) {
+	for i := len(db.snapshots) - 1; i >= 0; i-- {
+		if db.snapshots[i].id != id {
+			continue
+		}
+		for _, slot := range db.journal[db.snapshots[i].journal:] {
+			delete(db.writes, slot)
+		}
+		db.journal = db.journal[:db.snapshots[i].journal]
+		db.snapshots = db.snapshots[:i]
+		break
+	}
+	db.StateDB.RevertToSnapshot(id)
+}
+
+// classifyStorageAccess classifies the access of a slot by its value before
+// and after a transaction.
+func classifyStorageAccess(before common.Hash, after common.Hash, written bool) int {
+	switch {
+	case !written:
+		return storageReadOnly
+	case before == after:
+		return storageWriteSameValue
+	case before == (common.Hash{}):
+		return storageCreated
+	case after == (common.Hash{}):
+		return storageCleared
+	}
+	return storageWriteNew
+}
+
+// storageAccessStats counts the accesses of a contract's storage.
+type storageAccessStats struct {
+	transactions uint64
+	accesses     [numStorageAccessClasses]uint64
+}
+
+// storageAccessCollector accumulates the storage accesses of all contracts.
+type storageAccessCollector struct {
+	mu        sync.Mutex
+	contracts map[common.Address]*storageAccessStats
+	total     storageAccessStats
+	// number of transactions by the number of accessed and written slots
+	slots        map[int]uint64
+	writtenSlots map[int]uint64
+}
+
+// storageAccessTask replays a transaction and classifies its storage accesses.
+func storageAccessTask(config ReplayConfig, collector *storageAccessCollector, block uint64, tx int, st *substate.Substate) error {
+	config.storage_writes = map[storageSlot]struct{}{}
+	_, evmAlloc, err := runSubstate(config, config.vm_impl, block, tx, st, copyAlloc(st.InputAlloc), nil)
+	if err != nil {
+		return fmt.Errorf("block: %v Transaction: %v: %v", block, tx, err)
+	}
+
+	// all slots read or written by the transaction
+	contracts := map[common.Address]*storageAccessStats{}
+	written := 0
+	slots := 0
+	classify := func(address common.Address, key common.Hash) {
+		stats, found := contracts[address]
+		if !found {
+			stats = &storageAccessStats{}
+			contracts[address] = stats
+		}
+		var before, after common.Hash
+		if account, found := st.InputAlloc[address]; found {
+			before = account.Storage[key]
+		}
+		if account, found := evmAlloc[address]; found {
+			after = account.Storage[key]
+		}
+		_, isWritten := config.storage_writes[storageSlot{address, key}]
+		if isWritten {
+			written++
+		}
+		slots++
+		stats.accesses[classifyStorageAccess(before, after, isWritten)]++
+	}
+	for address, account := range st.InputAlloc {
+		for key := range account.Storage {
+			classify(address, key)
+		}
+	}
+	for slot := range config.storage_writes {
+		if account, found := st.InputAlloc[slot.address]; found {
+			if _, read := account.Storage[slot.key]; read {
+				continue
+			}
+		}
+		classify(slot.address, slot.key)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.total.transactions++
+	collector.slots[slots]++
+	collector.writtenSlots[written]++
+	for address, stats := range contracts {
+		total, found := collector.contracts[address]
+		if !found {
+			total = &storageAccessStats{}
+			collector.contracts[address] = total
+		}
+		total.transactions++
+		for class, n := range stats.accesses {
+			total.accesses[class] += n
+			collector.total.accesses[class] += n
+		}
+	}
+	return nil
+}
+
+// write writes the accesses per contract, sorted by the number of accesses,
+// and the distribution of slots per transaction to a sink.
+func (c *storageAccessCollector) write(sink metrics.Sink) error {
+	sum := func(stats *storageAccessStats) (n uint64) {
+		for _, accesses := range stats.accesses {
+			n += accesses
+		}
+		return n
+	}
+	addresses := make([]common.Address, 0, len(c.contracts))
+	for address := range c.contracts {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		a, b := sum(c.contracts[addresses[i]]), sum(c.contracts[addresses[j]])
+		if a != b {
+			return a > b
+		}
+		return addresses[i].Hex() < addresses[j].Hex()
+	})
+	for _, address := range addresses {
+		stats := c.contracts[address]
+		a := stats.accesses
+		err := sink.Write(storageAccessContractSchema.Table,
+			address.Hex(),
+			stats.transactions,
+			a[storageReadOnly],
+			a[storageWriteSameValue],
+			a[storageWriteNew],
+			a[storageCleared],
+			a[storageCreated])
+		if err != nil {
+			return err
+		}
+	}
+
+	max := 0
+	for n := range c.slots {
+		if n > max {
+			max = n
+		}
+	}
+	for n := 0; n <= max; n++ {
+		if c.slots[n] == 0 && c.writtenSlots[n] == 0 {
+			continue
+		}
+		if err := sink.Write(storageAccessDistributionSchema.Table, n, c.slots[n], c.writtenSlots[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSummary prints the accesses per class.
+func (c *storageAccessCollector) printSummary() {
+	var total uint64
+	for _, n := range c.total.accesses {
+		total += n
+	}
+	fmt.Printf("substate-cli storage-access: transactions:     %15d\n", c.total.transactions)
+	fmt.Printf("substate-cli storage-access: contracts:        %15d\n", len(c.contracts))
+	fmt.Printf("substate-cli storage-access: slot accesses:    %15d\n", total)
+	for class, n := range c.total.accesses {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(n) / float64(total)
+		}
+		fmt.Printf("substate-cli storage-access: %-17s %15d (%.2f%%)\n", storageAccessClassNames[class]+":", n, share)
+	}
+}
+
+// func getStorageAccessAction for GetStorageAccessCommand
+func getStorageAccessAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli storage-access command requires exactly 2 arguments")
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli storage-access: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("git-date: %v\n", gitDate)
+	fmt.Printf("git-commit: %v\n", gitCommit)
+
+	first, last, argErr := SetBlockRange(ctx.Args().Get(0), ctx.Args().Get(1))
+	if argErr != nil {
+		return argErr
+	}
+
+	config := ReplayConfig{
+		vm_impl:          ctx.String(InterpreterImplFlag.Name),
+		use_in_memory_db: ctx.Bool(UseInMemoryStateDbFlag.Name),
+		chain_profile:    chainProfile,
+		chain_config:     chainProfile.ChainConfig(),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		config.vm_impl = chainProfile.VM.Interpreter
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDBReadOnly()
+	defer substate.CloseSubstateDB()
+
+	sink, err := openMetricsSink(ctx, "storage-access", storageAccessContractSchema, storageAccessDistributionSchema)
+	if err != nil {
+		return err
+	}
+
+	collector := &storageAccessCollector{
+		contracts:    map[common.Address]*storageAccessStats{},
+		slots:        map[int]uint64{},
+		writtenSlots: map[int]uint64{},
+	}
+	task := func(block uint64, tx int, st *substate.Substate, taskPool *substate.SubstateTaskPool) error {
+		return storageAccessTask(config, collector, block, tx, st)
+	}
+	taskPool := substate.NewSubstateTaskPool("substate-cli storage-access", task, first, last, ctx)
+	err = progress.ExecuteTaskPool(taskPool)
+	if err == nil {
+		err = collector.write(sink)
+	}
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	collector.printSummary()
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/Fantom-foundation/substate-cli/progress"
 	"github.com/ethereum/go-ethereum/substate"
 	"github.com/urfave/cli/v2"
 )
@@ -16,6 +17,9 @@ var SubstateDumpCommand = cli.Command{
 	ArgsUsage: "<blockNumFirst> <blockNumLast>",
 	Flags: []cli.Flag{
 		&substate.WorkersFlag,
+		&progress.IntervalFlag,
+		&progress.FileFlag,
+		&progress.MetricsAddrFlag,
 		&substate.SubstateDirFlag,
 	},
 	Description: `
@@ -72,6 +76,6 @@ func substateDumpAction(ctx *cli.Context) error {
 	defer substate.CloseSubstateDB()
 
 	taskPool := substate.NewSubstateTaskPool("substate-cli dump", substateDumpTask, first, last, ctx)
-	err = taskPool.Execute()
+	err = progress.ExecuteTaskPool(taskPool)
 	return err
 }
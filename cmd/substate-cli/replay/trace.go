@@ -0,0 +1,268 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// names of the supported tracers
+const (
+	structLogTracerName = "struct"
+	callTracerName      = "call"
+)
+
+// TraceConfig selects the transactions to be traced and the tracer to be
+// used. A nil *TraceConfig disables tracing.
+type TraceConfig struct {
+	dir              string
+	tracer           string
+	blocks           map[uint64]bool         // blocks of which all transactions are traced
+	txs              map[uint64]map[int]bool // individually selected transactions
+	addresses        map[common.Address]bool // senders and recipients of traced transactions
+	only_mismatching bool
+}
+
+// NewTraceConfig parses the tracing options of the command line. It returns
+// nil if tracing was not requested.
+func NewTraceConfig(ctx *cli.Context) (*TraceConfig, error) {
+	dir := ctx.String(TraceDirFlag.Name)
+	if dir == "" {
+		return nil, nil
+	}
+	config := &TraceConfig{
+		dir:              dir,
+		tracer:           ctx.String(TracerFlag.Name),
+		only_mismatching: ctx.Bool(TraceOnlyMismatchingFlag.Name),
+	}
+	if config.tracer != structLogTracerName && config.tracer != callTracerName {
+		return nil, fmt.Errorf("unknown tracer %q, supported tracers are %v and %v", config.tracer, structLogTracerName, callTracerName)
+	}
+	if list := ctx.String(TraceTxFlag.Name); list != "" {
+		config.blocks = map[uint64]bool{}
+		config.txs = map[uint64]map[int]bool{}
+		for _, entry := range strings.Split(list, ",") {
+			blockStr, txStr, hasTx := strings.Cut(strings.TrimSpace(entry), ":")
+			block, err := strconv.ParseUint(blockStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block number in --%v: %q", TraceTxFlag.Name, entry)
+			}
+			if !hasTx {
+				config.blocks[block] = true
+				continue
+			}
+			tx, err := strconv.Atoi(txStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transaction index in --%v: %q", TraceTxFlag.Name, entry)
+			}
+			if config.txs[block] == nil {
+				config.txs[block] = map[int]bool{}
+			}
+			config.txs[block][tx] = true
+		}
+	}
+	if list := ctx.String(TraceAddressFlag.Name); list != "" {
+		config.addresses = map[common.Address]bool{}
+		for _, entry := range strings.Split(list, ",") {
+			entry = strings.TrimSpace(entry)
+			if !common.IsHexAddress(entry) {
+				return nil, fmt.Errorf("invalid address in --%v: %q", TraceAddressFlag.Name, entry)
+			}
+			config.addresses[common.HexToAddress(entry)] = true
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create trace directory %v: %v", dir, err)
+	}
+	return config, nil
+}
+
+// selects reports whether the given transaction is to be traced.
+func (c *TraceConfig) selects(block uint64, tx int, recording *substate.Substate) bool {
+	if c.blocks != nil && !c.blocks[block] && !c.txs[block][tx] {
+		return false
+	}
+	if c.addresses != nil {
+		msg := recording.Message
+		if !c.addresses[msg.From] && (msg.To == nil || !c.addresses[*msg.To]) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewTracer creates a tracer for the given transaction, or returns nil if the
+// transaction is not selected for tracing.
+func (c *TraceConfig) NewTracer(block uint64, tx int, recording *substate.Substate) TransactionTracer {
+	if c == nil || !c.selects(block, tx, recording) {
+		return nil
+	}
+	if c.tracer == callTracerName {
+		return &callTracer{}
+	}
+	return &structLogTracer{StructLogger: vm.NewStructLogger(&vm.LogConfig{})}
+}
+
+// Finish writes the trace of a transaction to a JSON file named after the
+// block, the transaction index, and the interpreter if given. If only
+// mismatching transactions are traced, consistent transactions are dropped.
+func (c *TraceConfig) Finish(tracer TransactionTracer, block uint64, tx int, vm_impl string, mismatch bool) error {
+	if c == nil || tracer == nil || (c.only_mismatching && !mismatch) {
+		return nil
+	}
+	name := fmt.Sprintf("%d_%d", block, tx)
+	if vm_impl != "" {
+		name = fmt.Sprintf("%s_%s", name, vm_impl)
+	}
+	filename := filepath.Join(c.dir, name+".json")
+	data, err := json.MarshalIndent(&TransactionTrace{
+		Block:       block,
+		Tx:          tx,
+		Interpreter: vm_impl,
+		Tracer:      c.tracer,
+		Mismatch:    mismatch,
+		Result:      tracer.Result(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode trace of block %v tx %v: %v", block, tx, err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("cannot write trace file %v: %v", filename, err)
+	}
+	return nil
+}
+
+// TransactionTrace is the content of a trace file.
+type TransactionTrace struct {
+	Block       uint64      `json:"block"`
+	Tx          int         `json:"tx"`
+	Interpreter string      `json:"interpreter,omitempty"`
+	Tracer      string      `json:"tracer"`
+	Mismatch    bool        `json:"mismatch"`
+	Result      interface{} `json:"result"`
+}
+
+// TransactionTracer is a VM tracer producing a JSON-encodable result.
+type TransactionTracer interface {
+	vm.Tracer
+	Result() interface{}
+}
+
+// structLogTracer records every executed instruction.
+type structLogTracer struct {
+	*vm.StructLogger
+}
+
+type structLogResult struct {
+	Failed      bool           `json:"failed"`
+	Error       string         `json:"error,omitempty"`
+	ReturnValue hexutil.Bytes  `json:"returnValue"`
+	StructLogs  []vm.StructLog `json:"structLogs"`
+}
+
+func (t *structLogTracer) Result() interface{} {
+	res := &structLogResult{
+		ReturnValue: t.Output(),
+		StructLogs:  t.StructLogs(),
+	}
+	if err := t.Error(); err != nil {
+		res.Failed = true
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// callFrame is a single call of a call trace.
+type callFrame struct {
+	Type    string         `json:"type"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	Value   *hexutil.Big   `json:"value,omitempty"`
+	Gas     hexutil.Uint64 `json:"gas"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Input   hexutil.Bytes  `json:"input"`
+	Output  hexutil.Bytes  `json:"output,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Calls   []*callFrame   `json:"calls,omitempty"`
+}
+
+func newCallFrame(typ vm.OpCode, from, to common.Address, input []byte, gas uint64, value *big.Int) *callFrame {
+	frame := &callFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	}
+	return frame
+}
+
+func (f *callFrame) finish(output []byte, gasUsed uint64, err error) {
+	f.GasUsed = hexutil.Uint64(gasUsed)
+	f.Output = common.CopyBytes(output)
+	if err != nil {
+		f.Error = err.Error()
+	}
+}
+
+// callTracer records the tree of calls of a transaction.
+type callTracer struct {
+	root  *callFrame
+	stack []*callFrame
+}
+
+func (t *callTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.root = newCallFrame(typ, from, to, input, gas, value)
+	t.stack = []*callFrame{t.root}
+}
+
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (t *callTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	frame := newCallFrame(typ, from, to, input, gas, value)
+	if len(t.stack) > 0 {
+		parent := t.stack[len(t.stack)-1]
+		parent.Calls = append(parent.Calls, frame)
+	}
+	t.stack = append(t.stack, frame)
+}
+
+func (t *callTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.stack) <= 1 {
+		return
+	}
+	t.stack[len(t.stack)-1].finish(output, gasUsed, err)
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	if t.root != nil {
+		t.root.finish(output, gasUsed, err)
+	}
+}
+
+func (t *callTracer) Result() interface{} {
+	return t.root
+}
@@ -0,0 +1,124 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+// substate-cli tx command
+var TxCommand = cli.Command{
+	Action:    txAction,
+	Name:      "tx",
+	Usage:     "replays a single transaction and compares it with the recording",
+	ArgsUsage: "<block> <tx>",
+	Flags: []cli.Flag{
+		&ChainIDFlag,
+		&ChainConfigFlag,
+		&ChainProfileFlag,
+		&InterpreterImplFlag,
+		&UseInMemoryStateDbFlag,
+		&CrossCheckStateDbFlag,
+		&TraceDirFlag,
+		&TracerFlag,
+		&substate.SubstateDirFlag,
+	},
+	Description: `
+The substate-cli tx command requires two arguments:
+<block> <tx>
+
+<block> and <tx> are the block number and the index of the transaction
+to be replayed.
+
+The command prints the recorded environment and message, replays the
+transaction with the selected interpreter and StateDB, and prints the result
+and all differences to the recording. With --trace <dir>, a trace of the
+transaction is written to <dir>/<block>_<tx>.json.`,
+}
+
+func printJSON(label string, v interface{}) {
+	jbytes, _ := json.MarshalIndent(v, "", " ")
+	fmt.Printf("%s:\n%s\n", label, jbytes)
+}
+
+// func txAction for tx command
+func txAction(ctx *cli.Context) error {
+	var err error
+
+	if ctx.Args().Len() != 2 {
+		return fmt.Errorf("substate-cli tx command requires exactly 2 arguments")
+	}
+	block, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("substate-cli tx: invalid block number %v", ctx.Args().Get(0))
+	}
+	tx, err := strconv.Atoi(ctx.Args().Get(1))
+	if err != nil || tx < 0 {
+		return fmt.Errorf("substate-cli tx: invalid transaction index %v", ctx.Args().Get(1))
+	}
+
+	chainID = ctx.Int(ChainIDFlag.Name)
+	chainProfile, err := getChainProfile(ctx.String(ChainConfigFlag.Name), ctx.String(ChainProfileFlag.Name), uint64(chainID))
+	if err != nil {
+		return fmt.Errorf("substate-cli tx: %v", err)
+	}
+	chainID = int(chainProfile.ChainID)
+	fmt.Printf("chain-id: %v\n", chainID)
+	fmt.Printf("chain-profile: %v\n", chainProfile.Name)
+
+	var config = ReplayConfig{
+		vm_impl:          ctx.String(InterpreterImplFlag.Name),
+		use_in_memory_db: ctx.Bool(UseInMemoryStateDbFlag.Name),
+		cross_check_db:   ctx.Bool(CrossCheckStateDbFlag.Name),
+		chain_profile:    chainProfile,
+		chain_config:     chainProfile.ChainConfig(),
+	}
+	if !ctx.IsSet(InterpreterImplFlag.Name) && chainProfile.VM.Interpreter != "" {
+		config.vm_impl = chainProfile.VM.Interpreter
+	}
+	config.trace, err = NewTraceConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("substate-cli tx: %v", err)
+	}
+
+	substate.SetSubstateFlags(ctx)
+	substate.OpenSubstateDBReadOnly()
+	defer substate.CloseSubstateDB()
+
+	if !substate.HasSubstate(block, tx) {
+		return fmt.Errorf("substate-cli tx: no substate recorded for block %v tx %v", block, tx)
+	}
+	recording := substate.GetSubstate(block, tx)
+
+	fmt.Printf("block: %v Transaction: %v\n", block, tx)
+	printJSON("Recorded input environment", recording.Env)
+	printJSON("Recorded input message", recording.Message)
+
+	resetVmDuration()
+	tracer := config.trace.NewTracer(block, tx, recording)
+	evmResult, evmAlloc, err := runSubstate(config, config.vm_impl, block, tx, recording, copyAlloc(recording.InputAlloc), tracer)
+	if err != nil {
+		if traceErr := config.trace.Finish(tracer, block, tx, "", true); traceErr != nil {
+			fmt.Printf("substate-cli tx: %v\n", traceErr)
+		}
+		return fmt.Errorf("substate-cli tx: %v", err)
+	}
+	fmt.Printf("substate-cli tx: VM time: %v\n", getVmDuration())
+
+	printJSON("Recorded output result", recording.Result)
+	printJSON("Replayed output result", evmResult)
+
+	diff := NewTransactionDiff(block, tx, recording.Message.To, recording.Result, recording.OutputAlloc, evmResult, evmAlloc)
+	if err := config.trace.Finish(tracer, block, tx, "", diff != nil); err != nil {
+		return fmt.Errorf("substate-cli tx: %v", err)
+	}
+	if diff == nil {
+		fmt.Printf("substate-cli tx: replayed output is consistent with the recording\n")
+		return nil
+	}
+	diff.Print()
+	return fmt.Errorf("substate-cli tx: %v", &InconsistentOutputError{Diff: diff})
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// consoleSink prints rows as comma separated "metric:" lines, the format
+// processed by the scripts in scripts/metric_scripts.
+type consoleSink struct {
+	mu     sync.Mutex
+	tables tables
+}
+
+func newConsoleSink() *consoleSink {
+	return &consoleSink{tables: tables{}}
+}
+
+func (s *consoleSink) Declare(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tables.declare(schema)
+}
+
+func (s *consoleSink) Write(table string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.tables.get(table, values); err != nil {
+		return err
+	}
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = fmt.Sprintf("%v", value)
+	}
+	fmt.Printf("metric: %v\n", strings.Join(fields, ","))
+	return nil
+}
+
+func (s *consoleSink) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// csvSink writes every table to its own CSV file with a header line. The
+// first declared table is written to the given path, further tables to
+// <path>_<table>.csv.
+type csvSink struct {
+	mu      sync.Mutex
+	path    string
+	tables  tables
+	files   map[string]*os.File
+	writers map[string]*csv.Writer
+}
+
+func newCSVSink(path string) *csvSink {
+	return &csvSink{
+		path:    path,
+		tables:  tables{},
+		files:   map[string]*os.File{},
+		writers: map[string]*csv.Writer{},
+	}
+}
+
+func (s *csvSink) Declare(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path
+	if len(s.tables) > 0 {
+		ext := filepath.Ext(s.path)
+		path = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(s.path, ext), schema.Table, ext)
+	}
+	if err := s.tables.declare(schema); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot create metrics file %v: %v", path, err)
+	}
+	writer := csv.NewWriter(file)
+	header := make([]string, len(schema.Columns))
+	for i, column := range schema.Columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		file.Close()
+		return err
+	}
+	s.files[schema.Table] = file
+	s.writers[schema.Table] = writer
+	return nil
+}
+
+func (s *csvSink) Write(table string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.tables.get(table, values); err != nil {
+		return err
+	}
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = fmt.Sprintf("%v", value)
+	}
+	return s.writers[table].Write(record)
+}
+
+func (s *csvSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res error
+	for table, writer := range s.writers {
+		writer.Flush()
+		if err := writer.Error(); err != nil && res == nil {
+			res = err
+		}
+		if err := s.files[table].Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// jsonLinesSink writes every row as a JSON object holding the table name and
+// the named column values, one object per line.
+type jsonLinesSink struct {
+	mu     sync.Mutex
+	tables tables
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func newJSONLinesSink(path string) (*jsonLinesSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create metrics file %v: %v", path, err)
+	}
+	return &jsonLinesSink{tables: tables{}, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *jsonLinesSink) Declare(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tables.declare(schema)
+}
+
+func (s *jsonLinesSink) Write(table string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schema, err := s.tables.get(table, values)
+	if err != nil {
+		return err
+	}
+	row := make(map[string]interface{}, len(values)+1)
+	row["table"] = table
+	for i, column := range schema.Columns {
+		row[column.Name] = values[i]
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(data); err != nil {
+		return err
+	}
+	return s.writer.WriteByte('\n')
+}
+
+func (s *jsonLinesSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadTable reads the given columns of all rows of a table from a file
+// written by a sink; the file is named like an --output option. The values
+// are passed to visit as strings in the order of the columns. ReadTable
+// reports whether the file holds the table at all.
+//
+// A CSV table is read from <path>_<table>.csv, or from the file itself if its
+// header holds all columns.
+func ReadTable(input string, table string, columns []string, visit func(values []string) error) (bool, error) {
+	format, path := splitOutput(input)
+	switch format {
+	case "csv":
+		return readCSVTable(path, table, columns, visit)
+	case "jsonl":
+		return readJSONLinesTable(path, table, columns, visit)
+	case "sqlite":
+		return readSQLiteTable(path, table, columns, visit)
+	}
+	return false, fmt.Errorf("unknown metrics format of %v, use a .csv, .jsonl or .db file", input)
+}
+
+func readCSVTable(path string, table string, columns []string, visit func(values []string) error) (bool, error) {
+	ext := filepath.Ext(path)
+	for _, candidate := range []string{fmt.Sprintf("%s_%s%s", strings.TrimSuffix(path, ext), table, ext), path} {
+		file, err := os.Open(candidate)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("cannot open metrics file %v: %v", candidate, err)
+		}
+		found, err := readCSVFile(file, columns, visit)
+		file.Close()
+		if err != nil {
+			return false, fmt.Errorf("cannot read table %v from %v: %v", table, candidate, err)
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readCSVFile reads the columns of a CSV file if its header holds all of them.
+func readCSVFile(file io.Reader, columns []string, visit func(values []string) error) (bool, error) {
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	indices := make([]int, len(columns))
+	for i, column := range columns {
+		indices[i] = -1
+		for j, name := range header {
+			if name == column {
+				indices[i] = j
+			}
+		}
+		if indices[i] < 0 {
+			return false, nil
+		}
+	}
+	values := make([]string, len(columns))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		for i, index := range indices {
+			values[i] = record[index]
+		}
+		if err := visit(values); err != nil {
+			return true, err
+		}
+	}
+}
+
+func readJSONLinesTable(path string, table string, columns []string, visit func(values []string) error) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("cannot open metrics file %v: %v", path, err)
+	}
+	defer file.Close()
+
+	found := false
+	values := make([]string, len(columns))
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.UseNumber()
+		row := map[string]interface{}{}
+		if err := decoder.Decode(&row); err != nil {
+			return found, fmt.Errorf("cannot parse line %d of %v: %v", line, path, err)
+		}
+		if row["table"] != table {
+			continue
+		}
+		found = true
+		for i, column := range columns {
+			value, exists := row[column]
+			if !exists {
+				return found, fmt.Errorf("line %d of %v has no column %v", line, path, column)
+			}
+			if text, ok := value.(string); ok {
+				values[i] = text
+			} else {
+				values[i] = fmt.Sprint(value)
+			}
+		}
+		if err := visit(values); err != nil {
+			return found, fmt.Errorf("line %d of %v: %v", line, path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return found, fmt.Errorf("cannot read metrics file %v: %v", path, err)
+	}
+	return found, nil
+}
+
+func readSQLiteTable(path string, table string, columns []string, visit func(values []string) error) (bool, error) {
+	// sql.Open would create a missing database
+	if _, err := os.Stat(path); err != nil {
+		return false, fmt.Errorf("cannot open metrics database %v: %v", path, err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return false, fmt.Errorf("cannot open metrics database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type IN ('table', 'view') AND name = ?;", table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("cannot read metrics database %v: %v", path, err)
+	}
+	if count == 0 {
+		return false, nil
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s;", strings.Join(columns, ", "), table))
+	if err != nil {
+		return true, fmt.Errorf("cannot read table %v from %v: %v", table, path, err)
+	}
+	defer rows.Close()
+
+	fields := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range fields {
+		pointers[i] = &fields[i]
+	}
+	values := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return true, fmt.Errorf("cannot read table %v from %v: %v", table, path, err)
+		}
+		for i, field := range fields {
+			values[i] = field.String
+		}
+		if err := visit(values); err != nil {
+			return true, fmt.Errorf("table %v of %v: %v", table, path, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return true, fmt.Errorf("cannot read table %v from %v: %v", table, path, err)
+	}
+	return true, nil
+}
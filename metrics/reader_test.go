@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTestTable(t *testing.T, input string, table string, columns []string) (bool, []string) {
+	t.Helper()
+	rows := []string{}
+	found, err := ReadTable(input, table, columns, func(values []string) error {
+		rows = append(rows, strings.Join(values, ","))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read table %v of %v: %v", table, input, err)
+	}
+	return found, rows
+}
+
+func TestReadTableRoundTrip(t *testing.T) {
+	for _, name := range []string{"metrics.csv", "metrics.jsonl", "metrics.db"} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), name)
+			writeTestRows(t, filename)
+
+			// columns are read in the requested order
+			found, rows := readTestTable(t, filename, "contract", []string{"gas", "contract"})
+			if !found {
+				t.Fatalf("table contract not found")
+			}
+			if want := []string{"21000,0xa", "63000,0xb"}; !reflect.DeepEqual(rows, want) {
+				t.Errorf("unexpected rows of table contract, wanted %q, got %q", want, rows)
+			}
+
+			found, rows = readTestTable(t, filename, "distribution", []string{"slots", "transactions"})
+			if !found {
+				t.Fatalf("table distribution not found")
+			}
+			if want := []string{"0,3"}; !reflect.DeepEqual(rows, want) {
+				t.Errorf("unexpected rows of table distribution, wanted %q, got %q", want, rows)
+			}
+
+			if found, _ := readTestTable(t, filename, "missing", []string{"slots"}); found {
+				t.Errorf("missing table was found")
+			}
+		})
+	}
+}
+
+func TestReadTableWithFormatPrefix(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.log")
+	writeTestRows(t, "jsonl:"+filename)
+	found, rows := readTestTable(t, "jsonl:"+filename, "distribution", []string{"transactions"})
+	if !found || !reflect.DeepEqual(rows, []string{"3"}) {
+		t.Errorf("unexpected rows, wanted [3], got %q (found: %v)", rows, found)
+	}
+}
+
+func TestReadTableReportsMissingColumns(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writeTestRows(t, filename)
+	if _, err := ReadTable(filename, "contract", []string{"contract", "nonce"}, func([]string) error { return nil }); err == nil {
+		t.Errorf("missing column was not reported")
+	}
+
+	// a CSV file without the columns does not hold the table
+	filename = filepath.Join(t.TempDir(), "other.csv")
+	if err := os.WriteFile(filename, []byte("block,nonce\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := readTestTable(t, filename, "contract", []string{"contract"}); found {
+		t.Errorf("CSV file without the columns was taken for the table")
+	}
+}
+
+func TestReadTableOfMissingFile(t *testing.T) {
+	for _, name := range []string{"metrics.jsonl", "metrics.db"} {
+		filename := filepath.Join(t.TempDir(), name)
+		if _, err := ReadTable(filename, "contract", []string{"contract"}, func([]string) error { return nil }); err == nil {
+			t.Errorf("missing file %v was not reported", name)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("reading created the missing file %v", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package metrics provides sinks for the metrics collected by the analysis
+// commands of substate-cli. Every command declares the schema of its tables
+// and writes rows through a Sink, which stores them as CSV, JSON lines, in a
+// SQLite database, or prints them as "metric:" lines to the console.
+package metrics
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ColumnType is the SQL type of a column.
+type ColumnType string
+
+const (
+	Integer ColumnType = "INTEGER"
+	Real    ColumnType = "REAL"
+	Text    ColumnType = "TEXT"
+)
+
+// Column is a single column of a table.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Schema declares a table written to a sink.
+type Schema struct {
+	Table      string
+	Columns    []Column
+	PrimaryKey []string // optional
+}
+
+// Experiment describes the run producing the metrics.
+type Experiment struct {
+	Type      string
+	ChainID   int
+	GitDate   string
+	GitCommit string
+}
+
+// Sink stores rows of declared tables. Implementations are safe to be used by
+// multiple workers concurrently.
+type Sink interface {
+	// Declare registers a table; it must be called before rows are written.
+	Declare(schema *Schema) error
+	// Write stores a row; the values must match the columns of the table.
+	Write(table string, values ...interface{}) error
+	// Close flushes all rows and releases the sink.
+	Close() error
+}
+
+// Open creates the sink for an --output option. The format is selected by a
+// "csv:", "jsonl:" or "sqlite:" prefix or else by the file extension. An empty
+// output prints "metric:" lines to the console.
+func Open(output string, experiment Experiment) (Sink, error) {
+	if output == "" {
+		return newConsoleSink(), nil
+	}
+	format, path := splitOutput(output)
+	switch format {
+	case "csv":
+		return newCSVSink(path), nil
+	case "jsonl":
+		return newJSONLinesSink(path)
+	case "sqlite":
+		return newSQLiteSink(path, experiment)
+	}
+	return nil, fmt.Errorf("unknown metrics output format of %v, use a .csv, .jsonl or .db file", output)
+}
+
+// splitOutput returns the format and the path of an --output option.
+func splitOutput(output string) (string, string) {
+	format, path, found := strings.Cut(output, ":")
+	if !found || !isFormat(format) {
+		return formatOf(output), output
+	}
+	return format, path
+}
+
+func isFormat(format string) bool {
+	return format == "csv" || format == "jsonl" || format == "sqlite"
+}
+
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".json":
+		return "jsonl"
+	case ".db", ".sqlite", ".sqlite3":
+		return "sqlite"
+	}
+	return ""
+}
+
+// tables keeps the declared schemas of a sink.
+type tables map[string]*Schema
+
+func (t tables) declare(schema *Schema) error {
+	if _, exists := t[schema.Table]; exists {
+		return fmt.Errorf("table %v declared twice", schema.Table)
+	}
+	if len(schema.Columns) == 0 {
+		return fmt.Errorf("table %v has no columns", schema.Table)
+	}
+	t[schema.Table] = schema
+	return nil
+}
+
+func (t tables) get(table string, values []interface{}) (*Schema, error) {
+	schema, exists := t[table]
+	if !exists {
+		return nil, fmt.Errorf("table %v is not declared", table)
+	}
+	if len(values) != len(schema.Columns) {
+		return nil, fmt.Errorf("table %v has %d columns, got %d values", table, len(schema.Columns), len(values))
+	}
+	return schema, nil
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSchemas = []*Schema{
+	{
+		Table: "contract",
+		Columns: []Column{
+			{Name: "contract", Type: Text},
+			{Name: "gas", Type: Integer},
+			{Name: "share", Type: Real},
+		},
+		PrimaryKey: []string{"contract"},
+	},
+	{
+		Table: "distribution",
+		Columns: []Column{
+			{Name: "slots", Type: Integer},
+			{Name: "transactions", Type: Integer},
+		},
+	},
+}
+
+// writeTestRows writes the test tables to the sink of the given output.
+func writeTestRows(t *testing.T, output string) {
+	t.Helper()
+	sink, err := Open(output, Experiment{Type: "test", ChainID: 250, GitDate: "date", GitCommit: "commit"})
+	if err != nil {
+		t.Fatalf("cannot open sink: %v", err)
+	}
+	for _, schema := range testSchemas {
+		if err := sink.Declare(schema); err != nil {
+			t.Fatalf("cannot declare table %v: %v", schema.Table, err)
+		}
+	}
+	rows := []struct {
+		table  string
+		values []interface{}
+	}{
+		{"contract", []interface{}{"0xa", 21000, 0.25}},
+		{"distribution", []interface{}{0, 3}},
+		{"contract", []interface{}{"0xb", uint64(63000), 0.75}},
+	}
+	for _, row := range rows {
+		if err := sink.Write(row.table, row.values...); err != nil {
+			t.Fatalf("cannot write to table %v: %v", row.table, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("cannot close sink: %v", err)
+	}
+}
+
+func readLines(t *testing.T, filename string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read %v: %v", filename, err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestOpenSelectsFormat(t *testing.T) {
+	tests := []struct {
+		output string
+		sink   interface{}
+	}{
+		{output: "", sink: &consoleSink{}},
+		{output: "out.csv", sink: &csvSink{}},
+		{output: "out.jsonl", sink: &jsonLinesSink{}},
+		{output: "out.db", sink: &sqliteSink{}},
+		{output: "jsonl:out.log", sink: &jsonLinesSink{}},
+		{output: "csv:out", sink: &csvSink{}},
+	}
+	for _, test := range tests {
+		t.Run(test.output, func(t *testing.T) {
+			output := test.output
+			if output != "" {
+				// keep the format prefix, place the file in a temporary directory
+				format, path, found := strings.Cut(output, ":")
+				if found {
+					output = format + ":" + filepath.Join(t.TempDir(), path)
+				} else {
+					output = filepath.Join(t.TempDir(), output)
+				}
+			}
+			sink, err := Open(output, Experiment{})
+			if err != nil {
+				t.Fatalf("cannot open sink: %v", err)
+			}
+			defer sink.Close()
+			if reflect.TypeOf(sink) != reflect.TypeOf(test.sink) {
+				t.Errorf("unexpected sink, wanted %T, got %T", test.sink, sink)
+			}
+		})
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "out.txt"), Experiment{}); err == nil {
+		t.Errorf("unknown format was accepted")
+	}
+}
+
+func TestSinkRejectsUndeclaredTablesAndWrongRows(t *testing.T) {
+	sink := newConsoleSink()
+	if err := sink.Declare(testSchemas[1]); err != nil {
+		t.Fatalf("cannot declare table: %v", err)
+	}
+	if err := sink.Declare(testSchemas[1]); err == nil {
+		t.Errorf("table was declared twice")
+	}
+	if err := sink.Write("contract", "0xa", 1, 0.5); err == nil {
+		t.Errorf("row of undeclared table was accepted")
+	}
+	if err := sink.Write("distribution", 1); err == nil {
+		t.Errorf("row with missing values was accepted")
+	}
+}
+
+func TestCSVSinkWritesTablesToFiles(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.csv")
+	writeTestRows(t, filename)
+
+	want := []string{"contract,gas,share", "0xa,21000,0.25", "0xb,63000,0.75"}
+	if got := readLines(t, filename); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected content of first table, wanted %q, got %q", want, got)
+	}
+	want = []string{"slots,transactions", "0,3"}
+	if got := readLines(t, strings.TrimSuffix(filename, ".csv")+"_distribution.csv"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected content of second table, wanted %q, got %q", want, got)
+	}
+}
+
+func TestJSONLinesSinkWritesTableField(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writeTestRows(t, filename)
+
+	want := []string{
+		`{"contract":"0xa","gas":21000,"share":0.25,"table":"contract"}`,
+		`{"slots":0,"table":"distribution","transactions":3}`,
+		`{"contract":"0xb","gas":63000,"share":0.75,"table":"contract"}`,
+	}
+	if got := readLines(t, filename); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected content, wanted %q, got %q", want, got)
+	}
+}
+
+func TestSQLiteSinkRecreatesTables(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.db")
+	// a second run replaces the rows of the first one
+	writeTestRows(t, filename)
+	writeTestRows(t, filename)
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	var experiments int
+	if err := db.QueryRow("SELECT COUNT(*) FROM experiment WHERE type = 'test' AND chainid = '250';").Scan(&experiments); err != nil {
+		t.Fatalf("cannot read experiments: %v", err)
+	}
+	if experiments != 2 {
+		t.Errorf("unexpected number of experiments, wanted 2, got %d", experiments)
+	}
+
+	rows, err := db.Query("SELECT contract, gas, share FROM contract ORDER BY contract;")
+	if err != nil {
+		t.Fatalf("cannot read table: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var contract string
+		var gas int64
+		var share float64
+		if err := rows.Scan(&contract, &gas, &share); err != nil {
+			t.Fatalf("cannot read row: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s,%d,%v", contract, gas, share))
+	}
+	want := []string{"0xa,21000,0.25", "0xb,63000,0.75"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected rows, wanted %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// number of rows inserted per database transaction
+const sqliteBatchSize = 10000
+
+// sqliteSink writes tables into a SQLite database. Declared tables are
+// recreated, and the experiment is appended to the experiment table.
+type sqliteSink struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+	tables  tables
+	inserts map[string]string
+}
+
+func newSQLiteSink(path string, experiment Experiment) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open metrics database %v: %v", path, err)
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS experiment (type TEXT, creation_date TEXT, chainid TEXT, git_date TEXT, git_commit TEXT);"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create experiment table in %v: %v", path, err)
+	}
+	_, err = db.Exec("INSERT INTO experiment VALUES (?, ?, ?, ?, ?);",
+		experiment.Type,
+		time.Now().Format(time.UnixDate),
+		fmt.Sprint(experiment.ChainID),
+		experiment.GitDate,
+		experiment.GitCommit)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot record experiment in %v: %v", path, err)
+	}
+	return &sqliteSink{
+		db:      db,
+		tables:  tables{},
+		inserts: map[string]string{},
+	}, nil
+}
+
+func (s *sqliteSink) Declare(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tables.declare(schema); err != nil {
+		return err
+	}
+	columns := make([]string, len(schema.Columns))
+	placeholders := make([]string, len(schema.Columns))
+	for i, column := range schema.Columns {
+		columns[i] = fmt.Sprintf("%s %s NOT NULL", column.Name, column.Type)
+		placeholders[i] = "?"
+	}
+	if len(schema.PrimaryKey) > 0 {
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(schema.PrimaryKey, ", ")))
+	}
+	statements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", schema.Table),
+		fmt.Sprintf("CREATE TABLE %s (%s);", schema.Table, strings.Join(columns, ", ")),
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("cannot create table %v: %v", schema.Table, err)
+		}
+	}
+	s.inserts[schema.Table] = fmt.Sprintf("INSERT INTO %s VALUES (%s);", schema.Table, strings.Join(placeholders, ", "))
+	return nil
+}
+
+func (s *sqliteSink) Write(table string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.tables.get(table, values); err != nil {
+		return err
+	}
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+	if _, err := s.tx.Exec(s.inserts[table], values...); err != nil {
+		return fmt.Errorf("cannot insert into %v: %v", table, err)
+	}
+	s.pending++
+	if s.pending >= sqliteBatchSize {
+		return s.commit()
+	}
+	return nil
+}
+
+// commit ends the current batch of inserts.
+func (s *sqliteSink) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	s.pending = 0
+	return err
+}
+
+func (s *sqliteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.commit(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,308 @@
+// Package progress reports the progress of long-running substate-cli
+// commands. A Reporter periodically prints the current block, throughput and
+// estimated time of arrival as human-readable lines, or writes them as JSON
+// heartbeats to a file for monitoring. The progress and further metrics of a
+// command can be scraped from a Prometheus metrics endpoint.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// command line options
+var (
+	IntervalFlag = cli.DurationFlag{
+		Name:  "progress-interval",
+		Usage: "report progress in the given interval, e.g. 30s (default: off, or 10s with --progress-file)",
+	}
+	FileFlag = cli.StringFlag{
+		Name:  "progress-file",
+		Usage: "append progress reports as JSON heartbeats to the given file",
+	}
+)
+
+// default interval of heartbeats written to a progress file
+const defaultFileInterval = 10 * time.Second
+
+// Unbounded is the last block of a range without a known end.
+const Unbounded = math.MaxUint64
+
+// Heartbeat is a single progress report.
+type Heartbeat struct {
+	Time         time.Time `json:"time"`
+	Name         string    `json:"name"`
+	First        uint64    `json:"first"`
+	Last         uint64    `json:"last,omitempty"`
+	Block        uint64    `json:"block"`
+	Blocks       uint64    `json:"blocks"`
+	Transactions uint64    `json:"transactions"`
+	Gas          uint64    `json:"gas"`
+	Elapsed      float64   `json:"elapsedSeconds"`
+	BlockRate    float64   `json:"blocksPerSecond"`
+	TxRate       float64   `json:"txPerSecond"`
+	GasRate      float64   `json:"gasPerSecond"`
+	Percent      *float64  `json:"percent,omitempty"`
+	ETA          *float64  `json:"etaSeconds,omitempty"`
+	Done         bool      `json:"done"`
+}
+
+// Reporter collects the progress of a command over an inclusive block range
+// and reports it periodically. Rates are measured over the last interval, the
+// ETA is estimated from the average block rate since the start. A nil
+// Reporter is disabled.
+type Reporter struct {
+	mu    sync.Mutex
+	name  string
+	first uint64
+	last  uint64
+	start time.Time
+
+	started bool
+	block   uint64
+	blocks  uint64
+	txs     uint64
+	gas     uint64
+
+	// state at the previous report
+	lastTime   time.Time
+	lastBlocks uint64
+	lastTxs    uint64
+	lastGas    uint64
+
+	file   *os.File
+	writer io.Writer
+	stop   chan struct{}
+	done   sync.WaitGroup
+
+	metrics      *MetricsServer
+	ownsMetrics  bool
+	printsReport bool
+}
+
+// NewReporter creates a reporter configured by the progress and metrics
+// flags in ctx. The progress is exported by the running metrics server, which
+// is started if needed. It returns nil if progress reporting and the metrics
+// endpoint are disabled.
+func NewReporter(ctx *cli.Context, name string, first, last uint64) (*Reporter, error) {
+	interval := ctx.Duration(IntervalFlag.Name)
+	filename := ctx.String(FileFlag.Name)
+	if filename != "" && !ctx.IsSet(IntervalFlag.Name) {
+		interval = defaultFileInterval
+	}
+
+	activeMu.Lock()
+	running := activeMetrics != nil
+	activeMu.Unlock()
+	metrics, err := StartMetricsServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 && metrics == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	r := &Reporter{
+		name:     name,
+		first:    first,
+		last:     last,
+		start:    now,
+		lastTime: now,
+		writer:   os.Stdout,
+		stop:     make(chan struct{}),
+
+		metrics:      metrics,
+		ownsMetrics:  metrics != nil && !running,
+		printsReport: interval > 0,
+	}
+	r.registerMetrics()
+	if !r.printsReport {
+		return r, nil
+	}
+	if filename != "" {
+		file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			r.closeMetrics()
+			return nil, fmt.Errorf("cannot open progress file %v: %v", filename, err)
+		}
+		r.file = file
+		r.writer = file
+	}
+
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(false)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// Metrics returns the metrics server exporting the progress, or nil if the
+// metrics endpoint is disabled.
+func (r *Reporter) Metrics() *MetricsServer {
+	if r == nil {
+		return nil
+	}
+	return r.metrics
+}
+
+// registerMetrics exports the progress on the metrics server.
+func (r *Reporter) registerMetrics() {
+	read := func(value *uint64) func() float64 {
+		return func() float64 {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return float64(*value)
+		}
+	}
+	r.metrics.Counter("substate_cli_blocks_total", "Number of processed blocks.", read(&r.blocks))
+	r.metrics.Counter("substate_cli_transactions_total", "Number of processed transactions.", read(&r.txs))
+	r.metrics.Counter("substate_cli_gas_total", "Gas used by the processed transactions as recorded.", read(&r.gas))
+	r.metrics.Gauge("substate_cli_block", "Latest processed block.", read(&r.block))
+	r.metrics.Gauge("substate_cli_first_block", "First block of the processed block range.", read(&r.first))
+	if r.last != Unbounded {
+		r.metrics.Gauge("substate_cli_last_block", "Last block of the processed block range.", read(&r.last))
+	}
+}
+
+func (r *Reporter) closeMetrics() error {
+	if r.ownsMetrics {
+		return r.metrics.Close()
+	}
+	return nil
+}
+
+// Add records the progress of a number of blocks, their transactions and
+// gas. The block is the latest block processed.
+func (r *Reporter) Add(block uint64, blocks uint64, txs uint64, gas uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started || block > r.block {
+		r.block = block
+		r.started = true
+	}
+	r.blocks += blocks
+	r.txs += txs
+	r.gas += gas
+}
+
+// report prints or writes a heartbeat.
+func (r *Reporter) report(done bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	interval := now.Sub(r.lastTime).Seconds()
+	elapsed := now.Sub(r.start).Seconds()
+	if done {
+		// the final report shows the average rates
+		interval = elapsed
+		r.lastBlocks, r.lastTxs, r.lastGas = 0, 0, 0
+	}
+	if interval <= 0 {
+		interval = math.SmallestNonzeroFloat64
+	}
+	hb := Heartbeat{
+		Time:         now,
+		Name:         r.name,
+		First:        r.first,
+		Block:        r.block,
+		Blocks:       r.blocks,
+		Transactions: r.txs,
+		Gas:          r.gas,
+		Elapsed:      elapsed,
+		BlockRate:    float64(r.blocks-r.lastBlocks) / interval,
+		TxRate:       float64(r.txs-r.lastTxs) / interval,
+		GasRate:      float64(r.gas-r.lastGas) / interval,
+		Done:         done,
+	}
+	if !r.started {
+		hb.Block = r.first
+	}
+	if r.last != Unbounded {
+		hb.Last = r.last
+		total := float64(r.last-r.first) + 1
+		percent := 100 * float64(r.blocks) / total
+		if percent > 100 {
+			percent = 100
+		}
+		hb.Percent = &percent
+		if r.blocks > 0 && elapsed > 0 {
+			eta := (total - float64(r.blocks)) / (float64(r.blocks) / elapsed)
+			if eta < 0 {
+				eta = 0
+			}
+			hb.ETA = &eta
+		}
+	}
+	r.lastTime, r.lastBlocks, r.lastTxs, r.lastGas = now, r.blocks, r.txs, r.gas
+
+	if r.file != nil {
+		data, err := json.Marshal(hb)
+		if err == nil {
+			_, err = fmt.Fprintf(r.writer, "%s\n", data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: cannot write progress: %v\n", r.name, err)
+		}
+		return
+	}
+	fmt.Fprintln(r.writer, hb.String())
+}
+
+// String formats a heartbeat as a human-readable line.
+func (hb *Heartbeat) String() string {
+	line := fmt.Sprintf("%v: progress: block %v", hb.Name, hb.Block)
+	if hb.Percent != nil {
+		line += fmt.Sprintf(" (%.1f%%)", *hb.Percent)
+	}
+	elapsed := time.Duration(hb.Elapsed * float64(time.Second)).Round(time.Second)
+	line += fmt.Sprintf(", elapsed %v, %.2f blk/s, %.2f tx/s, %.4g gas/s", elapsed, hb.BlockRate, hb.TxRate, hb.GasRate)
+	if hb.Done {
+		line += ", done"
+	} else if hb.ETA != nil {
+		line += fmt.Sprintf(", ETA %v", time.Duration(*hb.ETA*float64(time.Second)).Round(time.Second))
+	}
+	return line
+}
+
+// Close stops the periodic reports and writes the final report. The metrics
+// server is stopped if it was started by the reporter.
+func (r *Reporter) Close() error {
+	if r == nil {
+		return nil
+	}
+	err := r.closeMetrics()
+	if !r.printsReport {
+		return err
+	}
+	close(r.stop)
+	r.done.Wait()
+	r.report(true)
+	if r.file != nil {
+		if closeErr := r.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
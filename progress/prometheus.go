@@ -0,0 +1,188 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// MetricsAddrFlag enables the Prometheus metrics endpoint.
+var MetricsAddrFlag = cli.StringFlag{
+	Name:  "metrics-addr",
+	Usage: "serve Prometheus metrics on http://<address>/metrics, e.g. localhost:9101",
+}
+
+// metric is a counter or gauge whose samples are read on every scrape.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	label   string
+	samples func() map[string]float64
+}
+
+// MetricsServer exposes metrics in the Prometheus text format on /metrics.
+// The values are read from functions when the endpoint is scraped, so the
+// commands keep their own counters. A nil MetricsServer is disabled.
+type MetricsServer struct {
+	mu       sync.Mutex
+	metrics  map[string]*metric
+	server   *http.Server
+	listener net.Listener
+}
+
+// the metrics server of the running command
+var (
+	activeMu      sync.Mutex
+	activeMetrics *MetricsServer
+)
+
+// StartMetricsServer starts the metrics server configured by the flags in
+// ctx, or returns the server already running. It returns nil if the metrics
+// endpoint is disabled.
+func StartMetricsServer(ctx *cli.Context) (*MetricsServer, error) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	if activeMetrics != nil {
+		return activeMetrics, nil
+	}
+	address := ctx.String(MetricsAddrFlag.Name)
+	if address == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serve metrics on %v: %v", address, err)
+	}
+	s := &MetricsServer{metrics: map[string]*metric{}, listener: listener}
+	s.registerRuntimeMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(listener)
+	fmt.Printf("metrics: serving on http://%v/metrics\n", listener.Addr())
+	activeMetrics = s
+	return s, nil
+}
+
+func (s *MetricsServer) register(m *metric) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics[m.name] = m
+}
+
+// Counter registers a counter, replacing a metric of the same name.
+func (s *MetricsServer) Counter(name string, help string, value func() float64) {
+	s.register(&metric{name: name, help: help, kind: "counter", samples: func() map[string]float64 {
+		return map[string]float64{"": value()}
+	}})
+}
+
+// Gauge registers a gauge, replacing a metric of the same name.
+func (s *MetricsServer) Gauge(name string, help string, value func() float64) {
+	s.register(&metric{name: name, help: help, kind: "gauge", samples: func() map[string]float64 {
+		return map[string]float64{"": value()}
+	}})
+}
+
+// CounterVec registers a counter with one sample per value of a label.
+func (s *MetricsServer) CounterVec(name string, help string, label string, values func() map[string]float64) {
+	s.register(&metric{name: name, help: help, kind: "counter", label: label, samples: values})
+}
+
+func (s *MetricsServer) registerRuntimeMetrics() {
+	var mu sync.Mutex
+	var stats runtime.MemStats
+	var last time.Time
+	memStats := func() *runtime.MemStats {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) > time.Second {
+			runtime.ReadMemStats(&stats)
+			last = time.Now()
+		}
+		return &stats
+	}
+	start := time.Now()
+	s.Gauge("substate_cli_uptime_seconds", "Time since the metrics server started.", func() float64 {
+		return time.Since(start).Seconds()
+	})
+	s.Gauge("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	s.Gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", func() float64 {
+		return float64(memStats().Alloc)
+	})
+	s.Gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", func() float64 {
+		return float64(memStats().HeapInuse)
+	})
+	s.Gauge("go_memstats_sys_bytes", "Number of bytes obtained from the system.", func() float64 {
+		return float64(memStats().Sys)
+	})
+	s.Counter("go_gc_cycles_total", "Number of completed GC cycles.", func() float64 {
+		return float64(memStats().NumGC)
+	})
+}
+
+// escapeLabel escapes a label value of the Prometheus text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format.
+func (s *MetricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	metrics := make([]*metric, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		metrics = append(metrics, m)
+	}
+	s.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", m.name, m.kind)
+		samples := m.samples()
+		labels := make([]string, 0, len(samples))
+		for label := range samples {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			value := strconv.FormatFloat(samples[label], 'g', -1, 64)
+			if m.label == "" {
+				fmt.Fprintf(&buf, "%s %s\n", m.name, value)
+			} else {
+				fmt.Fprintf(&buf, "%s{%s=\"%s\"} %s\n", m.name, m.label, escapeLabel(label), value)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// Close stops the metrics server.
+func (s *MetricsServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	activeMu.Lock()
+	if activeMetrics == s {
+		activeMetrics = nil
+	}
+	activeMu.Unlock()
+	return s.server.Close()
+}
@@ -0,0 +1,107 @@
+package progress
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// AttachTaskPool reports the progress of a task pool configured by the
+// progress flags of the pool's context. The block and task functions of the
+// pool are wrapped to count every block together with the transactions and
+// recorded gas of its executed substates once they are completed, so both
+// functions must be set before. The returned reporter has to be closed after
+// the execution.
+func AttachTaskPool(pool *substate.SubstateTaskPool) (*Reporter, error) {
+	reporter, err := NewReporter(pool.Ctx, pool.Name, pool.First, pool.Last)
+	if err != nil || reporter == nil {
+		return nil, err
+	}
+	blockFunc := pool.BlockFunc
+	taskFunc := pool.TaskFunc
+
+	// number of tasks of a block not completed yet
+	var mu sync.Mutex
+	pending := map[uint64]int{}
+
+	pool.BlockFunc = func(block uint64, transactions map[int]*substate.Substate, pool *substate.SubstateTaskPool) error {
+		if blockFunc != nil {
+			if err := blockFunc(block, transactions, pool); err != nil {
+				return err
+			}
+		}
+		var txs, gas uint64
+		for _, st := range transactions {
+			if IsSkipped(pool, st) {
+				continue
+			}
+			txs++
+			gas += st.Result.GasUsed
+		}
+		if taskFunc == nil || txs == 0 {
+			// the block is completed, either by the block function or
+			// because no task is run
+			reporter.Add(block, 1, txs, gas)
+			return nil
+		}
+		mu.Lock()
+		pending[block] = int(txs)
+		mu.Unlock()
+		return nil
+	}
+	if taskFunc != nil {
+		pool.TaskFunc = func(block uint64, tx int, st *substate.Substate, pool *substate.SubstateTaskPool) error {
+			if err := taskFunc(block, tx, st, pool); err != nil {
+				return err
+			}
+			mu.Lock()
+			pending[block]--
+			var blocks uint64
+			if pending[block] == 0 {
+				delete(pending, block)
+				blocks = 1
+			}
+			mu.Unlock()
+			reporter.Add(block, blocks, 1, st.Result.GasUsed)
+			return nil
+		}
+	}
+	return reporter, nil
+}
+
+// ExecuteTaskPool executes a task pool and reports its progress.
+func ExecuteTaskPool(pool *substate.SubstateTaskPool) error {
+	reporter, err := AttachTaskPool(pool)
+	if err != nil {
+		return err
+	}
+	err = pool.Execute()
+	if closeErr := reporter.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// IsSkipped applies the transaction filters of a task pool, like
+// SubstateTaskPool.ExecuteBlock does before running a task.
+func IsSkipped(pool *substate.SubstateTaskPool, recording *substate.Substate) bool {
+	alloc := recording.InputAlloc
+	to := recording.Message.To
+	if pool.SkipTransferTxs && to != nil {
+		// skip regular transactions (ETH transfer)
+		if account, exist := alloc[*to]; !exist || len(account.Code) == 0 {
+			return true
+		}
+	}
+	if pool.SkipCallTxs && to != nil {
+		// skip CALL transactions with contract bytecode
+		if account, exist := alloc[*to]; exist && len(account.Code) > 0 {
+			return true
+		}
+	}
+	if pool.SkipCreateTxs && to == nil {
+		// skip CREATE transactions
+		return true
+	}
+	return false
+}
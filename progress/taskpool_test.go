@@ -0,0 +1,140 @@
+package progress
+
+import (
+	"flag"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/substate"
+	"github.com/urfave/cli/v2"
+)
+
+func newTestPool(t *testing.T, taskFunc substate.SubstateTaskFunc) *substate.SubstateTaskPool {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := IntervalFlag.Apply(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Set(IntervalFlag.Name, time.Hour.String()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := cli.NewContext(cli.NewApp(), set, nil)
+	return &substate.SubstateTaskPool{Name: "test", TaskFunc: taskFunc, First: 1, Last: 2, Ctx: ctx}
+}
+
+func newTestSubstate(to *common.Address, code []byte, gas uint64) *substate.Substate {
+	alloc := substate.SubstateAlloc{}
+	if to != nil {
+		alloc[*to] = substate.NewSubstateAccount(0, big.NewInt(0), code)
+	}
+	return &substate.Substate{
+		InputAlloc: alloc,
+		Message:    &substate.SubstateMessage{To: to},
+		Result:     &substate.SubstateResult{GasUsed: gas},
+	}
+}
+
+func checkProgress(t *testing.T, r *Reporter, blocks, txs, gas uint64) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.blocks != blocks || r.txs != txs || r.gas != gas {
+		t.Errorf("unexpected progress, wanted %d blocks, %d txs and %d gas, got %d, %d and %d", blocks, txs, gas, r.blocks, r.txs, r.gas)
+	}
+}
+
+func TestAttachTaskPoolReportsCompletedTasks(t *testing.T) {
+	contract, account := common.Address{0xc}, common.Address{0xa}
+	transactions := map[int]*substate.Substate{
+		0: newTestSubstate(&contract, []byte{0x00}, 100),
+		1: newTestSubstate(&account, nil, 21000),
+		2: newTestSubstate(nil, nil, 50000),
+	}
+	pool := newTestPool(t, func(uint64, int, *substate.Substate, *substate.SubstateTaskPool) error { return nil })
+	pool.SkipTransferTxs = true
+	reporter, err := AttachTaskPool(pool)
+	if err != nil {
+		t.Fatalf("cannot attach task pool: %v", err)
+	}
+	reporter.writer = io.Discard
+	defer reporter.Close()
+
+	if err := pool.BlockFunc(1, transactions, pool); err != nil {
+		t.Fatalf("block function failed: %v", err)
+	}
+	// nothing is reported before the tasks are completed
+	checkProgress(t, reporter, 0, 0, 0)
+	if err := pool.TaskFunc(1, 0, transactions[0], pool); err != nil {
+		t.Fatalf("task failed: %v", err)
+	}
+	checkProgress(t, reporter, 0, 1, 100)
+	// the transfer is skipped, so the block is completed by the create
+	if err := pool.TaskFunc(1, 2, transactions[2], pool); err != nil {
+		t.Fatalf("task failed: %v", err)
+	}
+	checkProgress(t, reporter, 1, 2, 50100)
+
+	// a block without executed transactions is completed immediately
+	if err := pool.BlockFunc(2, map[int]*substate.Substate{0: transactions[1]}, pool); err != nil {
+		t.Fatalf("block function failed: %v", err)
+	}
+	checkProgress(t, reporter, 2, 2, 50100)
+}
+
+func TestAttachTaskPoolReportsBlockFunctions(t *testing.T) {
+	pool := newTestPool(t, nil)
+	pool.SkipCreateTxs = true
+	executed := false
+	pool.BlockFunc = func(uint64, map[int]*substate.Substate, *substate.SubstateTaskPool) error {
+		executed = true
+		return nil
+	}
+	reporter, err := AttachTaskPool(pool)
+	if err != nil {
+		t.Fatalf("cannot attach task pool: %v", err)
+	}
+	reporter.writer = io.Discard
+	defer reporter.Close()
+
+	account := common.Address{0xa}
+	transactions := map[int]*substate.Substate{
+		0: newTestSubstate(&account, nil, 21000),
+		1: newTestSubstate(nil, nil, 50000),
+	}
+	if err := pool.BlockFunc(1, transactions, pool); err != nil {
+		t.Fatalf("block function failed: %v", err)
+	}
+	if !executed {
+		t.Errorf("block function of the pool was not executed")
+	}
+	if pool.TaskFunc != nil {
+		t.Errorf("task function was set")
+	}
+	checkProgress(t, reporter, 1, 1, 21000)
+}
+
+func TestIsSkipped(t *testing.T) {
+	contract, account := common.Address{0xc}, common.Address{0xa}
+	call := newTestSubstate(&contract, []byte{0x00}, 0)
+	transfer := newTestSubstate(&account, nil, 0)
+	create := newTestSubstate(nil, nil, 0)
+	tests := []struct {
+		pool    substate.SubstateTaskPool
+		skipped []bool // call, transfer, create
+	}{
+		{pool: substate.SubstateTaskPool{}, skipped: []bool{false, false, false}},
+		{pool: substate.SubstateTaskPool{SkipTransferTxs: true}, skipped: []bool{false, true, false}},
+		{pool: substate.SubstateTaskPool{SkipCallTxs: true}, skipped: []bool{true, false, false}},
+		{pool: substate.SubstateTaskPool{SkipCreateTxs: true}, skipped: []bool{false, false, true}},
+	}
+	for _, test := range tests {
+		for i, st := range []*substate.Substate{call, transfer, create} {
+			if got := IsSkipped(&test.pool, st); got != test.skipped[i] {
+				t.Errorf("unexpected result for transaction %d with transfer %v, call %v, create %v skipped: got %v", i, test.pool.SkipTransferTxs, test.pool.SkipCallTxs, test.pool.SkipCreateTxs, got)
+			}
+		}
+	}
+}
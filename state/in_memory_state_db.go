@@ -5,6 +5,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/substate"
@@ -25,7 +26,7 @@ type StateDB interface {
 // MakeInMemoryStateDB creates a StateDB instance reflecting the state
 // captured by the provided Substate allocation.
 func MakeInMemoryStateDB(alloc *substate.SubstateAlloc, block uint64) StateDB {
-	return &inMemoryStateDB{alloc: alloc, state: makeSnapshot(nil, 0), touchedSlots: map[slot]int{}, createdAccount: map[common.Address]int{}, blockNum: block}
+	return &inMemoryStateDB{alloc: alloc, state: makeSnapshot(nil, 0), touchedSlots: map[slot]int{}, createdAccount: map[common.Address]int{}, preimages: map[common.Hash][]byte{}, blockNum: block}
 }
 
 // inMemoryStateDB implements the interface of a state.StateDB and can be
@@ -36,7 +37,13 @@ type inMemoryStateDB struct {
 	snapshot_counter int
 	touchedSlots     map[slot]int
 	createdAccount   map[common.Address]int
+	preimages        map[common.Hash][]byte
 	blockNum         uint64
+
+	// the committed state trie of the pre-state, built by the first call of
+	// IntermediateRoot
+	preState state.Database
+	preRoot  common.Hash
 }
 
 type slot struct {
@@ -53,6 +60,7 @@ type snapshot struct {
 	nonces            map[common.Address]uint64
 	codes             map[common.Address][]byte
 	suicided          map[common.Address]int // Set of destructed accounts
+	created           map[common.Address]int // Set of (re-)created accounts
 	storage           map[slot]common.Hash
 	accessed_accounts map[common.Address]int
 	accessed_slots    map[slot]int
@@ -73,6 +81,7 @@ func makeSnapshot(parent *snapshot, id int) *snapshot {
 		nonces:            map[common.Address]uint64{},
 		codes:             map[common.Address][]byte{},
 		suicided:          map[common.Address]int{},
+		created:           map[common.Address]int{},
 		storage:           map[slot]common.Hash{},
 		accessed_accounts: map[common.Address]int{},
 		accessed_slots:    map[slot]int{},
@@ -88,7 +97,8 @@ func (db *inMemoryStateDB) CreateAccount(addr common.Address) {
 	if db.blockNum > 46051750 {
 		db.createdAccount[addr] = 0
 	}
-	// ignored
+	// only recorded for iterating the storage of the account
+	db.state.created[addr] = 0
 }
 
 func (db *inMemoryStateDB) SubBalance(addr common.Address, value *big.Int) {
@@ -310,16 +320,81 @@ func (db *inMemoryStateDB) AddLog(log *types.Log) {
 	db.state.logs = append(db.state.logs, log)
 }
 
-func (db *inMemoryStateDB) AddPreimage(common.Hash, []byte) {
-	// ignored
-	panic("not implemented")
+func (db *inMemoryStateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	if _, exists := db.preimages[hash]; !exists {
+		db.preimages[hash] = common.CopyBytes(preimage)
+	}
+}
+
+// Preimages returns the preimages recorded by AddPreimage.
+func (db *inMemoryStateDB) Preimages() map[common.Hash][]byte {
+	return db.preimages
 }
 
-func (db *inMemoryStateDB) ForEachStorage(common.Address, func(common.Hash, common.Hash) bool) error {
-	panic("not implemented")
+// ForEachStorage iterates over the non-zero storage slots of the given
+// account in the current state, i.e. the slots of the pre-state and the slots
+// written by the transaction. Destructed accounts have no storage, re-created
+// accounts only the slots written since.
+func (db *inMemoryStateDB) ForEachStorage(addr common.Address, cb func(common.Hash, common.Hash) bool) error {
+	if db.HasSuicided(addr) {
+		return nil
+	}
+	for key := range db.storageKeys(addr) {
+		value := db.lookupState(addr, key)
+		if value == (common.Hash{}) {
+			continue
+		}
+		if !cb(key, value) {
+			return nil
+		}
+	}
 	return nil
 }
 
+// storageKeys returns the keys of the pre-state slots of an account, unless it
+// was re-created, and of the slots accessed by the transaction.
+func (db *inMemoryStateDB) storageKeys(addr common.Address) map[common.Hash]int {
+	keys := map[common.Hash]int{}
+	if account, exists := (*db.alloc)[addr]; exists && !db.isCreated(addr) {
+		for key := range account.Storage {
+			keys[key] = 0
+		}
+	}
+	for state := db.state; state != nil; state = state.parent {
+		for slot := range state.storage {
+			if slot.addr == addr {
+				keys[slot.key] = 0
+			}
+		}
+	}
+	return keys
+}
+
+// isCreated reports whether the account was (re-)created by the transaction.
+func (db *inMemoryStateDB) isCreated(addr common.Address) bool {
+	for state := db.state; state != nil; state = state.parent {
+		if _, exists := state.created[addr]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupState returns the current value of a storage slot without recording
+// the access.
+func (db *inMemoryStateDB) lookupState(addr common.Address, key common.Hash) common.Hash {
+	slot := slot{addr, key}
+	for state := db.state; state != nil; state = state.parent {
+		if val, exists := state.storage[slot]; exists {
+			return val
+		}
+	}
+	if account, exists := (*db.alloc)[addr]; exists && !db.isCreated(addr) {
+		return account.Storage[key]
+	}
+	return common.Hash{}
+}
+
 func (db *inMemoryStateDB) Prepare(common.Hash, int) {
 	// nothing to do ...
 }
@@ -327,9 +402,62 @@ func (db *inMemoryStateDB) Prepare(common.Hash, int) {
 func (db *inMemoryStateDB) Finalise(bool) {
 	// nothing to do ...
 }
+
+// IntermediateRoot computes the state root of all accounts covered by the
+// substate. Like for an OffTheChainStateDB, the pre-state is committed first
+// and only the accounts touched by the transaction are applied on top, such
+// that untouched empty accounts are kept.
 func (db *inMemoryStateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
-	panic("not implemented")
-	return common.Hash{}
+	db.Finalise(deleteEmptyObjects)
+	if db.preState == nil {
+		preState := MakeOffTheChainStateDB(*db.alloc)
+		db.preState, db.preRoot = preState.Database(), preState.IntermediateRoot(false)
+	}
+	trie, err := state.New(db.preRoot, db.preState, nil)
+	if err != nil {
+		panic(fmt.Errorf("error opening the pre-state in IntermediateRoot(): %v", err))
+	}
+
+	// collect the accounts and slots modified by non-reverted calls
+	touched := map[common.Address]map[common.Hash]int{}
+	for state := db.state; state != nil; state = state.parent {
+		for addr := range state.touched {
+			if _, exists := touched[addr]; !exists {
+				touched[addr] = map[common.Hash]int{}
+			}
+		}
+		for addr := range state.suicided {
+			if _, exists := touched[addr]; !exists {
+				touched[addr] = map[common.Hash]int{}
+			}
+		}
+	}
+	for state := db.state; state != nil; state = state.parent {
+		for slot := range state.storage {
+			if keys, exists := touched[slot.addr]; exists {
+				keys[slot.key] = 0
+			}
+		}
+	}
+
+	for addr, keys := range touched {
+		if db.HasSuicided(addr) {
+			trie.Suicide(addr)
+			continue
+		}
+		if db.isCreated(addr) {
+			// a re-created account does not keep the storage of the pre-state
+			trie.CreateAccount(addr)
+		}
+		code := db.GetCode(addr)
+		trie.SetPrehashedCode(addr, getHash(addr, code), code)
+		trie.SetNonce(addr, db.GetNonce(addr))
+		trie.SetBalance(addr, db.GetBalance(addr))
+		for key := range keys {
+			trie.SetState(addr, key, db.lookupState(addr, key))
+		}
+	}
+	return trie.IntermediateRoot(deleteEmptyObjects)
 }
 
 func (db *inMemoryStateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
@@ -391,10 +519,14 @@ func (db *inMemoryStateDB) GetEffects() substate.SubstateAlloc {
 }
 
 func (db *inMemoryStateDB) GetSubstatePostAlloc() substate.SubstateAlloc {
-	// Use the pre-alloc ...
-	res := *db.alloc
+	// Use the pre-alloc and extend it with the effects.
+	return db.collectPostAlloc(*db.alloc)
+}
 
-	// ... and extend with effects
+// collectPostAlloc applies the effects of the transaction to the given
+// allocation, which is modified in place.
+func (db *inMemoryStateDB) collectPostAlloc(res substate.SubstateAlloc) substate.SubstateAlloc {
+	// extend with effects
 	for key, value := range db.GetEffects() {
 		entry, exists := res[key]
 		if !exists {
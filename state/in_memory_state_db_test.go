@@ -0,0 +1,144 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+var (
+	addrA     = common.Address{0xa}
+	addrB     = common.Address{0xb}
+	addrC     = common.Address{0xc}
+	addrEmpty = common.Address{0xe}
+)
+
+func newAccount(nonce uint64, balance int64, code []byte, storage map[common.Hash]common.Hash) *substate.SubstateAccount {
+	account := substate.NewSubstateAccount(nonce, big.NewInt(balance), code)
+	for key, value := range storage {
+		account.Storage[key] = value
+	}
+	return account
+}
+
+func makeTestAlloc() substate.SubstateAlloc {
+	return substate.SubstateAlloc{
+		addrA:     newAccount(1, 100, nil, nil),
+		addrB:     newAccount(0, 50, nil, nil),
+		addrC:     newAccount(1, 0, []byte{0x60, 0x00}, map[common.Hash]common.Hash{{1}: {1}, {2}: {2}}),
+		addrEmpty: newAccount(0, 0, nil, nil),
+	}
+}
+
+func TestInMemoryStateDBIntermediateRoot(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(db vm.StateDB)
+		// the post-state, with empty accounts not touched by the transaction
+		want substate.SubstateAlloc
+	}{
+		{
+			name:  "no modification",
+			apply: func(db vm.StateDB) {},
+			want:  makeTestAlloc(),
+		},
+		{
+			name: "transfer",
+			apply: func(db vm.StateDB) {
+				db.SetNonce(addrA, 2)
+				db.SubBalance(addrA, big.NewInt(10))
+				db.AddBalance(addrB, big.NewInt(10))
+			},
+			want: substate.SubstateAlloc{
+				addrA:     newAccount(2, 90, nil, nil),
+				addrB:     newAccount(0, 60, nil, nil),
+				addrC:     newAccount(1, 0, []byte{0x60, 0x00}, map[common.Hash]common.Hash{{1}: {1}, {2}: {2}}),
+				addrEmpty: newAccount(0, 0, nil, nil),
+			},
+		},
+		{
+			name: "storage update",
+			apply: func(db vm.StateDB) {
+				db.SetState(addrC, common.Hash{1}, common.Hash{})
+				db.SetState(addrC, common.Hash{3}, common.Hash{3})
+			},
+			want: substate.SubstateAlloc{
+				addrA:     newAccount(1, 100, nil, nil),
+				addrB:     newAccount(0, 50, nil, nil),
+				addrC:     newAccount(1, 0, []byte{0x60, 0x00}, map[common.Hash]common.Hash{{2}: {2}, {3}: {3}}),
+				addrEmpty: newAccount(0, 0, nil, nil),
+			},
+		},
+		{
+			name: "reverted modification",
+			apply: func(db vm.StateDB) {
+				snapshot := db.Snapshot()
+				db.AddBalance(addrEmpty, big.NewInt(1))
+				db.SetState(addrC, common.Hash{1}, common.Hash{7})
+				db.RevertToSnapshot(snapshot)
+			},
+			want: makeTestAlloc(),
+		},
+		{
+			name: "touched empty account",
+			apply: func(db vm.StateDB) {
+				db.SetNonce(addrEmpty, 0)
+			},
+			want: substate.SubstateAlloc{
+				addrA: newAccount(1, 100, nil, nil),
+				addrB: newAccount(0, 50, nil, nil),
+				addrC: newAccount(1, 0, []byte{0x60, 0x00}, map[common.Hash]common.Hash{{1}: {1}, {2}: {2}}),
+			},
+		},
+		{
+			name: "destroyed account",
+			apply: func(db vm.StateDB) {
+				db.Suicide(addrC)
+			},
+			want: substate.SubstateAlloc{
+				addrA:     newAccount(1, 100, nil, nil),
+				addrB:     newAccount(0, 50, nil, nil),
+				addrEmpty: newAccount(0, 0, nil, nil),
+			},
+		},
+		{
+			name: "re-created account",
+			apply: func(db vm.StateDB) {
+				db.CreateAccount(addrC)
+				db.SetNonce(addrC, 1)
+				db.SetCode(addrC, []byte{0x00})
+				db.SetState(addrC, common.Hash{3}, common.Hash{3})
+			},
+			want: substate.SubstateAlloc{
+				addrA:     newAccount(1, 100, nil, nil),
+				addrB:     newAccount(0, 50, nil, nil),
+				addrC:     newAccount(1, 0, []byte{0x00}, map[common.Hash]common.Hash{{3}: {3}}),
+				addrEmpty: newAccount(0, 0, nil, nil),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alloc := makeTestAlloc()
+			inMemory := MakeInMemoryStateDB(&alloc, 1)
+			test.apply(inMemory)
+			offTheChain := MakeOffTheChainStateDB(makeTestAlloc())
+			test.apply(offTheChain)
+
+			want := ComputeStateRoot(test.want, false)
+			if got := offTheChain.IntermediateRoot(true); got != want {
+				t.Fatalf("unexpected root of the off-the-chain StateDB, wanted %v, got %v", want, got)
+			}
+			if got := inMemory.IntermediateRoot(true); got != want {
+				t.Errorf("unexpected root, wanted %v, got %v", want, got)
+			}
+			// a second call must not see the effects of the first one
+			if got := inMemory.IntermediateRoot(true); got != want {
+				t.Errorf("unexpected root of the second call, wanted %v, got %v", want, got)
+			}
+		})
+	}
+}
@@ -140,3 +140,18 @@ func MakeOffTheChainStateDB(alloc substate.SubstateAlloc) *state.StateDB {
 	}
 	return statedb
 }
+
+// ComputeStateRoot returns the root of the state trie holding the accounts of
+// the given allocation.
+func ComputeStateRoot(alloc substate.SubstateAlloc, deleteEmptyObjects bool) common.Hash {
+	statedb := NewOffTheChainStateDB()
+	for addr, a := range alloc {
+		statedb.SetPrehashedCode(addr, getHash(addr, a.Code), a.Code)
+		statedb.SetNonce(addr, a.Nonce)
+		statedb.SetBalance(addr, a.Balance)
+		for k, v := range a.Storage {
+			statedb.SetState(addr, k, v)
+		}
+	}
+	return statedb.IntermediateRoot(deleteEmptyObjects)
+}
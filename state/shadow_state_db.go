@@ -0,0 +1,395 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// ShadowStateDB is a StateDB forwarding all operations to a prime and a
+// shadow StateDB implementation and comparing their observable behaviour.
+type ShadowStateDB interface {
+	StateDB
+	// GetMismatch returns the first disagreement between the two
+	// implementations, or nil if they agreed so far.
+	GetMismatch() *StateDBMismatchError
+}
+
+// StateDBMismatchError describes the first call on which the prime and the
+// shadow StateDB disagreed, including the sequence of calls leading to it.
+type StateDBMismatchError struct {
+	Method string
+	Call   string
+	Prime  string
+	Shadow string
+	Calls  []string // all calls up to and including the mismatching one
+}
+
+func (e *StateDBMismatchError) Error() string {
+	return fmt.Sprintf("state DB mismatch in %v: call #%d %v: prime %v, shadow %v", e.Method, len(e.Calls), e.Call, e.Prime, e.Shadow)
+}
+
+// PrintCalls prints the sequence of calls leading to the mismatch.
+func (e *StateDBMismatchError) PrintCalls() {
+	for i, call := range e.Calls {
+		fmt.Printf("    #%d %v\n", i+1, call)
+	}
+}
+
+// MakeShadowStateDB creates a StateDB running every operation on both given
+// implementations. The results of the prime StateDB are handed to the caller,
+// the results of the shadow StateDB are only compared against them.
+func MakeShadowStateDB(prime, shadow StateDB) ShadowStateDB {
+	return &shadowStateDB{prime: prime, shadow: shadow, snapshots: map[int]int{}}
+}
+
+type shadowStateDB struct {
+	prime     StateDB
+	shadow    StateDB
+	snapshots map[int]int // prime snapshot id -> shadow snapshot id
+	calls     []string
+	mismatch  *StateDBMismatchError
+}
+
+func (db *shadowStateDB) GetMismatch() *StateDBMismatchError {
+	return db.mismatch
+}
+
+// record appends a call to the call sequence, as long as no mismatch was found.
+func (db *shadowStateDB) record(method string, args ...any) string {
+	if db.mismatch != nil {
+		return ""
+	}
+	params := make([]string, 0, len(args))
+	for _, arg := range args {
+		params = append(params, fmt.Sprintf("%v", arg))
+	}
+	call := fmt.Sprintf("%v(%v)", method, strings.Join(params, ", "))
+	db.calls = append(db.calls, call)
+	return call
+}
+
+// check registers a mismatch of the given call if the results are not equal.
+func (db *shadowStateDB) check(method, call string, equal bool, prime, shadow any) {
+	if equal || db.mismatch != nil {
+		return
+	}
+	calls := make([]string, len(db.calls))
+	copy(calls, db.calls)
+	db.mismatch = &StateDBMismatchError{
+		Method: method,
+		Call:   call,
+		Prime:  fmt.Sprintf("%v", prime),
+		Shadow: fmt.Sprintf("%v", shadow),
+		Calls:  calls,
+	}
+}
+
+func (db *shadowStateDB) CreateAccount(addr common.Address) {
+	db.record("CreateAccount", addr)
+	db.prime.CreateAccount(addr)
+	db.shadow.CreateAccount(addr)
+}
+
+func (db *shadowStateDB) SubBalance(addr common.Address, value *big.Int) {
+	db.record("SubBalance", addr, value)
+	db.prime.SubBalance(addr, value)
+	db.shadow.SubBalance(addr, value)
+}
+
+func (db *shadowStateDB) AddBalance(addr common.Address, value *big.Int) {
+	db.record("AddBalance", addr, value)
+	db.prime.AddBalance(addr, value)
+	db.shadow.AddBalance(addr, value)
+}
+
+func (db *shadowStateDB) GetBalance(addr common.Address) *big.Int {
+	call := db.record("GetBalance", addr)
+	a, b := db.prime.GetBalance(addr), db.shadow.GetBalance(addr)
+	db.check("GetBalance", call, a.Cmp(b) == 0, a, b)
+	return a
+}
+
+func (db *shadowStateDB) GetNonce(addr common.Address) uint64 {
+	call := db.record("GetNonce", addr)
+	a, b := db.prime.GetNonce(addr), db.shadow.GetNonce(addr)
+	db.check("GetNonce", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) SetNonce(addr common.Address, value uint64) {
+	db.record("SetNonce", addr, value)
+	db.prime.SetNonce(addr, value)
+	db.shadow.SetNonce(addr, value)
+}
+
+func (db *shadowStateDB) GetCodeHash(addr common.Address) common.Hash {
+	call := db.record("GetCodeHash", addr)
+	a, b := db.prime.GetCodeHash(addr), db.shadow.GetCodeHash(addr)
+	db.check("GetCodeHash", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) GetCode(addr common.Address) []byte {
+	call := db.record("GetCode", addr)
+	a, b := db.prime.GetCode(addr), db.shadow.GetCode(addr)
+	db.check("GetCode", call, bytes.Equal(a, b), common.Bytes2Hex(a), common.Bytes2Hex(b))
+	return a
+}
+
+func (db *shadowStateDB) SetCode(addr common.Address, code []byte) {
+	db.record("SetCode", addr, common.Bytes2Hex(code))
+	db.prime.SetCode(addr, code)
+	db.shadow.SetCode(addr, code)
+}
+
+func (db *shadowStateDB) GetCodeSize(addr common.Address) int {
+	call := db.record("GetCodeSize", addr)
+	a, b := db.prime.GetCodeSize(addr), db.shadow.GetCodeSize(addr)
+	db.check("GetCodeSize", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) AddRefund(gas uint64) {
+	db.record("AddRefund", gas)
+	db.prime.AddRefund(gas)
+	db.shadow.AddRefund(gas)
+}
+
+func (db *shadowStateDB) SubRefund(gas uint64) {
+	db.record("SubRefund", gas)
+	db.prime.SubRefund(gas)
+	db.shadow.SubRefund(gas)
+}
+
+func (db *shadowStateDB) GetRefund() uint64 {
+	call := db.record("GetRefund")
+	a, b := db.prime.GetRefund(), db.shadow.GetRefund()
+	db.check("GetRefund", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	call := db.record("GetCommittedState", addr, key)
+	a, b := db.prime.GetCommittedState(addr, key), db.shadow.GetCommittedState(addr, key)
+	db.check("GetCommittedState", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	call := db.record("GetState", addr, key)
+	a, b := db.prime.GetState(addr, key), db.shadow.GetState(addr, key)
+	db.check("GetState", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	db.record("SetState", addr, key, value)
+	db.prime.SetState(addr, key, value)
+	db.shadow.SetState(addr, key, value)
+}
+
+func (db *shadowStateDB) Suicide(addr common.Address) bool {
+	call := db.record("Suicide", addr)
+	a, b := db.prime.Suicide(addr), db.shadow.Suicide(addr)
+	db.check("Suicide", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) HasSuicided(addr common.Address) bool {
+	call := db.record("HasSuicided", addr)
+	a, b := db.prime.HasSuicided(addr), db.shadow.HasSuicided(addr)
+	db.check("HasSuicided", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) Exist(addr common.Address) bool {
+	call := db.record("Exist", addr)
+	a, b := db.prime.Exist(addr), db.shadow.Exist(addr)
+	db.check("Exist", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) Empty(addr common.Address) bool {
+	call := db.record("Empty", addr)
+	a, b := db.prime.Empty(addr), db.shadow.Empty(addr)
+	db.check("Empty", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
+	db.record("PrepareAccessList", sender, dest, precompiles, txAccesses)
+	db.prime.PrepareAccessList(sender, dest, precompiles, txAccesses)
+	db.shadow.PrepareAccessList(sender, dest, precompiles, txAccesses)
+}
+
+func (db *shadowStateDB) AddressInAccessList(addr common.Address) bool {
+	call := db.record("AddressInAccessList", addr)
+	a, b := db.prime.AddressInAccessList(addr), db.shadow.AddressInAccessList(addr)
+	db.check("AddressInAccessList", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) SlotInAccessList(addr common.Address, key common.Hash) (addressOk bool, slotOk bool) {
+	call := db.record("SlotInAccessList", addr, key)
+	a1, a2 := db.prime.SlotInAccessList(addr, key)
+	b1, b2 := db.shadow.SlotInAccessList(addr, key)
+	db.check("SlotInAccessList", call, a1 == b1 && a2 == b2, fmt.Sprintf("(%v, %v)", a1, a2), fmt.Sprintf("(%v, %v)", b1, b2))
+	return a1, a2
+}
+
+func (db *shadowStateDB) AddAddressToAccessList(addr common.Address) {
+	db.record("AddAddressToAccessList", addr)
+	db.prime.AddAddressToAccessList(addr)
+	db.shadow.AddAddressToAccessList(addr)
+}
+
+func (db *shadowStateDB) AddSlotToAccessList(addr common.Address, key common.Hash) {
+	db.record("AddSlotToAccessList", addr, key)
+	db.prime.AddSlotToAccessList(addr, key)
+	db.shadow.AddSlotToAccessList(addr, key)
+}
+
+func (db *shadowStateDB) RevertToSnapshot(id int) {
+	call := db.record("RevertToSnapshot", id)
+	shadowId, exists := db.snapshots[id]
+	db.check("RevertToSnapshot", call, exists, id, "unknown snapshot")
+	db.prime.RevertToSnapshot(id)
+	if exists {
+		db.shadow.RevertToSnapshot(shadowId)
+	}
+}
+
+func (db *shadowStateDB) Snapshot() int {
+	db.record("Snapshot")
+	id := db.prime.Snapshot()
+	db.snapshots[id] = db.shadow.Snapshot()
+	return id
+}
+
+func (db *shadowStateDB) AddLog(log *types.Log) {
+	db.record("AddLog", log.Address, log.Topics, common.Bytes2Hex(log.Data))
+	db.prime.AddLog(log)
+	// the StateDB may annotate the log, thus the shadow gets its own copy
+	shadowLog := *log
+	db.shadow.AddLog(&shadowLog)
+}
+
+func (db *shadowStateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	db.record("AddPreimage", hash, common.Bytes2Hex(preimage))
+	db.prime.AddPreimage(hash, preimage)
+	db.shadow.AddPreimage(hash, preimage)
+}
+
+func (db *shadowStateDB) ForEachStorage(addr common.Address, cb func(common.Hash, common.Hash) bool) error {
+	call := db.record("ForEachStorage", addr)
+	// the callback only sees the slots of the prime StateDB; slots are only
+	// compared if the callback did not stop the iteration early
+	stopped := false
+	a := map[common.Hash]common.Hash{}
+	err := db.prime.ForEachStorage(addr, func(key, value common.Hash) bool {
+		a[key] = value
+		stopped = !cb(key, value)
+		return !stopped
+	})
+	b := map[common.Hash]common.Hash{}
+	if err := db.shadow.ForEachStorage(addr, func(key, value common.Hash) bool {
+		b[key] = value
+		return true
+	}); err != nil {
+		return err
+	}
+	// unlike the geth StateDB iterating its storage trie, the in-memory
+	// StateDB also reports slots written by the transaction and no slots of
+	// destructed accounts; the slots of the prime StateDB are compared only
+	if !stopped && !db.prime.HasSuicided(addr) {
+		db.check("ForEachStorage", call, coversStorage(b, a), fmt.Sprintf("%d slots", len(a)), fmt.Sprintf("%d slots", len(b)))
+	}
+	return err
+}
+
+func (db *shadowStateDB) Prepare(thash common.Hash, ti int) {
+	db.record("Prepare", thash, ti)
+	db.prime.Prepare(thash, ti)
+	db.shadow.Prepare(thash, ti)
+}
+
+func (db *shadowStateDB) Finalise(deleteEmptyObjects bool) {
+	db.record("Finalise", deleteEmptyObjects)
+	db.prime.Finalise(deleteEmptyObjects)
+	db.shadow.Finalise(deleteEmptyObjects)
+}
+
+func (db *shadowStateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
+	call := db.record("IntermediateRoot", deleteEmptyObjects)
+	a, b := db.prime.IntermediateRoot(deleteEmptyObjects), db.shadow.IntermediateRoot(deleteEmptyObjects)
+	db.check("IntermediateRoot", call, a == b, a, b)
+	return a
+}
+
+func (db *shadowStateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
+	db.record("Commit", deleteEmptyObjects)
+	if _, err := db.shadow.Commit(deleteEmptyObjects); err != nil {
+		return common.Hash{}, err
+	}
+	return db.prime.Commit(deleteEmptyObjects)
+}
+
+func (db *shadowStateDB) GetLogs(txHash common.Hash, blockHash common.Hash) []*types.Log {
+	call := db.record("GetLogs", txHash, blockHash)
+	a, b := db.prime.GetLogs(txHash, blockHash), db.shadow.GetLogs(txHash, blockHash)
+	db.check("GetLogs", call, equalLogs(a, b), formatLogs(a), formatLogs(b))
+	return a
+}
+
+func (db *shadowStateDB) GetSubstatePostAlloc() substate.SubstateAlloc {
+	call := db.record("GetSubstatePostAlloc")
+	a, b := db.prime.GetSubstatePostAlloc(), db.shadow.GetSubstatePostAlloc()
+	db.check("GetSubstatePostAlloc", call, a.Equal(b), fmt.Sprintf("%d accounts", len(a)), fmt.Sprintf("%d accounts", len(b)))
+	return a
+}
+
+// equalLogs compares the content of logs, ignoring the annotations added by
+// a StateDB such as transaction hashes and indices.
+func equalLogs(a, b []*types.Log) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Address != b[i].Address || !bytes.Equal(a[i].Data, b[i].Data) || len(a[i].Topics) != len(b[i].Topics) {
+			return false
+		}
+		for j := range a[i].Topics {
+			if a[i].Topics[j] != b[i].Topics[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// coversStorage reports whether a holds all non-zero slots of b.
+func coversStorage(a, b map[common.Hash]common.Hash) bool {
+	for key, value := range b {
+		if value == (common.Hash{}) {
+			continue
+		}
+		if other, exists := a[key]; !exists || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func formatLogs(logs []*types.Log) string {
+	res := make([]string, 0, len(logs))
+	for _, log := range logs {
+		res = append(res, fmt.Sprintf("{%v %v %v}", log.Address, log.Topics, common.Bytes2Hex(log.Data)))
+	}
+	return "[" + strings.Join(res, " ") + "]"
+}
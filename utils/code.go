@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// ContainsOpCode reports whether one of the given instructions appears in
+// code, skipping the immediate data of push instructions.
+func ContainsOpCode(code []byte, ops map[vm.OpCode]bool) bool {
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		if ops[op] {
+			return true
+		}
+		if op.IsPush() {
+			pc += int(op - vm.PUSH1 + 1)
+		}
+	}
+	return false
+}
+
+// MayExecuteOpCode reports whether the init code of a substate or the code of
+// an account in its input alloc contains one of the given instructions. The
+// instructions are not necessarily executed by the transaction.
+func MayExecuteOpCode(st *substate.Substate, ops map[vm.OpCode]bool) bool {
+	if st.Message.To == nil && ContainsOpCode(st.Message.Data, ops) {
+		return true
+	}
+	for _, account := range st.InputAlloc {
+		if ContainsOpCode(account.Code, ops) {
+			return true
+		}
+	}
+	return false
+}
+
+var blockHashOps = map[vm.OpCode]bool{vm.BLOCKHASH: true}
+
+// MayExecuteBlockHash reports whether a substate may execute BLOCKHASH, which
+// requires the block hashes to be recorded in its environment.
+func MayExecuteBlockHash(st *substate.Substate) bool {
+	return MayExecuteOpCode(st, blockHashOps)
+}
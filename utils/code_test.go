@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+func TestContainsOpCodeSkipsPushData(t *testing.T) {
+	ops := map[vm.OpCode]bool{vm.BLOCKHASH: true}
+	tests := []struct {
+		code []byte
+		want bool
+	}{
+		{code: nil, want: false},
+		{code: []byte{byte(vm.PUSH1), 0x01, byte(vm.BLOCKHASH)}, want: true},
+		{code: []byte{byte(vm.PUSH1), byte(vm.BLOCKHASH)}, want: false},
+		{code: []byte{byte(vm.PUSH2), 0x00, byte(vm.BLOCKHASH), byte(vm.STOP)}, want: false},
+		// truncated push data
+		{code: []byte{byte(vm.PUSH32), byte(vm.BLOCKHASH)}, want: false},
+	}
+	for _, test := range tests {
+		if got := ContainsOpCode(test.code, ops); got != test.want {
+			t.Errorf("unexpected result for code %x, wanted %v, got %v", test.code, test.want, got)
+		}
+	}
+}
+
+func TestMayExecuteBlockHash(t *testing.T) {
+	blockHash := []byte{byte(vm.PUSH1), 0x01, byte(vm.BLOCKHASH)}
+	to := common.Address{0xb}
+	create := &substate.Substate{
+		InputAlloc: substate.SubstateAlloc{},
+		Message:    &substate.SubstateMessage{Data: blockHash},
+	}
+	if !MayExecuteBlockHash(create) {
+		t.Errorf("BLOCKHASH in init code was not found")
+	}
+	call := &substate.Substate{
+		InputAlloc: substate.SubstateAlloc{to: substate.NewSubstateAccount(0, big.NewInt(0), blockHash)},
+		Message:    &substate.SubstateMessage{To: &to, Data: blockHash},
+	}
+	if !MayExecuteBlockHash(call) {
+		t.Errorf("BLOCKHASH in code of the input alloc was not found")
+	}
+	call.InputAlloc[to] = substate.NewSubstateAccount(0, big.NewInt(0), nil)
+	if MayExecuteBlockHash(call) {
+		t.Errorf("call data was taken for code")
+	}
+}
+
+func TestGetTxType(t *testing.T) {
+	contract, account := common.Address{0xc}, common.Address{0xa}
+	alloc := substate.SubstateAlloc{
+		contract: substate.NewSubstateAccount(1, big.NewInt(0), []byte{byte(vm.STOP)}),
+		account:  substate.NewSubstateAccount(1, big.NewInt(0), nil),
+	}
+	missing := common.Address{0xd}
+	tests := []struct {
+		to   *common.Address
+		want string
+	}{
+		{to: nil, want: "create"},
+		{to: &contract, want: "call"},
+		{to: &account, want: "transfer"},
+		{to: &missing, want: "transfer"},
+	}
+	for _, test := range tests {
+		if got := GetTxType(test.to, alloc); got != test.want {
+			t.Errorf("unexpected type of transaction to %v, wanted %v, got %v", test.to, test.want, got)
+		}
+	}
+}
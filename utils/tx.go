@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/substate"
+)
+
+// GetTxType classifies a transaction as create, transfer or call by its
+// recipient and the code of the recipient in the input alloc.
+func GetTxType(to *common.Address, alloc substate.SubstateAlloc) string {
+	if to == nil {
+		return "create"
+	}
+	account, hasReceiver := alloc[*to]
+	if to != nil && (!hasReceiver || len(account.Code) == 0) {
+		return "transfer"
+	}
+	if to != nil && (hasReceiver && len(account.Code) > 0) {
+		return "call"
+	}
+	return "unknown"
+}